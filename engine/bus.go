@@ -0,0 +1,124 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Bus 把事件分发给多个订阅者。Publish 不会阻塞引擎：
+// 订阅者的缓冲区满时事件被丢弃，并计入 Dropped。
+type Bus struct {
+	mu      sync.Mutex
+	subs    map[chan Event]struct{}
+	dropped uint64
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: make(map[chan Event]struct{})}
+}
+
+// Publish 可直接用作 Options.OnEvent
+func (b *Bus) Publish(e Event) {
+	b.mu.Lock()
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			atomic.AddUint64(&b.dropped, 1)
+		}
+	}
+	b.mu.Unlock()
+}
+
+// Subscribe 返回缓冲区为 n 的事件通道，调用 cancel 取消订阅并关闭通道
+func (b *Bus) Subscribe(n int) (events <-chan Event, cancel func()) {
+	ch := make(chan Event, n)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Dropped 返回因订阅者处理不及时而丢弃的事件数
+func (b *Bus) Dropped() uint64 {
+	return atomic.LoadUint64(&b.dropped)
+}
+
+// WriteJSON 把事件逐行写成 JSON，直到通道关闭或写入出错
+func WriteJSON(w io.Writer, events <-chan Event) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for e := range events {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+		// 通道中没有积压时立即刷新，方便 tail -f
+		if len(events) == 0 {
+			if err := bw.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// ServeJSON 每个连接到 l 的客户端都会收到之后的全部事件
+func (b *Bus) ServeJSON(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			events, cancel := b.Subscribe(1024)
+			go func() {
+				// 客户端断开时结束订阅
+				io.Copy(io.Discard, conn)
+				cancel()
+			}()
+			WriteJSON(conn, events)
+			cancel()
+			conn.Close()
+		}()
+	}
+}
+
+// OutputJSON 按 target 输出 JSON 事件流：
+// unix:/path 或 tcp:host:port 监听套接字，其他视为追加写入的文件路径
+func (b *Bus) OutputJSON(target string) error {
+	if network, addr, ok := strings.Cut(target, ":"); ok && (network == "unix" || network == "tcp") {
+		if network == "unix" {
+			os.Remove(addr)
+		}
+		l, err := net.Listen(network, addr)
+		if err != nil {
+			return err
+		}
+		go b.ServeJSON(l)
+		return nil
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	events, _ := b.Subscribe(4096)
+	go func() {
+		WriteJSON(f, events)
+		f.Close()
+	}()
+	return nil
+}
@@ -0,0 +1,44 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestBus(t *testing.T) {
+	b := NewBus()
+	events, cancel := b.Subscribe(1)
+	task := &DownloadTask{filename: "a.rar", page: "p1"}
+	b.Publish(Event{Kind: EventRangeDone, Task: task, Proxy: "1.2.3.4", Start: 10, End: 20})
+	b.Publish(Event{Kind: EventProgress}) // 缓冲区已满，被丢弃
+	if b.Dropped() != 1 {
+		t.Errorf("dropped = %d", b.Dropped())
+	}
+	cancel()
+
+	var buf bytes.Buffer
+	if err := WriteJSON(&buf, events); err != nil {
+		t.Fatal(err)
+	}
+	var v map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &v); err != nil {
+		t.Fatal(err, buf.String())
+	}
+	if v["kind"] != "range-done" || v["file"] != "a.rar" || v["proxy"] != "1.2.3.4" || v["end"] != 20.0 {
+		t.Errorf("unexpected json %s", buf.String())
+	}
+
+	buf.Reset()
+	e := Event{Kind: EventFailed, Err: errors.New("boom")}
+	json.NewEncoder(&buf).Encode(e)
+	var back struct {
+		Kind  EventKind
+		Error string
+	}
+	json.Unmarshal(buf.Bytes(), &back)
+	if back.Kind != EventFailed || back.Error != "boom" {
+		t.Errorf("round trip %+v", back)
+	}
+}
@@ -0,0 +1,176 @@
+// Package engine 多代理分段下载引擎。
+//
+// Client 保存配置和解析下载地址用的 http.Client，Session 是一次运行：
+// 按顺序下载队列中的任务，每个代理一个 goroutine 分担当前任务的分片。
+// 任务的开始、进度和完成通过 Options.OnEvent 通知调用方。
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"downloader/layout"
+	"downloader/logx"
+)
+
+// Policy 分片的分配策略
+type Policy int
+
+const (
+	// PolicySplit 优先空闲的分片，否则对半分拆最大的未下载段，数据分散到达
+	PolicySplit Policy = iota
+	// PolicySequential 保持从文件开头连续下载的前沿，前沿之后 ReadAhead 字节内的
+	// 分片优先分配，窗口内都有代理时其余代理按 PolicySplit 填补更后面的空缺
+	PolicySequential
+)
+
+const defaultReadAhead = 8 << 20
+
+// Options Client 的配置
+type Options struct {
+	Layout   layout.Layout // 输出路径
+	Prealloc bool          // 预分配文件空间而不是创建稀疏文件
+
+	Policy    Policy
+	ReadAhead int64 // PolicySequential 的预读窗口，默认 8MB
+
+	// Resolve 由页面地址解析出直链，失败返回空字符串，默认解析 rosefile 页面
+	Resolve func(webURL string) string
+	// OnPage 默认解析时收到下载页面后调用，可从页面文本中找出解压密码等信息
+	OnPage func(webURL, text string)
+	// Logger 为 nil 时不输出日志，每个代理的日志在其基础上加 proxy 字段
+	Logger *logx.Logger
+	// OnEvent 在引擎的 goroutine 中同步调用，不应阻塞。
+	// EventStarted、EventFailed、EventSkipped 以及任务初始化时的 EventResolved
+	// 调用时持有 Session 的锁，不能在其中调用 Session 的方法
+	OnEvent func(Event)
+}
+
+// Client 下载引擎的入口
+type Client struct {
+	opts Options
+	http *http.Client
+}
+
+func NewClient(opts Options) *Client {
+	c := &Client{opts: opts}
+	if c.opts.Logger == nil {
+		c.opts.Logger = logx.Discard
+	}
+	if c.opts.Layout.Root == "" {
+		c.opts.Layout.Root = "."
+	}
+	if c.opts.ReadAhead <= 0 {
+		c.opts.ReadAhead = defaultReadAhead
+	}
+	c.http = &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return c
+}
+
+// NewSession 创建一个空的下载队列
+func (c *Client) NewSession() *Session {
+	s := &Session{c: c, log: c.opts.Logger}
+	s.disk = newDiskGuard(s)
+	return s
+}
+
+func (c *Client) resolve(webURL string) string {
+	if c.opts.Resolve != nil {
+		return c.opts.Resolve(webURL)
+	}
+	return c.getFileURL(webURL)
+}
+
+// getFileURL 解析 rosefile 页面，失败时不断重试
+func (c *Client) getFileURL(url string) string {
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Set("User-Agent", UA)
+	var resp *http.Response
+	var err error
+	var body []byte
+	var sleepIntv time.Duration
+	for {
+		if err != nil {
+			c.opts.Logger.Warn("解析页面失败", logx.F("url", url), logx.Err(err))
+			sleepIntv += 3 * time.Second
+			time.Sleep(sleepIntv)
+		}
+		resp, err = c.http.Do(req)
+		if err != nil {
+			continue
+		}
+		body, err = io.ReadAll(resp.Body)
+		if err != nil {
+			continue
+		}
+		if resp.StatusCode != 200 {
+			err = errors.New("resp:" + resp.Status)
+			continue
+		}
+		if c.opts.OnPage != nil {
+			c.opts.OnPage(url, string(body))
+		}
+		i := bytes.Index(body, ([]byte)("// is open ref count\nadd_ref"))
+		if i == -1 {
+			err = errors.New("failed to split fileid " + url)
+			continue
+		}
+		body = body[i+29-31:]
+		copy(body, "action=load_down_addr1&file_id=")
+		i = bytes.IndexByte(body, ')')
+		body = body[:i]
+		req, _ = http.NewRequest("POST", "https://rosefile.net/ajax.php", bytes.NewReader(body))
+		req.Header.Set("Referer", url)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("User-Agent", UA)
+		resp, err = c.http.Do(req)
+		if err != nil {
+			panic(err)
+		}
+		body, err = io.ReadAll(resp.Body)
+		i = bytes.IndexByte(body, '"')
+		if i == -1 {
+			err = errors.New("failed to split fileurl " + url)
+			continue
+		}
+		body = body[i+1:]
+		i = bytes.IndexByte(body, '"')
+
+		return string(body[:i])
+	}
+}
+
+func (c *Client) contentLength(url string) (length uint64, err error) {
+	var req *http.Request
+	var resp *http.Response
+	req, err = http.NewRequest("HEAD", url, nil)
+	if err != nil {
+		return
+	}
+	//req.Header.Set("Content-Range", "0-")
+	req.Header.Set("User-Agent", UA)
+	req.Header.Set("Referer", "https://rosefile.net")
+	for i := 0; i < 5; i++ {
+		resp, err = c.http.Do(req)
+		if err != nil {
+			continue
+		}
+	}
+	if err != nil {
+		return
+	}
+	if resp.StatusCode != 200 {
+		err = fmt.Errorf("unexpected resp: %d", resp.StatusCode)
+		return
+	}
+	//length, _ = parseCode(resp.Header.Get("Content-Length"))
+	return uint64(resp.ContentLength), err
+}
@@ -0,0 +1,95 @@
+package engine
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+
+	"downloader/logx"
+)
+
+// diskReserve 磁盘上始终保留的空间，避免写满后连 .stat 都无法保存
+const diskReserve = 64 << 20
+
+// diskChunk 恢复下载所需的空间（不含保留空间），任务剩余的字节更少时按剩余的计算，
+// 大文件不必等到整个文件都放得下
+const diskChunk = 32 << 20
+
+// diskGuard 在磁盘写满时暂停所有任务，空间恢复后统一继续
+type diskGuard struct {
+	sync.Mutex
+	cond   *sync.Cond
+	paused bool
+	log    *logx.Logger
+}
+
+func newDiskGuard(s *Session) *diskGuard {
+	d := &diskGuard{log: s.log}
+	d.cond = sync.NewCond(d)
+	return d
+}
+
+// wait 阻塞直到磁盘不再处于暂停状态
+func (d *diskGuard) wait() {
+	d.Lock()
+	for d.paused {
+		d.cond.Wait()
+	}
+	d.Unlock()
+}
+
+// pause 暂停所有任务，直到 t 所在分区重新有足够的空间
+func (d *diskGuard) pause(t *DownloadTask, need int64) {
+	d.Lock()
+	if !d.paused {
+		d.paused = true
+		free, _ := freeSpace(taskDir(t))
+		d.log.Warn("磁盘空间不足，暂停所有任务",
+			logx.F("task", t.filename), logx.F("bytes", need), logx.F("free", free))
+		if t.stat != nil {
+			t.SaveStat()
+		}
+		if need > diskChunk {
+			need = diskChunk
+		}
+		go d.watch(t, need)
+	}
+	for d.paused {
+		d.cond.Wait()
+	}
+	d.Unlock()
+}
+
+// watch 等待分区上有 need 字节加保留空间后恢复所有任务
+func (d *diskGuard) watch(t *DownloadTask, need int64) {
+	for {
+		time.Sleep(30 * time.Second)
+		free, err := freeSpace(taskDir(t))
+		if err != nil || free >= need+diskReserve {
+			break
+		}
+	}
+	d.Lock()
+	d.paused = false
+	d.Unlock()
+	d.cond.Broadcast()
+	d.log.Info("磁盘空间已恢复，继续下载")
+}
+
+// checkSpace 检查 t 所在分区能否容纳 need 字节，不够时暂停所有任务。
+// 可能长时间阻塞，调用时不能持有 s.mu
+func (s *Session) checkSpace(t *DownloadTask, need int64) {
+	free, err := freeSpace(taskDir(t))
+	if err != nil || free >= need+diskReserve {
+		return
+	}
+	s.disk.pause(t, need)
+}
+
+func taskDir(t *DownloadTask) string {
+	dir := filepath.Dir(t.filename)
+	if dir == "" {
+		dir = "."
+	}
+	return dir
+}
@@ -0,0 +1,31 @@
+//go:build linux
+
+package engine
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// freeSpace 返回 dir 所在分区当前用户可用的字节数
+func freeSpace(dir string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
+
+// preallocate 用 fallocate 为文件实际分配 size 字节，文件系统不支持时退回稀疏文件
+func preallocate(f *os.File, size int64) error {
+	err := syscall.Fallocate(int(f.Fd()), 0, 0, size)
+	if err == syscall.EOPNOTSUPP || err == syscall.ENOSYS {
+		return f.Truncate(size)
+	}
+	return err
+}
+
+func isDiskFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT)
+}
@@ -0,0 +1,24 @@
+//go:build !linux && !windows
+
+package engine
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+var errNoStatfs = errors.New("当前系统不支持查询剩余空间")
+
+// freeSpace 无法获取时返回错误，跳过空间检查
+func freeSpace(dir string) (int64, error) {
+	return 0, errNoStatfs
+}
+
+func preallocate(f *os.File, size int64) error {
+	return f.Truncate(size)
+}
+
+func isDiskFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
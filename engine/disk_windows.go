@@ -0,0 +1,29 @@
+//go:build windows
+
+package engine
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+func freeSpace(dir string) (int64, error) {
+	path, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+	var avail, total, free uint64
+	err = windows.GetDiskFreeSpaceEx(path, &avail, &total, &free)
+	return int64(avail), err
+}
+
+// preallocate Windows 下 SetEndOfFile 即会分配空间
+func preallocate(f *os.File, size int64) error {
+	return f.Truncate(size)
+}
+
+func isDiskFull(err error) bool {
+	return errors.Is(err, windows.ERROR_DISK_FULL) || errors.Is(err, windows.ERROR_HANDLE_DISK_FULL)
+}
@@ -0,0 +1,581 @@
+package engine
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"downloader/layout"
+	"downloader/logx"
+	"downloader/metrics"
+	"downloader/statfile"
+)
+
+const freshInt = 2
+const flushInt = 10
+const speedWindow = 60
+
+// DownloadTask 一个文件的下载任务，由 Session.Add 创建
+// errSkipped init 按冲突策略跳过了任务
+var errSkipped = errors.New("任务已跳过")
+
+type DownloadTask struct {
+	webUrl string
+	page   string // 页面 id
+	name   string // 原始文件名，与 page 一起生成输出路径
+	s      *Session
+
+	Tag any // 调用方自定义数据
+
+	filename   string
+	f          *os.File
+	lastActive time.Time
+	header     *HttpHeader
+
+	stat   *os.File
+	ranges []*DownloadThread
+	length int64
+	remain int64
+	alloc  bool // 预分配成功，文件需要的空间已经占用
+	sync.Mutex
+	once uint32
+
+	ready   chan struct{} // init 结束或任务被跳过时关闭，之后可以读取文件
+	initErr error         // 任务无法开始的原因
+	want    int64         // TaskReader 等待的位置，-1 为没有，持有锁时读写
+
+	speeds [speedWindow / freshInt]int64
+	speedI uint16
+	speed  int64
+}
+
+type ThreadState byte
+
+type DownloadThread struct {
+	f        *os.File
+	cur, end int64
+	state    ThreadState
+	bytes    *metrics.Counter // 接收时按代理和任务统计写入的字节
+	proxy    string           // 正在下载该分片的代理
+}
+
+// Range 未下载的一段 [Start, End)，Proxy 为正在下载它的代理
+type Range struct {
+	Start, End int64
+	Proxy      string
+	Active     bool // 正在接收数据
+}
+
+const (
+	stateNoWork ThreadState = iota
+	stateReady
+	stateReceive
+)
+
+func (t *DownloadTask) initURL() (fName, fUrl string) {
+	fUrl = t.s.c.resolve(t.webUrl)
+	if fUrl == "" {
+		return
+	}
+	t.s.log.Debug("下载地址", logx.F("task", t.name), logx.F("url", fUrl))
+	t.s.emit(Event{Kind: EventResolved, Task: t, URL: fUrl})
+
+	t.header = NewHeader(fUrl)
+	i := strings.LastIndexByte(fUrl, '/')
+	if i != -1 {
+		fName = fUrl[i+1:]
+		if name, err := url.PathUnescape(fName); err == nil {
+			fName = name
+		}
+	}
+	return
+}
+
+// only once
+func (t *DownloadTask) init() (err error) {
+	defer func() {
+		if err == errSkipped {
+			t.setReady(nil)
+		} else {
+			t.setReady(err)
+		}
+	}()
+	filename, fUrl := t.initURL()
+	if fUrl == "" {
+		t.s.log.Error("解析下载地址失败", logx.F("url", t.webUrl))
+		err = errors.New("解析下载地址失败")
+		t.s.emit(Event{Kind: EventFailed, Task: t, Err: err})
+		return
+	}
+	if filename != t.name && filename != "" {
+		t.s.log.Warn("文件名不匹配", logx.F("task", t.name), logx.F("name", filename))
+		t.name = filename
+		// 与 nextTask 一样按冲突策略放置新文件名
+		name, ok := t.s.c.opts.Layout.Place(layout.Vars{Page: t.page, Name: filename}, Finished)
+		t.filename = name
+		if !ok {
+			t.s.log.Info("已下载，跳过", logx.F("task", name))
+			t.s.emit(Event{Kind: EventSkipped, Task: t})
+			return errSkipped
+		}
+	}
+	filename = t.filename
+	fail := func(err error) error {
+		t.s.log.Error("无法创建输出文件", logx.F("task", filename), logx.Err(err))
+		t.s.emit(Event{Kind: EventFailed, Task: t, Err: err})
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return fail(err)
+	}
+	t.f, err = os.OpenFile(filename, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fail(err)
+	}
+	fStat, err := t.f.Stat()
+	if err == nil {
+		t.length = fStat.Size()
+	}
+	stat, err := os.OpenFile(filename+".stat", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		t.f.Close()
+		t.f = nil
+		return fail(err)
+	}
+
+	saved, err := statfile.Parse(stat)
+	if err != nil {
+		t.s.log.Warn("分片文件格式错误，忽略无效的行", logx.F("task", filename), logx.Err(err))
+	}
+	for _, r := range saved {
+		t.ranges = append(t.ranges, &DownloadThread{cur: r.Start, end: r.End})
+	}
+	t.filename = filename
+	t.stat = stat
+	t.s.log.Info("任务开始", logx.F("task", t.filename), logx.F("ranges", len(t.ranges)))
+	var _len int64
+	_len, err = wrapI64(t.s.c.contentLength(fUrl))
+	if err != nil {
+		t.s.emit(Event{Kind: EventFailed, Task: t, Err: err})
+		return
+	}
+	if t.length != 0 && t.length != _len {
+		t.s.log.Warn("文件长度不一致", logx.F("task", t.filename), logx.F("bytes", _len), logx.F("local", t.length))
+	}
+	if _len > 1<<20 {
+		t.length = _len
+	}
+	t.s.log.Info("文件大小", logx.F("task", t.filename), logx.F("bytes", t.length))
+	if t.ranges == nil {
+		thread := new(DownloadThread)
+		thread.cur = 0
+		thread.end = t.length
+		t.ranges = append(t.ranges, thread)
+	} else {
+		for _, r := range t.ranges {
+			if r.end > t.length {
+				r.end = t.length
+			}
+		}
+	}
+	if t.s.c.opts.Prealloc {
+		if err = preallocate(t.f, _len); err != nil {
+			t.s.log.Warn("预分配失败，改用稀疏文件", logx.F("task", t.filename), logx.Err(err))
+			t.f.Truncate(_len)
+		} else {
+			t.alloc = true
+		}
+	} else {
+		t.f.Truncate(_len)
+	}
+	err = nil
+	t.s.emit(Event{Kind: EventStarted, Task: t})
+	go func() {
+		tick := time.NewTicker(freshInt * time.Second)
+		defer tick.Stop()
+		for range tick.C {
+			if len(t.ranges) == 0 {
+				t.remain = 0
+				t.f.Close()
+				t.stat.Close()
+				os.Remove(t.filename + ".stat")
+				t.s.log.Info("任务完成", logx.F("task", t.filename))
+				t.s.emit(Event{Kind: EventCompleted, Task: t})
+				return
+			}
+			t.SaveStat()
+		}
+	}()
+	return
+}
+
+// setReady 记录 init 的结果，唤醒等待任务开始的 TaskReader
+func (t *DownloadTask) setReady(err error) {
+	t.initErr = err
+	close(t.ready)
+}
+
+// Filename 返回输出文件路径
+func (t *DownloadTask) Filename() string { return t.filename }
+
+// Name 返回原始文件名
+func (t *DownloadTask) Name() string { return t.name }
+
+// Page 返回页面 id
+func (t *DownloadTask) Page() string { return t.page }
+
+// WebURL 返回页面地址
+func (t *DownloadTask) WebURL() string { return t.webUrl }
+
+// Length 返回文件长度，任务开始前为 0
+func (t *DownloadTask) Length() int64 { return t.length }
+
+// Pending 返回尚未下载的字节数
+func (t *DownloadTask) Pending() (n int64) {
+	t.Lock()
+	for _, r := range t.ranges {
+		if r.cur < r.end {
+			n += r.end - r.cur
+		}
+	}
+	t.Unlock()
+	return
+}
+
+// Ranges 返回尚未下载的范围，按起点排序
+func (t *DownloadTask) Ranges() []Range {
+	t.Lock()
+	rs := make([]Range, 0, len(t.ranges))
+	for _, r := range t.ranges {
+		if r.cur >= r.end {
+			continue
+		}
+		rs = append(rs, Range{Start: r.cur, End: r.end, Proxy: r.proxy, Active: r.state == stateReceive})
+	}
+	t.Unlock()
+	sort.Slice(rs, func(i, j int) bool { return rs[i].Start < rs[j].Start })
+	return rs
+}
+
+func (t *DownloadTask) SaveStat() {
+	var remain int64
+	var saved []statfile.Range
+	active := 0
+	t.Lock()
+	frontier := t.available(0, t.length)
+	for _, r := range t.ranges {
+		if r.cur > r.end {
+			continue
+		}
+		saved = append(saved, statfile.Range{Start: r.cur, End: r.end, Active: r.state == stateReceive})
+		if r.state == stateReceive {
+			active++
+		}
+		remain += r.end - r.cur
+	}
+	t.Unlock()
+	// TODO 窗口速度
+	/*	delta := (t.remain - remain) / freshInt
+		t.speeds[t.speedI] = delta
+		t.speedI++
+		if t.speedI == speedWindow/freshInt {
+
+		}
+		t.speed += delta */
+	if t.remain >= remain {
+		t.s.emit(Event{
+			Kind:     EventProgress,
+			Task:     t,
+			Done:     t.length - remain,
+			Total:    t.length,
+			Speed:    (t.remain - remain) / freshInt,
+			Active:   active,
+			Frontier: frontier,
+		})
+	}
+	t.remain = remain
+	if len(saved) == 0 {
+		t.ranges = nil
+		return
+	}
+	buf := statfile.Format(saved)
+	t.stat.Seek(0, 0)
+	t.stat.Truncate(int64(len(buf)))
+	t.stat.Write(buf)
+	//t.stat.Sync()
+}
+
+func (t *DownloadTask) Go(dialer *sysDialer, raddr *net.TCPAddr, logger *logx.Logger) (err error) {
+	thread, _ := t.getThread()
+	if thread == nil {
+		return
+	}
+	logger.Debug("子任务开始", logx.F("task", t.filename), logx.F("range", thread))
+	proxy, start := dialer.address, thread.cur
+	thread.proxy = proxy
+	if thread.cur < thread.end {
+		t.s.emit(Event{Kind: EventRangeAssigned, Task: t, Proxy: proxy, Start: thread.cur, End: thread.end})
+		var conn *net.TCPConn
+		begin := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		conn, err = dialer.dialTCP(ctx, nil, raddr)
+		cancel()
+		if err == nil {
+			mDial.With(proxy).Observe(time.Since(begin).Seconds())
+			thread.bytes = mBytes.With(proxy, t.name)
+			err = t.run(conn, thread, proxy)
+		}
+		conn.Close()
+		mRequest.With(proxy).Observe(time.Since(begin).Seconds())
+	} else {
+		logger.Debug("cur >= end, skip", logx.F("task", t.filename), logx.F("range", thread))
+	}
+
+	t.Lock()
+	var pos int
+	var tag, mergedOrFinished bool
+	for i, r := range t.ranges {
+		if r == thread {
+			pos = i
+			if tag {
+				break
+			}
+			tag = true
+		}
+		if r.end+1 == thread.cur {
+			r.end = thread.end
+			mergedOrFinished = true
+			if tag {
+				break
+			}
+			tag = true
+		}
+	}
+	if err == nil { // finished
+		mergedOrFinished = true
+		err = ErrNext
+		t.s.emit(Event{Kind: EventRangeDone, Task: t, Proxy: proxy, Start: start, End: thread.end})
+		logger.Debug("子任务完成", logx.F("task", t.filename), logx.F("range", thread), logx.F("bytes", thread.cur-start))
+	} else {
+		logger.Warn("子任务失败", logx.F("task", t.filename), logx.F("range", thread), logx.F("bytes", thread.cur-start), logx.Err(err))
+		mProxyErrors.With(proxy).Inc()
+		t.s.emit(Event{Kind: EventProxyError, Task: t, Proxy: proxy, Start: thread.cur, End: thread.end, Err: err})
+	}
+	if mergedOrFinished {
+		copy(t.ranges[pos:], t.ranges[pos+1:])
+		t.ranges = t.ranges[:len(t.ranges)-1]
+	}
+	if thread.state == stateReceive {
+		mActive.With(t.name).Dec()
+	}
+	thread.state = stateNoWork
+	thread.proxy = ""
+	t.Unlock()
+	return
+}
+
+func (t *DownloadTask) run(conn *net.TCPConn, thread *DownloadThread, proxy string) (err error) {
+	var stat uint64
+	begin := time.Now()
+	err = t.header.SendHeader(conn, thread.cur)
+	if err != nil {
+		return errors.New("发送请求失败 " + err.Error())
+	}
+	br := bufio.NewReader(conn)
+	stat, err = readHead(br)
+	mTTFB.With(proxy).Observe(time.Since(begin).Seconds())
+	mStatus.With(proxy, statusLabel(stat)).Inc()
+	if stat != 206 {
+		if stat == 200 {
+			t.initURL()
+			return fmt.Errorf("下载链接失效，刷新")
+		}
+		return fmt.Errorf("响应无效 %d %s", stat, err)
+	}
+	_len, _ := wrapI64(parseCode(err.Error()))
+	_len += thread.cur
+	if t.length != 0 && t.length != _len {
+		err = fmt.Errorf("文件长度不一致 %d!=%d", _len, t.length)
+		time.Sleep(25 * time.Second)
+		return err
+	}
+
+	thread.state = stateReceive // 不需要锁
+	mActive.With(t.name).Inc()
+	thread.f = t.f
+	br.WriteTo(thread)
+
+	err = thread.Download(conn)
+	return err
+}
+
+func (t *DownloadTask) getThread() (cur, prev *DownloadThread) {
+	cur = new(DownloadThread) // [0:0:0]
+	var l int64
+	t.Lock()
+	defer t.Unlock()
+	if len(t.ranges) == 0 {
+		return nil, nil
+	}
+	th := t.wantThread()
+	if th == nil && t.s != nil && t.s.c.opts.Policy == PolicySequential {
+		th = t.sequentialThread(t.s.c.opts.ReadAhead)
+	}
+	if th != nil {
+		th.state = stateReady
+		return th, nil
+	}
+	var i int
+	for ; i < len(t.ranges); i++ {
+		_len := t.ranges[i].end - t.ranges[i].cur
+		// 找最大的未下载段，取一半下载
+		if _len > l {
+			l = _len
+			prev = t.ranges[i]
+		}
+		if t.ranges[i].state == stateNoWork {
+			cur = t.ranges[i]
+			prev = nil
+			break
+		}
+	}
+	cur.state = stateReady
+	// 从已有片段分拆的新片段
+	if prev != nil {
+		if l > 64<<10 {
+			cur.cur = (prev.cur + prev.end) / 2
+			cur.end = prev.end
+			prev.end = cur.cur - 1
+			t.ranges = append(t.ranges, cur)
+		} else { // 分片过小
+			cur = nil
+		}
+	}
+	return
+}
+
+// wantThread 返回从 TaskReader 等待的位置开始下载的分片，调用时需持有锁。
+// 包含该位置的分片空闲时直接使用，正在下载但离该位置超过 wantGap 时从该位置分拆，
+// 否则返回 nil 按通常方式分配
+func (t *DownloadTask) wantThread() *DownloadThread {
+	if t.want < 0 {
+		return nil
+	}
+	for _, r := range t.ranges {
+		if t.want < r.cur || t.want > r.end {
+			continue
+		}
+		if r.state == stateNoWork {
+			return r
+		}
+		if t.want-r.cur <= wantGap || r.end-t.want <= 64<<10 {
+			return nil
+		}
+		cur := &DownloadThread{cur: t.want, end: r.end}
+		r.end = cur.cur - 1
+		t.ranges = append(t.ranges, cur)
+		return cur
+	}
+	return nil
+}
+
+// sequentialThread 返回前沿之后 window 字节内要下载的分片，调用时需持有锁。
+// 窗口内有空闲分片时取最靠前的，否则对半分拆窗口内剩余最长的分片，
+// 都小于 64K 时返回 nil 按通常方式分配
+func (t *DownloadTask) sequentialThread(window int64) *DownloadThread {
+	front := int64(-1)
+	for _, r := range t.ranges {
+		if r.cur < r.end && (front < 0 || r.cur < front) {
+			front = r.cur
+		}
+	}
+	if front < 0 {
+		return nil
+	}
+	limit := front + window
+	var idle, busy *DownloadThread
+	var busyLen int64
+	for _, r := range t.ranges {
+		if r.cur >= r.end || r.cur >= limit {
+			continue
+		}
+		if r.state == stateNoWork {
+			if idle == nil || r.cur < idle.cur {
+				idle = r
+			}
+			continue
+		}
+		end := r.end
+		if end > limit {
+			end = limit
+		}
+		if end-r.cur > busyLen {
+			busy, busyLen = r, end-r.cur
+		}
+	}
+	if idle != nil {
+		return idle
+	}
+	if busyLen <= 64<<10 {
+		return nil
+	}
+	cur := &DownloadThread{cur: busy.cur + busyLen/2, end: busy.end}
+	busy.end = cur.cur - 1
+	t.ranges = append(t.ranges, cur)
+	return cur
+}
+
+// Frontier 返回从文件开头起连续下载完成的字节数，顺序读取的调用方可以使用到这里。
+// 任务开始前为 0
+func (t *DownloadTask) Frontier() int64 {
+	t.Lock()
+	defer t.Unlock()
+	return t.available(0, t.length)
+}
+
+func (t *DownloadThread) ReadFrom(_ io.Reader) (n int64, err error) {
+	return 0, nil
+}
+
+func (t *DownloadThread) String() string {
+	return fmt.Sprintf("[%d:%d]", t.cur, t.end)
+}
+
+func (t *DownloadThread) Write(p []byte) (n int, err error) {
+	n, err = t.f.WriteAt(p, t.cur)
+	t.cur += int64(n)
+	t.addBytes(int64(n))
+	return
+}
+
+func (t *DownloadThread) addBytes(n int64) {
+	if t.bytes != nil {
+		t.bytes.Add(float64(n))
+	}
+}
+
+func wrapI64[V uint64, T any](v V, t T) (int64, T) {
+	return int64(v), t
+}
+
+// FormatSize 以 B/KB/MB/GB/TB 显示字节数
+func FormatSize(size int64) string {
+	ending := []string{" B", "KB", "MB", "GB", "TB"}
+	sf := float32(size)
+	n := 0
+	for sf > 1024 {
+		sf /= 1024
+		n++
+	}
+	return fmt.Sprintf("%.03f%s", sf, ending[n])
+}
@@ -1,6 +1,6 @@
 //go:build linux
 
-package main
+package engine
 
 import (
 	"net"
@@ -19,6 +19,14 @@ const (
 	onceRead = bufSize / 4
 )
 
+// splice 的计数器在每次系统调用时更新，预先取出，避免每次按标签查找
+var (
+	spliceInCalls  = mSpliceCalls.With("in")
+	spliceOutCalls = mSpliceCalls.With("out")
+	spliceInBytes  = mSpliceBytes.With("in")
+	spliceOutBytes = mSpliceBytes.With("out")
+)
+
 func (t *DownloadThread) Download(conn *net.TCPConn) (err error) {
 	conn.SetReadBuffer(128 << 10)
 	var file *os.File
@@ -46,6 +54,7 @@ func (t *DownloadThread) Download(conn *net.TCPConn) (err error) {
 		var buffered int64
 		// 从连接读到pipe
 		buffered, err = syscall.Splice(connFF.Sysfd, nil, wFF.Sysfd, nil, bufSize, spliceMove|spliceMore|spliceNonblock)
+		spliceInCalls.Inc()
 		if buffered <= 0 {
 			if err != nil && err != syscall.EAGAIN {
 				// wrap error
@@ -62,14 +71,20 @@ func (t *DownloadThread) Download(conn *net.TCPConn) (err error) {
 			}
 			continue
 		}
+		spliceInBytes.Add(float64(buffered))
 		// 从pipe写到文件
 		for buffered > 0 {
 			var n int64
 			n, err = syscall.Splice(rFF.Sysfd, nil, fileFF.Sysfd, nil, int(buffered), spliceMove|spliceMore)
+			spliceOutCalls.Inc()
 			if err == nil {
+				spliceOutBytes.Add(float64(n))
 				t.cur += n
+				t.addBytes(n)
 				buffered -= n
 				break
+			} else if err == syscall.ENOSPC || err == syscall.EDQUOT {
+				return err
 			} else {
 				err = pdFile.prepareWrite(fileFF.isFile)
 				if err != nil {
@@ -0,0 +1,89 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// EventKind 事件类型
+type EventKind int
+
+const (
+	EventQueued        EventKind = iota // 任务加入队列
+	EventResolved                       // 解析出直链，刷新失效链接时也会触发
+	EventStarted                        // 任务开始下载，文件长度已知
+	EventProgress                       // 每 freshInt 秒一次的进度
+	EventRangeAssigned                  // 代理领取了一个分片
+	EventRangeDone                      // 代理下载完一个分片
+	EventProxyError                     // 代理下载分片出错，分片会被重新分配
+	EventCompleted                      // 任务下载完成
+	EventFailed                         // 任务无法开始，被跳过
+	EventSkipped                        // 文件已存在，跳过
+)
+
+var eventNames = []string{
+	"queued", "resolved", "started", "progress", "range-assigned",
+	"range-done", "proxy-error", "completed", "failed", "skipped",
+}
+
+func (k EventKind) String() string {
+	if int(k) < len(eventNames) {
+		return eventNames[k]
+	}
+	return fmt.Sprintf("EventKind(%d)", int(k))
+}
+
+func (k EventKind) MarshalText() ([]byte, error) { return []byte(k.String()), nil }
+
+func (k *EventKind) UnmarshalText(b []byte) error {
+	for i, n := range eventNames {
+		if n == string(b) {
+			*k = EventKind(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown event kind %q", b)
+}
+
+// Event 通过 Options.OnEvent 传给调用方，也可经 Bus 分发、以 JSON 输出
+type Event struct {
+	Time time.Time     `json:"time"`
+	Kind EventKind     `json:"kind"`
+	Task *DownloadTask `json:"-"`
+	Err  error         `json:"-"`
+
+	URL   string `json:"url,omitempty"`   // EventResolved 的直链
+	Proxy string `json:"proxy,omitempty"` // 分片相关事件的代理 ip
+
+	// EventRangeAssigned EventRangeDone EventProxyError 的分片范围
+	Start int64 `json:"start,omitempty"`
+	End   int64 `json:"end,omitempty"`
+
+	// EventProgress
+	Done   int64 `json:"done,omitempty"`  // 已下载字节数
+	Total  int64 `json:"total,omitempty"` // 文件长度
+	Speed  int64 `json:"speed,omitempty"` // 字节每秒
+	Active int   `json:"active,omitempty"`
+	// Frontier 从文件开头起连续下载完成的字节数
+	Frontier int64 `json:"frontier,omitempty"`
+}
+
+// MarshalJSON 附加任务文件名和错误信息
+func (e Event) MarshalJSON() ([]byte, error) {
+	type plain Event
+	v := struct {
+		plain
+		File  string `json:"file,omitempty"`
+		Page  string `json:"page,omitempty"`
+		Error string `json:"error,omitempty"`
+	}{plain: plain(e)}
+	if e.Task != nil {
+		v.File = e.Task.filename
+		v.Page = e.Task.page
+	}
+	if e.Err != nil {
+		v.Error = e.Err.Error()
+	}
+	return json.Marshal(v)
+}
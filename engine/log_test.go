@@ -1,4 +1,4 @@
-package main
+package engine
 
 import (
 	"bufio"
@@ -7,6 +7,8 @@ import (
 	"net"
 	"os"
 	"testing"
+
+	"downloader/logx"
 )
 
 func TestNewHeader(t *testing.T) {
@@ -39,9 +41,7 @@ func TestAvc(t *testing.T) {
 			log.Println("无效ip：", txt)
 			continue
 		}
-		b := []byte("                ")
-		copy(b, ip.String())
-		bm[string(b)] = ""
+		bm[ip.String()] = ""
 		//go runProxy(ip)
 	}
 	f, err = os.Open("E:\\gm\\detect.txt")
@@ -51,9 +51,13 @@ func TestAvc(t *testing.T) {
 	bf = bufio.NewScanner(f)
 	for bf.Scan() {
 		txt := bf.Text()
-		// 		07:04:35 220.181.33.174  新子任务 0xc0000f8240
-		if _, ok := bm[txt[9:25]]; ok {
-			bm[txt[9:25]] = txt
+		// 07:04:35 INFO 已加载 proxy=220.181.33.174
+		r, err := logx.Parse(txt)
+		if err != nil {
+			continue
+		}
+		if _, ok := bm[r.Fields["proxy"]]; ok {
+			bm[r.Fields["proxy"]] = txt
 		}
 
 	}
@@ -0,0 +1,48 @@
+package engine
+
+import (
+	"strconv"
+	"strings"
+
+	"downloader/metrics"
+)
+
+// 引擎的指标注册在 metrics.Default，由调用方决定是否通过 HTTP 暴露
+var (
+	mBytes = metrics.NewCounterVec("downloader_bytes_total",
+		"已写入文件的字节数", "proxy", "task")
+	mDial = metrics.NewHistogramVec("downloader_dial_seconds",
+		"连接代理耗时", nil, "proxy")
+	mTTFB = metrics.NewHistogramVec("downloader_ttfb_seconds",
+		"发送请求到读完响应头的耗时", nil, "proxy")
+	mRequest = metrics.NewHistogramVec("downloader_request_seconds",
+		"一个分片请求从连接到结束的总耗时", []float64{1, 5, 15, 60, 300, 900, 3600}, "proxy")
+	mStatus = metrics.NewCounterVec("downloader_responses_total",
+		"按状态码分类的响应数，status 为 206、200 或 other", "proxy", "status")
+	mSquid = metrics.NewCounterVec("downloader_squid_errors_total",
+		"响应头中 X-Squid-Error 的原因", "reason")
+	mProxyErrors = metrics.NewCounterVec("downloader_proxy_errors_total",
+		"分片下载失败次数", "proxy")
+	mSpliceCalls = metrics.NewCounterVec("downloader_splice_calls_total",
+		"splice 系统调用次数，dir 为 in（连接到管道）或 out（管道到文件）", "dir")
+	mSpliceBytes = metrics.NewCounterVec("downloader_splice_bytes_total",
+		"splice 搬运的字节数", "dir")
+	mActive = metrics.NewGaugeVec("downloader_active_ranges",
+		"正在接收数据的分片数", "task")
+)
+
+func statusLabel(code uint64) string {
+	if code == 206 || code == 200 {
+		return strconv.FormatUint(code, 10)
+	}
+	return "other"
+}
+
+// squidReason 取 X-Squid-Error 的错误码部分，如 "ERR_CONNECT_FAIL 111" 取 ERR_CONNECT_FAIL
+func squidReason(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, ' '); i != -1 {
+		s = s[:i]
+	}
+	return s
+}
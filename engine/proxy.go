@@ -0,0 +1,85 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+	_ "unsafe"
+
+	"downloader/logx"
+)
+
+var ErrNext = errors.New("")
+
+// AddProxy 启动一个通过 ip 下载的代理，第一个代理加入时开始分配任务
+func (s *Session) AddProxy(ip net.IP) {
+	s.once.Do(func() {
+		s.mu.Lock()
+		s.nextTask()
+		s.mu.Unlock()
+	})
+	s.wg.Add(1)
+	go s.runProxy(ip, ip.String())
+}
+
+func (s *Session) runProxy(ip net.IP, address string) {
+	addr := &net.TCPAddr{IP: ip, Port: 443}
+	dialer := &sysDialer{network: "tcp", address: address}
+	logger := s.log.With(logx.F("proxy", address))
+	logger.Info("已加载")
+	for {
+		s.mu.Lock()
+		if s.cur == nil {
+			s.mu.Unlock()
+			break
+		}
+		task := s.cur
+		started := false
+		if task.once == 0 {
+			started = task.init() == nil
+			task.once = 1
+		}
+		s.mu.Unlock()
+		if started && !task.alloc {
+			// 预分配成功时空间已经占用，再检查会把文件算两次
+			s.checkSpace(task, task.Pending())
+		}
+		for {
+			s.disk.wait()
+			err := task.Go(dialer, addr, logger)
+			if err == nil {
+				break
+			}
+			if isDiskFull(err) {
+				s.disk.pause(task, task.Pending())
+				continue
+			}
+			if err != ErrNext {
+				time.Sleep(30 * time.Second)
+			}
+		}
+		s.mu.Lock()
+		if task == s.cur {
+			logger.Info("任务结束", logx.F("task", s.cur.filename))
+			s.nextTask()
+		} else {
+			logger.Info("任务切换", logx.F("task", task.filename))
+		}
+		s.mu.Unlock()
+	}
+	s.wg.Done()
+}
+
+// sysDialer contains a Dial's parameters and configuration.
+type sysDialer struct {
+	net.Dialer
+	network, address string
+}
+
+//go:linkname doDialTCP net.(*sysDialer).doDialTCP
+func doDialTCP(sd *sysDialer, ctx context.Context, laddr, raddr *net.TCPAddr) (*net.TCPConn, error)
+
+func (sd *sysDialer) dialTCP(ctx context.Context, laddr, raddr *net.TCPAddr) (*net.TCPConn, error) {
+	return doDialTCP(sd, ctx, laddr, raddr)
+}
@@ -1,4 +1,4 @@
-package main
+package engine
 
 import (
 	"bufio"
@@ -88,6 +88,7 @@ func readHead(conn *bufio.Reader) (status uint64, err error) {
 		}
 		if strings.HasPrefix(str, "X-Squid-Error") {
 			lastErr = errors.New(str[15 : len(str)-2])
+			mSquid.With(squidReason(lastErr.Error())).Inc()
 			return status, lastErr
 		}
 		if status == 206 && strings.HasPrefix(str, "Content-Length") {
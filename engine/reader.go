@@ -0,0 +1,162 @@
+package engine
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	waitInt = 200 * time.Millisecond // 等待数据时检查下载进度的间隔
+	wantGap = 1 << 20                // 等待的位置离正在下载的位置超过该值时从等待的位置分拆分片
+)
+
+// TaskReader 读取下载中的任务文件，读到尚未下载的范围时阻塞，
+// 并让代理优先下载该位置，任务未开始时让 Session 先下载该任务
+type TaskReader struct {
+	t    *DownloadTask
+	f    *os.File
+	size int64
+	off  int64
+
+	done chan struct{}
+	once sync.Once
+	mu   sync.Mutex // Close 之后 start 不再设置 f
+}
+
+// NewReader 返回读取任务文件的 TaskReader，第一次读取时等待任务开始
+func (t *DownloadTask) NewReader() *TaskReader {
+	return &TaskReader{t: t, done: make(chan struct{})}
+}
+
+// start 等待任务开始后打开文件，文件大小在 init 中已截断为下载的长度
+func (r *TaskReader) start() error {
+	if r.f != nil {
+		return nil
+	}
+	select {
+	case <-r.t.ready:
+	case <-r.done:
+		return os.ErrClosed
+	}
+	if r.t.initErr != nil {
+		return r.t.initErr
+	}
+	f, err := os.Open(r.t.filename)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	select {
+	case <-r.done:
+		f.Close()
+		return os.ErrClosed
+	default:
+	}
+	r.f, r.size = f, st.Size()
+	return nil
+}
+
+func (r *TaskReader) Read(p []byte) (int, error) {
+	if err := r.start(); err != nil {
+		return 0, err
+	}
+	if r.off >= r.size {
+		return 0, io.EOF
+	}
+	avail, err := r.t.waitData(r.off, r.size, r.done)
+	if err != nil {
+		return 0, err
+	}
+	if int64(len(p)) > avail {
+		p = p[:avail]
+	}
+	n, err := r.f.ReadAt(p, r.off)
+	r.off += int64(n)
+	return n, err
+}
+
+func (r *TaskReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		if err := r.start(); err != nil {
+			return 0, err
+		}
+		offset += r.size
+	default:
+		return 0, errors.New("engine: 无效的 whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("engine: 负的读取位置")
+	}
+	r.off = offset
+	return offset, nil
+}
+
+// Close 关闭文件，可以在其他 goroutine 中调用，使等待数据的 Read 返回 os.ErrClosed
+func (r *TaskReader) Close() (err error) {
+	r.once.Do(func() {
+		close(r.done)
+		r.mu.Lock()
+		if r.f != nil {
+			err = r.f.Close()
+		}
+		r.mu.Unlock()
+	})
+	return
+}
+
+// waitData 等待 off 处的数据下载完成，返回从 off 开始连续可读的字节数。
+// 等待期间把 off 记为优先下载的位置
+func (t *DownloadTask) waitData(off, size int64, done <-chan struct{}) (int64, error) {
+	for {
+		t.Lock()
+		n := t.available(off, size)
+		if n > 0 {
+			if t.want == off {
+				t.want = -1
+			}
+			t.Unlock()
+			return n, nil
+		}
+		t.want = off
+		t.Unlock()
+		if t.s != nil {
+			t.s.prefer(t)
+		}
+		select {
+		case <-done:
+			return 0, os.ErrClosed
+		case <-time.After(waitInt):
+		}
+	}
+}
+
+// available 返回从 off 开始已下载的连续字节数，调用时需持有锁
+func (t *DownloadTask) available(off, size int64) int64 {
+	end := size
+	for _, r := range t.ranges {
+		if r.cur > r.end {
+			continue
+		}
+		// 分拆时前一段的 end 为后一段起点减一，end 处的一个字节也视为未下载
+		if off >= r.cur && off <= r.end {
+			return 0
+		}
+		if r.cur > off && r.cur < end {
+			end = r.cur
+		}
+	}
+	return end - off
+}
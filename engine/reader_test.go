@@ -0,0 +1,66 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTaskReader(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	name := filepath.Join(t.TempDir(), "a.bin")
+	if err := os.WriteFile(name, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	task := &DownloadTask{filename: name, ready: make(chan struct{}), want: -1}
+	task.ranges = []*DownloadThread{{cur: 400, end: 600}}
+	task.setReady(nil)
+
+	r := task.NewReader()
+	defer r.Close()
+	buf := make([]byte, 1000)
+	if n, err := r.Read(buf); n != 400 || err != nil {
+		t.Fatalf("Read = %d, %v; want the 400 bytes before the pending range", n, err)
+	}
+	got := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(r)
+		got <- b
+	}()
+	for {
+		task.Lock()
+		want := task.want
+		task.Unlock()
+		if want == 400 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	task.Lock()
+	task.ranges = nil
+	task.Unlock()
+	if b := <-got; !bytes.Equal(b, data[400:]) {
+		t.Fatalf("read %d bytes after the range arrived", len(b))
+	}
+	if task.want != -1 {
+		t.Errorf("want = %d after reading", task.want)
+	}
+}
+
+func TestTaskReaderClose(t *testing.T) {
+	task := &DownloadTask{ready: make(chan struct{}), want: -1}
+	r := task.NewReader()
+	errc := make(chan error)
+	go func() {
+		_, err := r.Read(make([]byte, 10))
+		errc <- err
+	}()
+	r.Close()
+	if err := <-errc; !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("Read = %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,164 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"downloader/layout"
+	"downloader/logx"
+	"downloader/statfile"
+)
+
+// Session 一次下载运行：任务队列和下载它们的代理
+type Session struct {
+	c     *Client
+	log   *logx.Logger
+	queue []*DownloadTask
+	cur   *DownloadTask
+	mu    sync.Mutex // 保护 queue 和 cur
+	once  sync.Once
+	wg    sync.WaitGroup
+	disk  *diskGuard
+}
+
+// Add 把页面地址加入队列，地址形如 https://rosefile.net/<page>/<name>.html
+func (s *Session) Add(webURL string) *DownloadTask {
+	page, name := parsePageURL(webURL)
+	t := &DownloadTask{
+		webUrl:   webURL,
+		page:     page,
+		name:     name,
+		s:        s,
+		filename: s.c.opts.Layout.Path(layout.Vars{Page: page, Name: name}),
+		ready:    make(chan struct{}),
+		want:     -1,
+	}
+	s.mu.Lock()
+	s.queue = append(s.queue, t)
+	s.mu.Unlock()
+	s.emit(Event{Kind: EventQueued, Task: t})
+	return t
+}
+
+func parsePageURL(url string) (page, name string) {
+	url = strings.TrimSuffix(url, ".html")
+	i := strings.LastIndexByte(url, '/')
+	name = url[i+1:]
+	url = url[:i]
+	page = url[strings.LastIndexByte(url, '/')+1:]
+	return
+}
+
+// Current 返回正在下载的任务，队列结束后为 nil
+func (s *Session) Current() *DownloadTask {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cur
+}
+
+// Wait 等待所有代理退出
+func (s *Session) Wait() {
+	s.wg.Wait()
+}
+
+// nextTask 取出下一个未完成的任务，调用时需持有 s.mu
+func (s *Session) nextTask() {
+	for len(s.queue) > 0 {
+		t := s.queue[0]
+		s.queue = s.queue[1:]
+		name, ok := s.c.opts.Layout.Place(layout.Vars{Page: t.page, Name: t.name}, Finished)
+		if !ok {
+			s.log.Info("已下载，跳过", logx.F("task", name))
+			t.filename = name
+			t.setReady(nil)
+			s.emit(Event{Kind: EventSkipped, Task: t})
+			continue
+		}
+		t.filename = name
+		s.cur = t
+		return
+	}
+	s.cur = nil
+	s.log.Info("任务分配结束，等待退出")
+}
+
+// prefer 把尚未开始的任务 t 移到队列最前，有 TaskReader 在等待它的数据
+func (s *Session) prefer(t *DownloadTask) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, q := range s.queue {
+		if q == t {
+			copy(s.queue[1:i+1], s.queue[:i])
+			s.queue[0] = t
+			return
+		}
+	}
+}
+
+// Finished 文件存在且没有 .stat 即视为已下载完成
+func Finished(name string) bool {
+	if _, err := os.Stat(name); err != nil {
+		return false
+	}
+	_, err := os.Stat(name + ".stat")
+	return err != nil && os.IsNotExist(err)
+}
+
+func (s *Session) emit(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if s.c.opts.OnEvent != nil {
+		s.c.opts.OnEvent(e)
+	}
+}
+
+// Preflight 解析队列中所有未完成任务的文件大小，与剩余磁盘空间比较
+func (s *Session) Preflight() error {
+	s.mu.Lock()
+	queue := append([]*DownloadTask(nil), s.queue...)
+	s.mu.Unlock()
+	var total int64
+	for _, t := range queue {
+		if Finished(t.filename) {
+			continue
+		}
+		fUrl := s.c.resolve(t.webUrl)
+		if fUrl == "" {
+			continue
+		}
+		size, err := wrapI64(s.c.contentLength(fUrl))
+		if err != nil {
+			s.log.Warn("获取文件大小失败", logx.F("task", t.filename), logx.Err(err))
+			continue
+		}
+		// 已存在的文件按 .stat 中剩余的范围计算
+		if st, err := os.Stat(t.filename); err == nil && st.Size() == size {
+			size = statRemain(t.filename+".stat", size)
+		}
+		s.log.Info("需要空间", logx.F("task", t.filename), logx.F("bytes", size))
+		total += size
+	}
+	free, err := freeSpace(s.c.opts.Layout.Root)
+	if err != nil {
+		s.log.Warn("无法获取剩余空间", logx.Err(err))
+		return nil
+	}
+	if free < total+diskReserve {
+		return fmt.Errorf("磁盘空间不足：队列共需要 %s，可用 %s", FormatSize(total), FormatSize(free))
+	}
+	s.log.Info("磁盘空间检查通过", logx.F("bytes", total), logx.F("free", free))
+	return nil
+}
+
+// statRemain 统计 .stat 文件中未下载的字节数，读取失败时返回 size
+func statRemain(name string, size int64) int64 {
+	rs, err := statfile.Read(name)
+	if err != nil {
+		return size
+	}
+	return statfile.Remain(rs, size)
+}
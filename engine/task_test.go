@@ -0,0 +1,153 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"downloader/layout"
+)
+
+func TestGetThreadSplit(t *testing.T) {
+	task := &DownloadTask{}
+	task.ranges = []*DownloadThread{{cur: 0, end: 1 << 20}}
+
+	first, _ := task.getThread()
+	if first != task.ranges[0] || first.state != stateReady {
+		t.Fatalf("first thread %v", first)
+	}
+	second, _ := task.getThread()
+	if second == nil || len(task.ranges) != 2 {
+		t.Fatalf("range not split: %v %v", second, task.ranges)
+	}
+	if second.cur != 1<<19 || second.end != 1<<20 || first.end != 1<<19-1 {
+		t.Errorf("split %v %v", first, second)
+	}
+
+	small := &DownloadTask{}
+	small.ranges = []*DownloadThread{{cur: 0, end: 32 << 10, state: stateReceive}}
+	if th, _ := small.getThread(); th != nil {
+		t.Errorf("small range split: %v", th)
+	}
+}
+
+func TestGetThreadWant(t *testing.T) {
+	task := &DownloadTask{want: 4 << 20}
+	task.ranges = []*DownloadThread{{cur: 0, end: 8 << 20, state: stateReceive}}
+	th, _ := task.getThread()
+	if th == nil || th.cur != 4<<20 || th.end != 8<<20 || task.ranges[0].end != 4<<20-1 {
+		t.Fatalf("thread %v, ranges %v", th, task.ranges)
+	}
+
+	near := &DownloadTask{want: 100 << 10}
+	near.ranges = []*DownloadThread{{cur: 0, end: 8 << 20, state: stateReceive}}
+	if th, _ := near.getThread(); th == nil || th.cur != 4<<20 {
+		t.Errorf("want close to an active thread: %v, want the usual split", th)
+	}
+
+	idle := &DownloadTask{want: 300}
+	idle.ranges = []*DownloadThread{{cur: 0, end: 100, state: stateReceive}, {cur: 200, end: 1000}}
+	if th, _ := idle.getThread(); th != idle.ranges[1] || th.state != stateReady {
+		t.Errorf("idle range with the wanted position not chosen: %v", th)
+	}
+}
+
+func TestGetThreadSequential(t *testing.T) {
+	c := NewClient(Options{Policy: PolicySequential, ReadAhead: 1 << 20})
+	task := &DownloadTask{s: c.NewSession(), want: -1}
+	task.ranges = []*DownloadThread{{cur: 0, end: 64 << 20}}
+
+	first, _ := task.getThread()
+	if first != task.ranges[0] {
+		t.Fatalf("first thread %v", first)
+	}
+	// 后续代理在前沿之后 1MB 的窗口内分拆，而不是从文件中间
+	for i := 0; i < 4; i++ {
+		th, _ := task.getThread()
+		if th == nil || th.cur >= 1<<20 {
+			t.Fatalf("thread %d %v outside the read-ahead window", i, th)
+		}
+	}
+	// 窗口内的分片都小于 64K 后，其余代理对半分拆最大的段
+	ahead := false
+	for i := 0; i < 32 && !ahead; i++ {
+		th, _ := task.getThread()
+		if th == nil {
+			t.Fatal("no thread")
+		}
+		ahead = th.cur >= 1<<20
+	}
+	if !ahead {
+		t.Error("no proxy left to fill the ranges after the window")
+	}
+	if got := task.Frontier(); got != 0 {
+		t.Errorf("Frontier = %d before any data", got)
+	}
+
+	done := &DownloadTask{length: 1000}
+	done.ranges = []*DownloadThread{{cur: 300, end: 400}, {cur: 700, end: 1000}}
+	if got := done.Frontier(); got != 300 {
+		t.Errorf("Frontier = %d, want 300", got)
+	}
+	done.ranges = nil
+	if got := done.Frontier(); got != 1000 {
+		t.Errorf("Frontier = %d of a finished task", got)
+	}
+}
+
+func TestParsePageURL(t *testing.T) {
+	page, name := parsePageURL("https://rosefile.net/abcdef1234/2205092.part1.rar.html")
+	if page != "abcdef1234" || name != "2205092.part1.rar" {
+		t.Errorf("got %q %q", page, name)
+	}
+}
+
+func TestStatRemain(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "f.stat")
+	os.WriteFile(name, []byte("# comment\n0:100\n200:300\n900:2000\n"), 0644)
+	if got := statRemain(name, 1000); got != 300 {
+		t.Errorf("statRemain = %d, want 300", got)
+	}
+	if got := statRemain(name+".missing", 1000); got != 1000 {
+		t.Errorf("missing stat = %d", got)
+	}
+}
+
+func TestInitRenamedCollide(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "real.rar"), []byte("done"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		collide layout.Policy
+		want    string
+		skipped bool
+	}{
+		{layout.Skip, "real.rar", true},
+		{layout.Rename, "real (1).rar", false},
+	} {
+		var skipped bool
+		client := NewClient(Options{
+			Layout:  layout.Layout{Root: root, Collide: c.collide},
+			Resolve: func(string) string { return "http://127.0.0.1:1/real.rar" },
+			OnEvent: func(e Event) { skipped = skipped || e.Kind == EventSkipped },
+		})
+		s := client.NewSession()
+		task := s.Add("https://rosefile.net/p1/page.rar.html")
+		s.mu.Lock()
+		s.nextTask()
+		err := task.init()
+		s.mu.Unlock()
+		if task.f != nil {
+			task.f.Close()
+			task.stat.Close()
+		}
+		// 直链的文件名与页面不同，按新文件名重新处理冲突
+		if got := filepath.Base(task.Filename()); got != c.want || skipped != c.skipped {
+			t.Errorf("%v: filename %q, skipped %v, want %q, %v", c.collide, got, skipped, c.want, c.skipped)
+		}
+		if c.skipped && (err != errSkipped || task.initErr != nil) {
+			t.Errorf("%v: init = %v, ready with %v", c.collide, err, task.initErr)
+		}
+	}
+}
@@ -2,19 +2,35 @@ package main
 
 import (
 	"bufio"
+	"downloader/layout"
+	"downloader/passwords"
+	"flag"
 	"fmt"
 	rar "github.com/nwaples/rardecode"
 	"io"
+	"io/fs"
 	"os"
-	"strings"
+	"path/filepath"
+)
+
+var (
+	out      layout.Layout
+	password = flag.String("password", "", "压缩包密码，最先尝试")
+	pwFile   = flag.String("passwords", "passwords.txt", "密码列表，[站点] 之后为该站点的默认密码")
+	list     = flag.Bool("list", false, "只列出压缩包中的文件，不解压")
 )
 
 func main() {
-	file := os.Args[1]
-	i := strings.IndexByte(file, '.')
-	dir := `R:/车库/lsp/guomoo/` + file[:i] + `/`
+	out.Flags(flag.CommandLine, "{set}")
+	flag.Parse()
+	file := flag.Arg(0)
+	if *list {
+		listRAR(file)
+		return
+	}
+	dir := out.Path(layout.Vars{Name: filepath.Base(file)}) + "/"
 	println(dir)
-	unpackRAR(os.Args[1], dir)
+	unpackRAR(file, dir)
 	os.Exit(0)
 }
 
@@ -61,46 +77,58 @@ func (a *rarArchives) Read(p []byte) (n int, err error) {
 	return
 }
 
-func (a *rarArchives) MarkRange(start, end int64) {
-	fStat, err := a.curF.Stat()
+type errFile struct {
+	filename   string
+	start, end int
+}
+
+// findPassword 从 -password 和密码列表中找出压缩包的密码
+func findPassword(firstFile string) string {
+	p, err := passwords.Load(*pwFile, *password)
 	if err != nil {
 		panic(err)
 	}
-	prevPos := a.lastOffset - fStat.Size()
-	if start < prevPos { // 有上一文件的末尾损坏
-		markInfect(a.files[a.pos-2], prevPos, start, end)
+	pw, err := p.Find(firstFile, nil)
+	if err != nil {
+		panic(err)
 	}
-	markInfect(a.files[a.pos-1], a.lastOffset, start, end)
+	return pw
 }
 
-func markInfect(filename string, endOffset, start, end int64) {
-	stat, err := os.Stat(filename) // 上一文件
+// listRAR 按目录树列出压缩包中的文件，只读取文件头
+func listRAR(firstFile string) {
+	x, err := rar.ReadIndex(firstFile, findPassword(firstFile))
 	if err != nil {
 		panic(err)
 	}
-	endOffset -= stat.Size()
-	start -= endOffset
-	end -= endOffset
-	if end > stat.Size() {
-		end = stat.Size()
-	}
-	statF, err := os.OpenFile(filename+".stat", os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	err = fs.WalkDir(rar.NewFS(x), ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s %12d %s %s\n", fi.Mode(), fi.Size(), fi.ModTime().Format("2006-01-02 15:04"), name)
+		return nil
+	})
 	if err != nil {
 		panic(err)
 	}
-	fmt.Sprintf("%d:%d\n", start, end)
-	statF.Close()
-}
-
-type errFile struct {
-	filename   string
-	start, end int
 }
 
 func unpackRAR(firstFile string, workDir string) {
-	r, err := rar.OpenReader(firstFile, "123123")
+	r, err := rar.OpenReader(firstFile, findPassword(firstFile))
 	if err != nil {
 		panic(err)
 	}
-	r.UnpackTo(workDir)
+	defer r.Close()
+	err = r.UnpackTo(workDir)
+	if errs, ok := err.(rar.UnpackErrors); ok {
+		for _, e := range errs {
+			fmt.Println(e)
+		}
+	} else if err != nil {
+		panic(err)
+	}
 }
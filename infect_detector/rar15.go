@@ -4,11 +4,15 @@ import (
 	"bufio"
 	"bytes"
 	"crypto/sha1"
+	"downloader/layout"
+	"downloader/passwords"
 	"errors"
+	"flag"
 	"fmt"
 	"hash/crc32"
 	"io"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -503,25 +507,32 @@ func (a *archive15) Next() (*FileHeader, error) {
 	return &h.FileHeader, nil
 }
 
+var (
+	out      layout.Layout
+	password = flag.String("password", "", "压缩包密码，最先尝试")
+	pwFile   = flag.String("passwords", "passwords.txt", "密码列表，[站点] 之后为该站点的默认密码")
+)
+
 func main() {
-	f, err := os.Open(os.Args[1])
+	out.Flags(flag.CommandLine, "{set}")
+	flag.Parse()
+	p, err := passwords.Load(*pwFile, *password)
 	if err != nil {
 		panic(err)
 	}
-	ar, err := openArchive15(f, "gmw1024")
+	pw, err := p.Find(flag.Arg(0), nil)
 	if err != nil {
 		panic(err)
 	}
-	var dir string
-	{
-		name := os.Args[1]
-		i := strings.LastIndexByte(name, '/')
-		if i != -1 {
-			name = name[i:]
-		}
-		i = strings.IndexByte(name, '.')
-		dir = `R:/车库/lsp/guomoo/` + name[:i] + `/`
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		panic(err)
+	}
+	ar, err := openArchive15(f, pw)
+	if err != nil {
+		panic(err)
 	}
+	dir := out.Path(layout.Vars{Name: filepath.Base(flag.Arg(0))}) + "/"
 	for err == nil {
 		h, err := ar.Next()
 		if err != nil {
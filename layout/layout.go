@@ -0,0 +1,203 @@
+// Package layout 决定下载文件和解压目录的存放位置。
+//
+// 路径由根目录和名称模板组成，模板中的 / 表示子目录，可用变量：
+//
+//	{page}  页面 id
+//	{name}  文件名
+//	{base}  去掉扩展名的文件名
+//	{ext}   扩展名（不含 .）
+//	{set}   分卷集合名，如 2205092.part1.rar => 2205092
+//	{date}  日期 2006-01-02，另有 {yyyy} {mm} {dd}
+//
+// 替换后的每一级目录名都会去掉不安全的字符。
+package layout
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Policy 目标文件已存在时的处理方式
+type Policy int
+
+const (
+	Skip      Policy = iota // 跳过
+	Overwrite               // 覆盖
+	Rename                  // 改名为 name (1).ext
+)
+
+var policyNames = []string{"skip", "overwrite", "rename"}
+
+func (p Policy) String() string {
+	if int(p) < len(policyNames) {
+		return policyNames[p]
+	}
+	return fmt.Sprintf("Policy(%d)", int(p))
+}
+
+// Set 实现 flag.Value
+func (p *Policy) Set(s string) error {
+	for i, n := range policyNames {
+		if strings.EqualFold(s, n) {
+			*p = Policy(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("未知的冲突处理方式 %q，可选 %s", s, strings.Join(policyNames, "/"))
+}
+
+// Vars 模板变量
+type Vars struct {
+	Page string
+	Name string
+	Date time.Time // 为零时使用当前时间
+}
+
+// Layout 输出根目录 + 名称模板
+type Layout struct {
+	Root     string
+	Template string
+	Collide  Policy
+}
+
+// Flags 注册 -out -name -collide 参数，下载器和解压工具共用
+func (l *Layout) Flags(fs *flag.FlagSet, template string) {
+	if l.Root == "" {
+		l.Root = "."
+	}
+	l.Template = template
+	fs.StringVar(&l.Root, "out", l.Root, "输出根目录")
+	fs.StringVar(&l.Template, "name", l.Template, "名称模板，可用 {page} {name} {base} {ext} {set} {date} {yyyy} {mm} {dd}")
+	fs.Var(&l.Collide, "collide", "目标已存在时 skip/overwrite/rename")
+}
+
+var reSet = regexp.MustCompile(`(?i)(\.part\d+)?\.(rar|exe|sfx)$|\.r\d\d$|\.\d{3}$`)
+
+// SetName 返回分卷集合名，去掉 .partN.rar/.rNN/.NNN 等分卷后缀
+func SetName(name string) string {
+	if loc := reSet.FindStringIndex(name); loc != nil && loc[0] > 0 {
+		return name[:loc[0]]
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		return name[:i]
+	}
+	return name
+}
+
+// Expand 按模板生成相对路径，使用 / 作为分隔符
+func (l *Layout) Expand(v Vars) string {
+	tmpl := l.Template
+	if tmpl == "" {
+		tmpl = "{name}"
+	}
+	date := v.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+	ext := filepath.Ext(v.Name)
+	r := strings.NewReplacer(
+		"{page}", v.Page,
+		"{name}", v.Name,
+		"{base}", strings.TrimSuffix(v.Name, ext),
+		"{ext}", strings.TrimPrefix(ext, "."),
+		"{set}", SetName(v.Name),
+		"{date}", date.Format("2006-01-02"),
+		"{yyyy}", date.Format("2006"),
+		"{mm}", date.Format("01"),
+		"{dd}", date.Format("02"),
+	)
+	// 先按模板中的 / 拆分，变量值里的 / 会在 Sanitize 中被替换
+	segs := strings.Split(tmpl, "/")
+	out := segs[:0]
+	for _, seg := range segs {
+		if seg == "" {
+			continue
+		}
+		out = append(out, Sanitize(r.Replace(seg)))
+	}
+	return strings.Join(out, "/")
+}
+
+// Path 返回根目录下的完整路径
+func (l *Layout) Path(v Vars) string {
+	return filepath.Join(l.Root, filepath.FromSlash(l.Expand(v)))
+}
+
+// Place 计算目标路径并处理冲突，busy 判断路径是否已被占用（为 nil 时检查文件是否存在）。
+// ok 为 false 表示按 Skip 策略跳过。
+func (l *Layout) Place(v Vars, busy func(string) bool) (path string, ok bool) {
+	if busy == nil {
+		busy = exists
+	}
+	path = l.Path(v)
+	if !busy(path) {
+		return path, true
+	}
+	switch l.Collide {
+	case Overwrite:
+		return path, true
+	case Rename:
+		return Unique(path, busy), true
+	}
+	return path, false
+}
+
+// Unique 在扩展名前追加 (1) (2)... 直到 busy 返回 false
+func Unique(path string, busy func(string) bool) string {
+	if busy == nil {
+		busy = exists
+	}
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for i := 1; ; i++ {
+		p := fmt.Sprintf("%s (%d)%s", base, i, ext)
+		if !busy(p) {
+			return p
+		}
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil || !errors.Is(err, os.ErrNotExist)
+}
+
+var reserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// Sanitize 把单级文件名中的路径分隔符、控制字符和 Windows 保留字符替换为 _，
+// 并去掉结尾的点和空格，保证结果不会跳出所在目录
+func Sanitize(name string) string {
+	b := []rune(name)
+	for i, c := range b {
+		switch {
+		case c < 0x20 || c == 0x7f:
+			b[i] = '_'
+		case strings.ContainsRune(`<>:"/\|?*`, c):
+			b[i] = '_'
+		}
+	}
+	name = strings.TrimRight(string(b), ". ")
+	if name == "" {
+		return "_"
+	}
+	base := name
+	if i := strings.IndexByte(base, '.'); i >= 0 {
+		base = base[:i]
+	}
+	if reserved[strings.ToUpper(base)] {
+		name = "_" + name
+	}
+	return name
+}
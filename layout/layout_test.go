@@ -0,0 +1,69 @@
+package layout
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSetName(t *testing.T) {
+	for name, want := range map[string]string{
+		"2205092.part1.rar":  "2205092",
+		"2205092.part12.rar": "2205092",
+		"a.b.part01.rar":     "a.b",
+		"old.rar":            "old",
+		"old.r00":            "old",
+		"split.001":          "split",
+		"video.mp4":          "video",
+		"noext":              "noext",
+	} {
+		if got := SetName(name); got != want {
+			t.Errorf("SetName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	for name, want := range map[string]string{
+		"a/b":       "a_b",
+		"..":        "_",
+		"x:y*?.":    "x_y__",
+		"con.txt":   "_con.txt",
+		"ok name":   "ok name",
+		"tab\there": "tab_here",
+	} {
+		if got := Sanitize(name); got != want {
+			t.Errorf("Sanitize(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	l := Layout{Root: "out", Template: "{date}/{set}/{page}_{name}"}
+	v := Vars{Page: "abc", Name: "../x.part2.rar", Date: time.Date(2022, 5, 9, 0, 0, 0, 0, time.UTC)}
+	want := filepath.Join("out", "2022-05-09", ".._x", "abc_.._x.part2.rar")
+	if got := l.Path(v); got != want {
+		t.Errorf("Path = %q, want %q", got, want)
+	}
+}
+
+func TestPlace(t *testing.T) {
+	taken := map[string]bool{"f.rar": true, "f (1).rar": true}
+	busy := func(p string) bool { return taken[p] }
+	v := Vars{Name: "f.rar"}
+	for _, c := range []struct {
+		p    Policy
+		path string
+		ok   bool
+	}{
+		{Skip, "f.rar", false},
+		{Overwrite, "f.rar", true},
+		{Rename, "f (2).rar", true},
+	} {
+		l := Layout{Root: ".", Collide: c.p}
+		path, ok := l.Place(v, busy)
+		if path != c.path || ok != c.ok {
+			t.Errorf("%v: Place = %q %v, want %q %v", c.p, path, ok, c.path, c.ok)
+		}
+	}
+}
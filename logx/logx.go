@@ -0,0 +1,292 @@
+// Package logx 分级、带字段的日志，可输出为文本或 JSON 行。
+//
+//	l := logx.New(os.Stderr, logx.Info, false)
+//	pl := l.With(logx.F("proxy", ip))
+//	pl.Warn("子任务结束", logx.F("range", r), logx.Err(err))
+//
+// 文本格式为 "15:04:05 WARN 子任务结束 proxy=1.2.3.4 range=[0:100] err=..."，
+// JSON 格式为每行一个对象，包含 time level msg 和全部字段。
+package logx
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level 日志级别
+type Level int8
+
+const (
+	Debug Level = iota - 1
+	Info
+	Warn
+	Error
+)
+
+func (l Level) String() string {
+	switch l {
+	case Debug:
+		return "DEBUG"
+	case Info:
+		return "INFO"
+	case Warn:
+		return "WARN"
+	case Error:
+		return "ERROR"
+	}
+	return "LEVEL(" + strconv.Itoa(int(l)) + ")"
+}
+
+// Set 实现 flag.Value
+func (l *Level) Set(s string) error {
+	for _, v := range []Level{Debug, Info, Warn, Error} {
+		if strings.EqualFold(s, v.String()) {
+			*l = v
+			return nil
+		}
+	}
+	return fmt.Errorf("未知的日志级别 %q", s)
+}
+
+// Field 一个键值对
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+func F(key string, value interface{}) Field {
+	return Field{key, value}
+}
+
+// Err 以 err 为键的字段，err 为 nil 时输出时忽略
+func Err(err error) Field {
+	return Field{"err", err}
+}
+
+// output 同一 Logger 派生出的所有 Logger 共用
+type output struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level Level
+	json  bool
+	buf   bytes.Buffer
+}
+
+// Logger 可并发使用，With 派生的 Logger 共享输出和级别
+type Logger struct {
+	out    *output
+	fields []Field
+}
+
+func New(w io.Writer, level Level, json bool) *Logger {
+	return &Logger{out: &output{w: w, level: level, json: json}}
+}
+
+// Discard 不输出任何内容
+var Discard = New(io.Discard, Error+1, false)
+
+// With 返回附加了 fields 的 Logger
+func (l *Logger) With(fields ...Field) *Logger {
+	nf := make([]Field, 0, len(l.fields)+len(fields))
+	nf = append(nf, l.fields...)
+	nf = append(nf, fields...)
+	return &Logger{out: l.out, fields: nf}
+}
+
+// SetLevel 修改共享输出的级别
+func (l *Logger) SetLevel(level Level) {
+	l.out.mu.Lock()
+	l.out.level = level
+	l.out.mu.Unlock()
+}
+
+func (l *Logger) Enabled(level Level) bool {
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	return level >= l.out.level
+}
+
+func (l *Logger) Debug(msg string, fields ...Field) { l.Log(Debug, msg, fields...) }
+func (l *Logger) Info(msg string, fields ...Field)  { l.Log(Info, msg, fields...) }
+func (l *Logger) Warn(msg string, fields ...Field)  { l.Log(Warn, msg, fields...) }
+func (l *Logger) Error(msg string, fields ...Field) { l.Log(Error, msg, fields...) }
+
+func (l *Logger) Log(level Level, msg string, fields ...Field) {
+	o := l.out
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if level < o.level {
+		return
+	}
+	o.buf.Reset()
+	now := time.Now()
+	if o.json {
+		o.buf.WriteString(`{"time":`)
+		writeJSON(&o.buf, now.Format(time.RFC3339Nano))
+		o.buf.WriteString(`,"level":`)
+		writeJSON(&o.buf, strings.ToLower(level.String()))
+		o.buf.WriteString(`,"msg":`)
+		writeJSON(&o.buf, msg)
+		l.eachField(fields, func(k string, v interface{}) {
+			o.buf.WriteByte(',')
+			writeJSON(&o.buf, k)
+			o.buf.WriteByte(':')
+			writeJSON(&o.buf, v)
+		})
+		o.buf.WriteString("}\n")
+	} else {
+		o.buf.WriteString(now.Format("15:04:05 "))
+		o.buf.WriteString(level.String())
+		o.buf.WriteByte(' ')
+		o.buf.WriteString(msg)
+		l.eachField(fields, func(k string, v interface{}) {
+			o.buf.WriteByte(' ')
+			o.buf.WriteString(k)
+			o.buf.WriteByte('=')
+			o.buf.WriteString(quote(fmt.Sprint(v)))
+		})
+		o.buf.WriteByte('\n')
+	}
+	o.w.Write(o.buf.Bytes())
+}
+
+func (l *Logger) eachField(fields []Field, fn func(string, interface{})) {
+	for _, fs := range [][]Field{l.fields, fields} {
+		for _, f := range fs {
+			v := f.Value
+			if err, ok := v.(error); ok {
+				v = err.Error()
+			} else if v == nil && f.Key == "err" {
+				continue
+			}
+			fn(f.Key, v)
+		}
+	}
+}
+
+func writeJSON(buf *bytes.Buffer, v interface{}) {
+	if s, ok := v.(fmt.Stringer); ok {
+		v = s.String()
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprint(v))
+	}
+	buf.Write(b)
+}
+
+// quote 值中有空格、引号或 = 时加引号
+func quote(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\r\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// Std 返回写入 l 的标准库 *log.Logger，每次输出作为一条 level 级别的消息，
+// 用于接管 log.Printf 等未改造的日志
+func (l *Logger) Std(level Level) *log.Logger {
+	return log.New(stdWriter{l, level}, "", 0)
+}
+
+type stdWriter struct {
+	l     *Logger
+	level Level
+}
+
+func (w stdWriter) Write(p []byte) (int, error) {
+	w.l.Log(w.level, strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+// Record 解析出的一行日志
+type Record struct {
+	Time   string
+	Level  Level
+	Msg    string
+	Fields map[string]string
+}
+
+// Parse 解析 Logger 输出的一行文本或 JSON 日志
+func Parse(line string) (r Record, err error) {
+	line = strings.TrimSpace(line)
+	r.Fields = make(map[string]string)
+	if strings.HasPrefix(line, "{") {
+		var m map[string]interface{}
+		if err = json.Unmarshal([]byte(line), &m); err != nil {
+			return
+		}
+		for k, v := range m {
+			s := fmt.Sprint(v)
+			switch k {
+			case "time":
+				r.Time = s
+			case "level":
+				err = r.Level.Set(s)
+			case "msg":
+				r.Msg = s
+			default:
+				r.Fields[k] = s
+			}
+		}
+		return
+	}
+	parts := splitText(line)
+	if len(parts) < 3 {
+		return r, fmt.Errorf("无效的日志行 %q", line)
+	}
+	r.Time = parts[0]
+	if err = r.Level.Set(parts[1]); err != nil {
+		return
+	}
+	// 消息中可能有空格，第一个 key=value 之前都属于消息
+	i := 2
+	for ; i < len(parts); i++ {
+		if k, v, ok := strings.Cut(parts[i], "="); ok && k != "" {
+			if uq, err := strconv.Unquote(v); err == nil {
+				v = uq
+			}
+			r.Fields[k] = v
+		} else if len(r.Fields) == 0 {
+			if r.Msg != "" {
+				r.Msg += " "
+			}
+			r.Msg += parts[i]
+		}
+	}
+	return
+}
+
+// splitText 按空格切分，保留引号中的空格
+func splitText(line string) (parts []string) {
+	start, inQuote := -1, false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case inQuote && c == '\\':
+			i++
+		case c == '"':
+			inQuote = !inQuote
+		case c == ' ' && !inQuote:
+			if start >= 0 {
+				parts = append(parts, line[start:i])
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		parts = append(parts, line[start:])
+	}
+	return
+}
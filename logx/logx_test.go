@@ -0,0 +1,76 @@
+package logx
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestText(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, Info, false).With(F("proxy", "1.2.3.4"))
+	l.Debug("hidden")
+	l.Warn("子任务结束", F("range", "[0:100]"), F("msg", "a b"), Err(nil))
+	line := buf.String()
+	if strings.Contains(line, "hidden") {
+		t.Error("debug line written")
+	}
+	want := ` WARN 子任务结束 proxy=1.2.3.4 range=[0:100] msg="a b"` + "\n"
+	if !strings.HasSuffix(line, want) {
+		t.Errorf("got %q, want suffix %q", line, want)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, Debug, true).With(F("task", "a.rar"))
+	l.Error("失败", F("bytes", 42), Err(errors.New("boom")))
+	var v map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &v); err != nil {
+		t.Fatal(err, buf.String())
+	}
+	if v["level"] != "error" || v["msg"] != "失败" || v["task"] != "a.rar" || v["bytes"] != 42.0 || v["err"] != "boom" {
+		t.Errorf("unexpected %s", buf.String())
+	}
+}
+
+func TestRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log.txt")
+	w := &RotateWriter{Path: path, MaxSize: 10, Backups: 2}
+	for _, s := range []string{"0123456\n", "abcdefg\n", "ABCDEFG\n", "last\n"} {
+		w.Write([]byte(s))
+	}
+	w.Close()
+	for name, want := range map[string]string{
+		path:        "last\n",
+		path + ".1": "ABCDEFG\n",
+		path + ".2": "abcdefg\n",
+	} {
+		b, _ := os.ReadFile(name)
+		if string(b) != want {
+			t.Errorf("%s = %q, want %q", name, b, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); err == nil {
+		t.Error("too many backups")
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, js := range []bool{false, true} {
+		var buf bytes.Buffer
+		New(&buf, Info, js).With(F("proxy", "1.2.3.4")).Warn("子任务 结束", F("err", `bad "x" y`), F("bytes", 7))
+		r, err := Parse(buf.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Level != Warn || r.Msg != "子任务 结束" || r.Fields["proxy"] != "1.2.3.4" ||
+			r.Fields["err"] != `bad "x" y` || r.Fields["bytes"] != "7" {
+			t.Errorf("json=%v: %+v", js, r)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package logx
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// RotateWriter 追加写入 Path，超过 MaxSize 字节时依次改名为 Path.1 Path.2 ...，
+// 最多保留 Backups 个旧文件
+type RotateWriter struct {
+	Path    string
+	MaxSize int64 // <=0 不切分
+	Backups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func (r *RotateWriter) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	if r.MaxSize > 0 && r.size+int64(len(p)) > r.MaxSize && r.size > 0 {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *RotateWriter) open() error {
+	f, err := os.OpenFile(r.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, st.Size()
+	return nil
+}
+
+func (r *RotateWriter) rotate() error {
+	r.f.Close()
+	r.f = nil
+	if r.Backups <= 0 {
+		os.Remove(r.Path)
+	} else {
+		os.Remove(fmt.Sprintf("%s.%d", r.Path, r.Backups))
+		for i := r.Backups - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", r.Path, i), fmt.Sprintf("%s.%d", r.Path, i+1))
+		}
+		if err := os.Rename(r.Path, r.Path+".1"); err != nil {
+			return err
+		}
+	}
+	return r.open()
+}
+
+func (r *RotateWriter) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
@@ -2,113 +2,304 @@ package main
 
 import (
 	"bufio"
-	"bytes"
-	"errors"
+	"flag"
+	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 	"time"
+
+	"downloader/engine"
+	"downloader/layout"
+	"downloader/logx"
+	"downloader/metrics"
+	"downloader/passwords"
+	"downloader/pipeline"
+	"downloader/serve"
+	"downloader/tui"
 )
 
 var wg sync.WaitGroup
 
-var nextTask func()
+var (
+	prealloc    = flag.Bool("prealloc", false, "使用 fallocate 预分配文件空间，而不是创建稀疏文件")
+	checkDisk   = flag.Bool("check-space", false, "开始前解析全部任务的文件大小，检查剩余磁盘空间")
+	urlsFile    = flag.String("urls", "urls.txt", "任务列表")
+	proxysFile  = flag.String("ips", "ips.txt", "代理ip列表")
+	eventsOut   = flag.String("events", "", "以 JSON 行输出事件：文件路径，或 unix:/path、tcp:host:port 监听地址")
+	metricsAddr = flag.String("metrics", "", "在该地址（如 :9100）的 /metrics 上提供 Prometheus 格式的指标")
+	serveAddr   = flag.String("serve", "", "在该地址（如 :8080）以 HTTP 提供下载目录中的文件，支持 Range，下载中的文件等待并优先下载请求的范围")
+	logFile     = flag.String("log-file", "log.txt", "日志文件，为空时只输出到终端")
+	logJSON     = flag.Bool("log-json", false, "日志使用 JSON 行格式")
+	logSize     = flag.Int64("log-max-size", 64, "日志文件超过该大小（MB）时切分，0 为不切分")
+	logBackups  = flag.Int("log-backups", 3, "保留的旧日志文件个数")
+	logLevel    = logx.Info
+	repairMax   = flag.Int("repair-rounds", 2, "重新下载损坏范围的最多轮数，需要用 -post 显式指定 verify 或 test 以及 repair，默认 none 时不生效")
+	useTUI      = flag.Bool("tui", false, "全屏显示队列、分片、代理和日志，标准输出不是终端时忽略")
+	pwFile      = flag.String("passwords", "passwords.txt", "压缩包密码列表，[站点] 之后为该站点的默认密码，不存在时忽略")
+	sequential  = flag.Bool("sequential", false, "按顺序优先下载，保持从文件开头连续的前沿，便于边下边用")
+	readAhead   = flag.Int64("read-ahead", 8, "-sequential 的预读窗口（MB），窗口内都有代理时其余代理下载更后面的部分")
+	streaming   = flag.Bool("stream-extract", false, "边下载边解压 rar 到 -extract-dir，解压读到未下载的范围时优先下载该位置")
+
+	out layout.Layout
+
+	post     pipeline.Config
+	postFlow *pipeline.Pipeline
+
+	sess  *engine.Session
+	ui    *tui.UI       // 未启用全屏界面时为 nil
+	files *serve.Server // 未启用 -serve 时为 nil
+)
 
 func main() {
-	logF, err := os.OpenFile("log.txt", os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
-		panic(err)
+	out.Flags(flag.CommandLine, "{name}")
+	flag.StringVar(&post.Steps, "post", "none", "下载完成后依次执行的步骤 verify/test/repair/extract/move，默认 none 不校验也不修复，需要时须显式指定，如 verify,test,repair")
+	flag.StringVar(&post.Extract.Root, "extract-dir", ".", "解压根目录，每个分卷集合解压到其下的 {set} 目录")
+	flag.Var(&post.Unpack.Overwrite, "extract-overwrite", "解压时已存在的文件 skip（校验和相同时跳过，否则覆盖）/rename/overwrite")
+	flag.Var(&post.Unpack.Symlinks, "extract-symlinks", "解压压缩包中的符号链接 skip/inside（只创建指向解压目录内的）/all")
+	flag.StringVar(&post.MoveTo, "move-to", "", "move 步骤的目标目录")
+	flag.StringVar(&post.Exec, "exec", "", "处理结束后执行的命令，任务信息见 JOB_* 环境变量")
+	flag.StringVar(&post.Webhook, "webhook", "", "处理结束后以 JSON POST 任务状态的地址")
+	flag.StringVar(&post.Password, "password", "", "压缩包密码")
+	flag.Var(&logLevel, "log-level", "日志级别 debug/info/warn/error")
+	flag.Parse()
+	var console io.Writer = os.Stderr
+	if *useTUI && tui.IsTerminal(os.Stdout) {
+		ui = tui.New(os.Stdout)
+		ui.Label = taskLabel
+		console = ui
 	}
-	log.SetFlags(log.Ltime | log.Lmsgprefix)
-	log.SetOutput(io.MultiWriter(os.Stderr, logF))
-	f, err := os.Open("urls.txt")
-	if err != nil {
-		panic(err)
+	logger := openLog(console)
+	var err error
+	if post.Passwords, err = passwords.Load(*pwFile, post.Password); err != nil {
+		fatal(err)
 	}
-	http.DefaultClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
-		return http.ErrUseLastResponse
+	post.Log = logger
+	if postFlow, err = pipeline.New(&post); err != nil {
+		fatal(err)
 	}
-	bf := bufio.NewScanner(f)
-	nextTask = func() {
-		for bf.Scan() {
-			url := bf.Text()
-			if len(url) < 32 || url[0] == '#' {
-				continue
-			}
-			name := url[32 : len(url)-5]
-			_, err := os.Stat(name)
-			if err == nil {
-				_, err = os.Stat(name + ".stat")
-				if err != nil && os.IsNotExist(err) {
-					log.Println(name, "已下载，跳过")
-					continue
-				}
+
+	if *metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Default)
+		go func() {
+			fatal(http.ListenAndServe(*metricsAddr, mux))
+		}()
+	}
+
+	if *serveAddr != "" {
+		root := out.Root
+		if root == "" {
+			root = "."
+		}
+		files = serve.New(root)
+		go func() {
+			fatal(http.ListenAndServe(*serveAddr, files))
+		}()
+	}
+
+	bus := engine.NewBus()
+	if *eventsOut != "" {
+		if err = bus.OutputJSON(*eventsOut); err != nil {
+			fatal(err)
+		}
+	}
+	policy := engine.PolicySplit
+	if *sequential {
+		policy = engine.PolicySequential
+	}
+	client := engine.NewClient(engine.Options{
+		Layout:    out,
+		Prealloc:  *prealloc,
+		Policy:    policy,
+		ReadAhead: *readAhead << 20,
+		Logger:    logger,
+		OnPage:    post.Passwords.AddPage,
+		OnEvent: func(e engine.Event) {
+			onEvent(e)
+			if ui != nil {
+				ui.Handle(e)
 			}
-			curTask = &DownloadTask{webUrl: url, filename: name}
-			return
+			bus.Publish(e)
+		},
+	})
+	sess = client.NewSession()
+	queue := loadQueue(*urlsFile)
+	groupVolumes(queue)
+	if *streaming {
+		startStreams(queue)
+	}
+	if *checkDisk {
+		if err = sess.Preflight(); err != nil {
+			fatal(err)
 		}
-		curTask = nil
-		log.Println("任务分配结束，等待退出")
 	}
-	nextTask()
-	runProxys("ips.txt")
+	if ui != nil {
+		ui.Start(500 * time.Millisecond)
+		closeOnSignal()
+	}
+	runProxys(*proxysFile)
+	sess.Wait()
 	wg.Wait()
+	for round := 1; round <= *repairMax; round++ {
+		again := damaged(queue)
+		if len(again) == 0 {
+			break
+		}
+		log.Printf("第 %d 轮修复：重新下载 %d 个文件中损坏的范围", round, len(again))
+		sess = client.NewSession()
+		for _, t := range again {
+			queue = requeue(queue, t)
+		}
+		runProxys(*proxysFile)
+		sess.Wait()
+		wg.Wait()
+	}
+	if ui != nil {
+		ui.Close()
+	}
+}
 
+// fatal 记录错误后退出，全屏界面先恢复终端
+func fatal(v ...interface{}) {
+	log.Print(v...)
+	if ui != nil {
+		ui.Close()
+	}
+	os.Exit(1)
 }
 
-func getFileURL(url string) string {
-	req, _ := http.NewRequest("GET", url, nil)
-	req.Header.Set("User-Agent", UA)
-	var resp *http.Response
-	var err error
-	var body []byte
-	var sleepIntv time.Duration
-	for {
-		if err != nil {
-			log.Println(err)
-			sleepIntv += 3 * time.Second
-			time.Sleep(sleepIntv)
-		}
-		resp, err = http.DefaultClient.Do(req)
-		if err != nil {
-			continue
-		}
-		body, err = io.ReadAll(resp.Body)
-		if err != nil {
-			continue
-		}
-		if resp.StatusCode != 200 {
-			err = errors.New("resp:" + resp.Status)
+// closeOnSignal 收到 Ctrl-C 或 SIGTERM 时恢复终端再退出
+func closeOnSignal() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-c
+		ui.Close()
+		log.Printf("收到信号 %v，退出", sig)
+		os.Exit(1)
+	}()
+}
+
+// openLog 日志同时输出到 console 和可切分的日志文件，标准库 log 的输出作为 info 级别转入
+func openLog(console io.Writer) *logx.Logger {
+	w := console
+	if *logFile != "" {
+		w = io.MultiWriter(console, &logx.RotateWriter{
+			Path:    *logFile,
+			MaxSize: *logSize << 20,
+			Backups: *logBackups,
+		})
+	}
+	logger := logx.New(w, logLevel, *logJSON)
+	log.SetFlags(0)
+	log.SetOutput(logger.Std(logx.Info).Writer())
+	return logger
+}
+
+// task 命令行对下载任务的附加信息，保存在 DownloadTask.Tag 中
+type task struct {
+	*engine.DownloadTask
+	set      *volumeSet // 所属分卷集合，非 rar 分卷为 nil
+	vol      int        // 分卷号，从 0 开始
+	finished bool
+	length   int64
+	job      *pipeline.Job // 不属于分卷集合时的后续处理结果
+}
+
+// loadQueue 读取任务列表，每行一个页面地址，# 开头为注释
+func loadQueue(filename string) (queue []*task) {
+	f, err := os.Open(filename)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	bf := bufio.NewScanner(f)
+	for bf.Scan() {
+		url := bf.Text()
+		if len(url) < 32 || url[0] == '#' {
 			continue
 		}
-		i := bytes.Index(body, ([]byte)("// is open ref count\nadd_ref"))
-		if i == -1 {
-			err = errors.New("failed to split fileid " + url)
+		t := &task{DownloadTask: sess.Add(url)}
+		t.Tag = t
+		queue = append(queue, t)
+	}
+	return
+}
+
+func runProxys(filename string) {
+	f, err := os.Open(filename)
+	if err != nil {
+		fatal("打开代理ip列表出错", err)
+	}
+
+	bf := bufio.NewScanner(f)
+	for bf.Scan() {
+		txt := bf.Text()
+		ip := net.ParseIP(txt)
+		if ip == nil {
+			log.Println("无效ip：", txt)
 			continue
 		}
-		body = body[i+29-31:]
-		copy(body, "action=load_down_addr1&file_id=")
-		i = bytes.IndexByte(body, ')')
-		body = body[:i]
-		req, _ = http.NewRequest("POST", "https://rosefile.net/ajax.php", bytes.NewReader(body))
-		req.Header.Set("Referer", url)
-		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-		req.Header.Set("User-Agent", UA)
-		resp, err = http.DefaultClient.Do(req)
-		if err != nil {
-			panic(err)
+		sess.AddProxy(ip)
+	}
+}
+
+func onEvent(e engine.Event) {
+	if files != nil && e.Kind == engine.EventQueued {
+		files.Track(e.Task)
+	}
+	t, ok := e.Task.Tag.(*task)
+	if !ok {
+		return // Session.Add 返回前的 EventQueued
+	}
+	switch e.Kind {
+	case engine.EventProgress:
+		if ui == nil {
+			printProgress(t, e)
 		}
-		body, err = io.ReadAll(resp.Body)
-		i = bytes.IndexByte(body, '"')
-		if i == -1 {
-			err = errors.New("failed to split fileurl " + url)
-			continue
+	case engine.EventCompleted:
+		t.finish()
+	case engine.EventSkipped:
+		if t.set != nil {
+			t.set.partDone(t, false)
 		}
-		body = body[i+1:]
-		i = bytes.IndexByte(body, '"')
+	}
+}
+
+func printProgress(t *task, e engine.Event) {
+	b := '\r'
+	if t.DownloadTask != sess.Current() {
+		b = ' '
+	}
+	var setInfo string
+	if t.set != nil && len(t.set.parts) > 1 {
+		done, total := t.set.progress()
+		setInfo = fmt.Sprintf("[%s %d/%d %s/%s] ", t.set.name,
+			t.vol+1, len(t.set.parts), formatSize(done), formatSize(total))
+	}
+	fmt.Printf("%s%s/%s %s/s #%d  %c",
+		setInfo,
+		formatSize(e.Done),
+		formatSize(e.Total),
+		formatSize(e.Speed),
+		e.Active, b)
+}
 
-		return string(body[:i])
+// taskLabel 全屏界面中任务的名称，分卷加上所属集合和序号
+func taskLabel(dt *engine.DownloadTask) string {
+	t, ok := dt.Tag.(*task)
+	if !ok || t.set == nil || len(t.set.parts) <= 1 {
+		return dt.Filename()
 	}
+	return fmt.Sprintf("[%s %d/%d] %s", t.set.name, t.vol+1, len(t.set.parts), dt.Filename())
+}
+
+func formatSize(size int64) string {
+	return engine.FormatSize(size)
 }
@@ -0,0 +1,305 @@
+// Package metrics 带标签的计数器、仪表和直方图，以 Prometheus 文本格式输出。
+//
+//	var bytes = metrics.NewCounterVec("downloader_bytes_total", "已下载字节数", "proxy")
+//	bytes.With("1.2.3.4").Add(n)
+//	http.Handle("/metrics", metrics.Default)
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Registry 一组指标，实现 http.Handler
+type Registry struct {
+	mu   sync.Mutex
+	vecs []*vec
+}
+
+// Default NewCounterVec 等函数注册到的 Registry
+var Default = new(Registry)
+
+type kind string
+
+const (
+	counterKind   kind = "counter"
+	gaugeKind     kind = "gauge"
+	histogramKind kind = "histogram"
+)
+
+// vec 一个指标名下按标签值区分的所有序列
+type vec struct {
+	name, help string
+	kind       kind
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]interface{} // 标签值以 \xff 连接为键
+	values map[string][]string
+}
+
+func (r *Registry) register(v *vec) *vec {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, o := range r.vecs {
+		if o.name == v.name {
+			panic("metrics: duplicate metric " + v.name)
+		}
+	}
+	v.series = make(map[string]interface{})
+	v.values = make(map[string][]string)
+	r.vecs = append(r.vecs, v)
+	return v
+}
+
+func (v *vec) get(values []string, create func() interface{}) interface{} {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.series[key]
+	if !ok {
+		s = create()
+		v.series[key] = s
+		v.values[key] = append([]string(nil), values...)
+	}
+	return s
+}
+
+// Counter 只增不减的计数
+type Counter struct{ bits uint64 }
+
+func (c *Counter) Inc() { c.Add(1) }
+
+// Add 增加 n，n 为负数时忽略
+func (c *Counter) Add(n float64) {
+	if n < 0 {
+		return
+	}
+	addFloat(&c.bits, n)
+}
+
+func (c *Counter) Value() float64 { return math.Float64frombits(atomic.LoadUint64(&c.bits)) }
+
+// Gauge 可增可减的当前值
+type Gauge struct{ bits uint64 }
+
+func (g *Gauge) Set(n float64) { atomic.StoreUint64(&g.bits, math.Float64bits(n)) }
+func (g *Gauge) Add(n float64) { addFloat(&g.bits, n) }
+func (g *Gauge) Inc()          { g.Add(1) }
+func (g *Gauge) Dec()          { g.Add(-1) }
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
+
+func addFloat(bits *uint64, n float64) {
+	for {
+		old := atomic.LoadUint64(bits)
+		nv := math.Float64bits(math.Float64frombits(old) + n)
+		if atomic.CompareAndSwapUint64(bits, old, nv) {
+			return
+		}
+	}
+}
+
+// Histogram 按上界分桶统计观测值
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64 // 与 buckets 对应，不累计
+	count   uint64
+	sum     float64
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.mu.Lock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+	h.mu.Unlock()
+}
+
+// DefBuckets 以秒计的延迟默认分桶
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+type CounterVec struct{ v *vec }
+type GaugeVec struct{ v *vec }
+type HistogramVec struct{ v *vec }
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labels...)
+}
+
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return Default.NewGaugeVec(name, help, labels...)
+}
+
+// NewHistogramVec buckets 为 nil 时使用 DefBuckets
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return Default.NewHistogramVec(name, help, buckets, labels...)
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{r.register(&vec{name: name, help: help, kind: counterKind, labels: labels})}
+}
+
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{r.register(&vec{name: name, help: help, kind: gaugeKind, labels: labels})}
+}
+
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &HistogramVec{r.register(&vec{name: name, help: help, kind: histogramKind, labels: labels, buckets: buckets})}
+}
+
+// With 返回标签值为 values 的序列，不存在时创建
+func (c *CounterVec) With(values ...string) *Counter {
+	return c.v.get(values, func() interface{} { return new(Counter) }).(*Counter)
+}
+
+func (g *GaugeVec) With(values ...string) *Gauge {
+	return g.v.get(values, func() interface{} { return new(Gauge) }).(*Gauge)
+}
+
+func (h *HistogramVec) With(values ...string) *Histogram {
+	return h.v.get(values, func() interface{} {
+		return &Histogram{buckets: h.v.buckets, counts: make([]uint64, len(h.v.buckets))}
+	}).(*Histogram)
+}
+
+// WriteTo 以 Prometheus 文本格式输出全部指标，序列按标签值排序
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: bufio.NewWriter(w)}
+	r.mu.Lock()
+	vecs := append([]*vec(nil), r.vecs...)
+	r.mu.Unlock()
+	for _, v := range vecs {
+		v.write(cw)
+	}
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	return cw.n, cw.err
+}
+
+func (v *vec) write(w *countWriter) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	type entry struct {
+		labels string
+		s      interface{}
+	}
+	entries := make([]entry, len(keys))
+	for i, k := range keys {
+		entries[i] = entry{formatLabels(v.labels, v.values[k]), v.series[k]}
+	}
+	v.mu.Unlock()
+
+	w.printf("# HELP %s %s\n", v.name, escapeHelp(v.help))
+	w.printf("# TYPE %s %s\n", v.name, v.kind)
+	for _, e := range entries {
+		switch s := e.s.(type) {
+		case *Counter:
+			w.printf("%s%s %s\n", v.name, wrap(e.labels), formatFloat(s.Value()))
+		case *Gauge:
+			w.printf("%s%s %s\n", v.name, wrap(e.labels), formatFloat(s.Value()))
+		case *Histogram:
+			s.mu.Lock()
+			var cum uint64
+			for i, b := range s.buckets {
+				cum += s.counts[i]
+				w.printf("%s_bucket%s %d\n", v.name, wrap(join(e.labels, `le="`+formatFloat(b)+`"`)), cum)
+			}
+			w.printf("%s_bucket%s %d\n", v.name, wrap(join(e.labels, `le="+Inf"`)), s.count)
+			w.printf("%s_sum%s %s\n", v.name, wrap(e.labels), formatFloat(s.sum))
+			w.printf("%s_count%s %d\n", v.name, wrap(e.labels), s.count)
+			s.mu.Unlock()
+		}
+	}
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+func formatLabels(names, values []string) string {
+	var b strings.Builder
+	for i, n := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(n)
+		b.WriteString(`="`)
+		b.WriteString(escapeValue(values[i]))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+func join(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "," + b
+}
+
+func wrap(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+var valueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeValue(s string) string { return valueEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+type countWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countWriter) printf(format string, a ...interface{}) {
+	if c.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(c.w, format, a...)
+	c.n += int64(n)
+	c.err = err
+}
@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	r := new(Registry)
+	c := r.NewCounterVec("bytes_total", "bytes", "proxy")
+	c.With("b").Add(2)
+	c.With(`a"x`).Inc()
+	c.With("b").Add(-1)
+	g := r.NewGaugeVec("active", "active ranges")
+	g.With().Inc()
+	g.With().Inc()
+	g.With().Dec()
+	h := r.NewHistogramVec("latency_seconds", "latency", []float64{1, 0.1}, "status")
+	h.With("206").Observe(0.05)
+	h.With("206").Observe(0.5)
+	h.With("206").Observe(3)
+
+	var b strings.Builder
+	if _, err := r.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP bytes_total bytes
+# TYPE bytes_total counter
+bytes_total{proxy="a\"x"} 1
+bytes_total{proxy="b"} 2
+# HELP active active ranges
+# TYPE active gauge
+active 1
+# HELP latency_seconds latency
+# TYPE latency_seconds histogram
+latency_seconds_bucket{status="206",le="0.1"} 1
+latency_seconds_bucket{status="206",le="1"} 2
+latency_seconds_bucket{status="206",le="+Inf"} 3
+latency_seconds_sum{status="206"} 3.55
+latency_seconds_count{status="206"} 3
+`
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestLabelCount(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic")
+		}
+	}()
+	new(Registry).NewCounterVec("x", "", "a", "b").With("1")
+}
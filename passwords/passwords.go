@@ -0,0 +1,206 @@
+// Package passwords 为加密的压缩包找密码：依次尝试该分卷集合已找到的密码、
+// 下载页面中出现的密码、站点的默认密码和配置的密码列表，找到后按分卷集合记住。
+//
+// 密码列表文件每行一个密码，# 开头为注释；[站点] 之后的密码是该站点的默认密码，
+// 站点为页面地址的主机名：
+//
+//	123123
+//	[rosefile.net]
+//	gmw1024
+package passwords
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	rar "github.com/nwaples/rardecode"
+)
+
+// maxPagePasswords 每个页面最多记录的密码个数
+const maxPagePasswords = 8
+
+var (
+	reTag      = regexp.MustCompile(`<[^>]*>`)
+	rePassword = regexp.MustCompile(`(?i)(?:解压密码|解压码|密码|password|passwd|pwd)\s*(?:[:：=]|是|为)\s*([^\s<>"'，,。;；]+)`)
+)
+
+// Provider 密码来源，可在多个 goroutine 中使用
+type Provider struct {
+	List  []string            // 配置的密码，按顺序尝试
+	Sites map[string][]string // 主机名 => 站点默认密码
+
+	mu    sync.Mutex
+	pages map[string][]string // 页面地址 => 页面中找到的密码
+	known map[string]string   // 分卷集合 => 密码
+}
+
+// Load 读取密码列表文件，文件不存在时返回空的 Provider。
+// first 中非空的密码排在列表最前，通常来自命令行参数
+func Load(name string, first ...string) (*Provider, error) {
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return &Provider{List: appendNew(nil, nonEmpty(first)...)}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	p, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	p.List = appendNew(nonEmpty(first), p.List...)
+	return p, nil
+}
+
+func nonEmpty(ps []string) (out []string) {
+	for _, p := range ps {
+		if p != "" {
+			out = append(out, p)
+		}
+	}
+	return
+}
+
+// Parse 解析密码列表，格式见包说明
+func Parse(r io.Reader) (*Provider, error) {
+	p := &Provider{Sites: make(map[string][]string)}
+	site := ""
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		switch {
+		case line == "" || line[0] == '#':
+		case line[0] == '[':
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("第 %d 行：站点应写成 [主机名]", n)
+			}
+			site = strings.ToLower(strings.TrimSpace(line[1 : len(line)-1]))
+		case site == "":
+			p.List = append(p.List, line)
+		default:
+			p.Sites[site] = append(p.Sites[site], line)
+		}
+	}
+	return p, s.Err()
+}
+
+// FromText 从页面文本中找出形如“解压密码：xxx”的密码，按出现顺序去重
+func FromText(text string) []string {
+	text = html.UnescapeString(reTag.ReplaceAllString(text, " "))
+	var out []string
+	for _, m := range rePassword.FindAllStringSubmatch(text, -1) {
+		out = appendNew(out, m[1])
+		if len(out) == maxPagePasswords {
+			break
+		}
+	}
+	return out
+}
+
+func appendNew(list []string, ps ...string) []string {
+	for _, p := range ps {
+		dup := false
+		for _, q := range list {
+			if q == p {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+// AddPage 记录下载页面中出现的密码，可作为 engine.Options.OnPage
+func (p *Provider) AddPage(webURL, text string) {
+	found := FromText(text)
+	if len(found) == 0 {
+		return
+	}
+	p.mu.Lock()
+	if p.pages == nil {
+		p.pages = make(map[string][]string)
+	}
+	p.pages[webURL] = found
+	p.mu.Unlock()
+}
+
+// setKey 分卷集合在 known 中的键：目录加第一个分卷的文件名
+func setKey(first string) string {
+	dir, file := filepath.Split(first)
+	if name, ok := rar.FirstVolumeName(file); ok {
+		file = name
+	}
+	return filepath.Join(dir, file)
+}
+
+func host(webURL string) string {
+	u, err := url.Parse(webURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// Candidates 按顺序返回要尝试的密码：该集合已找到的密码、pages 页面中的密码、
+// pages 所在站点的默认密码、密码列表。pages 为空时尝试全部站点的默认密码
+func (p *Provider) Candidates(first string, pages []string) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var out []string
+	if pw, ok := p.known[setKey(first)]; ok {
+		out = append(out, pw)
+	}
+	for _, u := range pages {
+		out = appendNew(out, p.pages[u]...)
+	}
+	if len(pages) == 0 {
+		sites := make([]string, 0, len(p.Sites))
+		for s := range p.Sites {
+			sites = append(sites, s)
+		}
+		sort.Strings(sites)
+		for _, s := range sites {
+			out = appendNew(out, p.Sites[s]...)
+		}
+	}
+	for _, u := range pages {
+		out = appendNew(out, p.Sites[host(u)]...)
+	}
+	return appendNew(out, p.List...)
+}
+
+// Find 找出能打开 first 开头的压缩包的密码，未加密时返回空字符串。
+// 只有经过校验的密码才记住供同一集合的其他分卷使用
+func (p *Provider) Find(first string, pages []string) (string, error) {
+	cands := p.Candidates(first, pages)
+	pw, verified, err := rar.FindPassword(first, cands)
+	if err != nil {
+		if err == rar.ErrNoPassword {
+			err = fmt.Errorf("尝试了 %d 个密码：%w", len(cands), err)
+		}
+		return "", err
+	}
+	if !verified {
+		return pw, nil
+	}
+	p.mu.Lock()
+	if p.known == nil {
+		p.known = make(map[string]string)
+	}
+	p.known[setKey(first)] = pw
+	p.mu.Unlock()
+	return pw, nil
+}
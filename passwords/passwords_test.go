@@ -0,0 +1,70 @@
+package passwords
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	p, err := Parse(strings.NewReader("# 通用\n123123\n\n[RoseFile.net]\ngmw1024\n pass word \n[other.com]\nx\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p.List, []string{"123123"}) {
+		t.Errorf("List = %q", p.List)
+	}
+	want := map[string][]string{"rosefile.net": {"gmw1024", "pass word"}, "other.com": {"x"}}
+	if !reflect.DeepEqual(p.Sites, want) {
+		t.Errorf("Sites = %q", p.Sites)
+	}
+	if _, err = Parse(strings.NewReader("[bad\n")); err == nil {
+		t.Error("unterminated site accepted")
+	}
+}
+
+func TestFromText(t *testing.T) {
+	text := `<p>解压密码：<b>gmw1024</b></p> 密码错误请反馈 Password = abc&amp;1, pwd:gmw1024`
+	if got := FromText(text); !reflect.DeepEqual(got, []string{"gmw1024", "abc&1"}) {
+		t.Errorf("FromText = %q", got)
+	}
+}
+
+func TestCandidates(t *testing.T) {
+	p := &Provider{
+		List:  []string{"list", "shared"},
+		Sites: map[string][]string{"rosefile.net": {"site", "shared"}, "b.com": {"b"}},
+	}
+	page := "https://rosefile.net/abc/a.part1.rar.html"
+	p.AddPage(page, "解压密码：page")
+	got := p.Candidates("/dl/a.part1.rar", []string{page})
+	if want := []string{"page", "site", "shared", "list"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Candidates = %q, want %q", got, want)
+	}
+	got = p.Candidates("/dl/a.part1.rar", nil)
+	if want := []string{"b", "site", "shared", "list"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Candidates without pages = %q, want %q", got, want)
+	}
+
+	// 记住的密码排在最前，同一集合的其他分卷名也能找到
+	p.known = map[string]string{"/dl/a.part1.rar": "known"}
+	if got = p.Candidates("/dl/a.part2.rar", nil); got[0] != "known" {
+		t.Errorf("Candidates = %q", got)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	p, err := Load(filepath.Join(t.TempDir(), "missing.txt"), "", "cli")
+	if err != nil || !reflect.DeepEqual(p.List, []string{"cli"}) {
+		t.Fatalf("Load missing file = %+v, %v", p, err)
+	}
+	name := filepath.Join(t.TempDir(), "passwords.txt")
+	if err = os.WriteFile(name, []byte("a\ncli\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if p, err = Load(name, "cli"); err != nil || !reflect.DeepEqual(p.List, []string{"cli", "a"}) {
+		t.Fatalf("Load = %+v, %v", p, err)
+	}
+}
@@ -0,0 +1,158 @@
+// Package pipeline 下载完成后的处理流程：校验、测试/解压、移动、通知。
+//
+// 一个 Job 对应一个下载任务或一个分卷集合，Pipeline 依次执行各步骤，
+// 任一步骤失败即停止，失败原因记录在 Job 中；通知类步骤无论成败都会执行。
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"downloader/layout"
+	"downloader/logx"
+	"downloader/passwords"
+
+	rar "github.com/nwaples/rardecode"
+)
+
+// State Job 的处理状态
+type State int
+
+const (
+	Pending State = iota
+	Running
+	Done
+	Failed
+)
+
+var stateNames = []string{"pending", "running", "done", "failed"}
+
+func (s State) String() string { return stateNames[s] }
+
+func (s State) MarshalText() ([]byte, error) { return []byte(s.String()), nil }
+
+// Job 一个已下载完成的任务或分卷集合
+type Job struct {
+	Name     string   `json:"name"`
+	Files    []string `json:"files"`           // 分卷按顺序排列，Files[0] 为首卷
+	Sizes    []int64  `json:"sizes,omitempty"` // 期望的文件大小，0 表示未知
+	Password string   `json:"-"`
+	Pages    []string `json:"pages,omitempty"`   // 下载页面地址，用于查找页面中的密码
+	Output   string   `json:"output,omitempty"`  // 解压目录
+	Damaged  []string `json:"damaged,omitempty"` // repair 发现损坏的文件
+
+	// Extracted 下载过程中已由 Extract.Stream 解压完成，extract 步骤跳过
+	Extracted bool `json:"extracted,omitempty"`
+
+	State State     `json:"state"`
+	Step  string    `json:"step,omitempty"` // 当前或失败的步骤
+	Err   string    `json:"error,omitempty"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Step 流程中的一个步骤
+type Step interface {
+	Name() string
+	Run(j *Job) error
+}
+
+// Pipeline Steps 依次执行，Notify 在最后总是执行
+type Pipeline struct {
+	Steps  []Step
+	Notify []Step
+	Log    *logx.Logger // nil 时不输出日志
+}
+
+// Run 执行流程，返回第一个失败步骤的错误
+func (p *Pipeline) Run(j *Job) (err error) {
+	l := p.Log
+	if l == nil {
+		l = logx.Discard
+	}
+	l = l.With(logx.F("job", j.Name))
+	j.State = Running
+	j.Start = time.Now()
+	for _, s := range p.Steps {
+		j.Step = s.Name()
+		l.Info("开始", logx.F("step", j.Step))
+		if err = s.Run(j); err != nil {
+			err = fmt.Errorf("%s: %w", j.Step, err)
+			break
+		}
+	}
+	j.End = time.Now()
+	if err != nil {
+		j.State = Failed
+		j.Err = err.Error()
+		l.Error("处理失败", logx.Err(err))
+	} else {
+		j.State = Done
+		j.Step = ""
+		l.Info("处理完成")
+	}
+	for _, s := range p.Notify {
+		if nErr := s.Run(j); nErr != nil {
+			l.Warn("通知失败", logx.F("step", s.Name()), logx.Err(nErr))
+		}
+	}
+	return err
+}
+
+// Config 由命令行参数构造 Pipeline
+type Config struct {
+	Steps    string        // 逗号分隔：verify,test,extract,move
+	Extract  layout.Layout // 解压目录
+	Unpack   rar.UnpackOptions
+	MoveTo   string
+	Exec     string // 完成后执行的命令
+	Webhook  string // 完成后 POST 的地址
+	Password string
+	// Passwords 不为 nil 时，在 test/repair/extract 之前找出加密压缩包的密码
+	Passwords *passwords.Provider
+	Log       *logx.Logger
+}
+
+// New 按配置构造 Pipeline
+func New(c *Config) (*Pipeline, error) {
+	p := &Pipeline{Log: c.Log}
+	pw := c.Passwords
+	findPassword := func() {
+		if pw != nil {
+			p.Steps = append(p.Steps, Password{Provider: pw})
+			pw = nil // 只需要一次
+		}
+	}
+	for _, name := range strings.Split(c.Steps, ",") {
+		name = strings.TrimSpace(name)
+		if name == "test" || name == "repair" || name == "extract" {
+			findPassword()
+		}
+		switch name {
+		case "", "none":
+		case "verify":
+			p.Steps = append(p.Steps, Verify{})
+		case "test":
+			p.Steps = append(p.Steps, Test{})
+		case "repair":
+			p.Steps = append(p.Steps, Repair{})
+		case "extract":
+			p.Steps = append(p.Steps, Extract{Layout: c.Extract, Options: c.Unpack})
+		case "move":
+			if c.MoveTo == "" {
+				return nil, fmt.Errorf("move 需要指定目标目录")
+			}
+			p.Steps = append(p.Steps, Move{Dir: c.MoveTo})
+		default:
+			return nil, fmt.Errorf("未知的步骤 %q", name)
+		}
+	}
+	if c.Exec != "" {
+		p.Notify = append(p.Notify, Exec{Command: c.Exec})
+	}
+	if c.Webhook != "" {
+		p.Notify = append(p.Notify, Webhook{URL: c.Webhook})
+	}
+	return p, nil
+}
@@ -0,0 +1,114 @@
+package pipeline
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"downloader/passwords"
+)
+
+type fakeStep struct {
+	name string
+	err  error
+	ran  *[]string
+}
+
+func (s fakeStep) Name() string { return s.name }
+
+func (s fakeStep) Run(j *Job) error {
+	*s.ran = append(*s.ran, s.name)
+	return s.err
+}
+
+func TestRunStopsOnFailure(t *testing.T) {
+	var ran []string
+	p := &Pipeline{
+		Steps: []Step{
+			fakeStep{"a", nil, &ran},
+			fakeStep{"b", errors.New("boom"), &ran},
+			fakeStep{"c", nil, &ran},
+		},
+		Notify: []Step{fakeStep{"n", nil, &ran}},
+	}
+	j := &Job{Name: "job"}
+	if err := p.Run(j); err == nil {
+		t.Fatal("expected error")
+	}
+	if j.State != Failed || j.Step != "b" || j.Err != "b: boom" {
+		t.Errorf("job = %v %q %q", j.State, j.Step, j.Err)
+	}
+	if want := "a b n"; join(ran) != want {
+		t.Errorf("ran %q, want %q", join(ran), want)
+	}
+}
+
+func join(s []string) (r string) {
+	for i, v := range s {
+		if i > 0 {
+			r += " "
+		}
+		r += v
+	}
+	return
+}
+
+func TestVerifyAndMove(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "a.bin")
+	if err := os.WriteFile(name, make([]byte, 10), 0644); err != nil {
+		t.Fatal(err)
+	}
+	j := &Job{Name: "a", Files: []string{name}, Sizes: []int64{11}}
+	if err := (Verify{}).Run(j); err == nil {
+		t.Error("size mismatch not detected")
+	}
+	j.Sizes[0] = 10
+	if err := (Verify{}).Run(j); err != nil {
+		t.Error(err)
+	}
+	os.WriteFile(name+".stat", []byte("0:1\n"), 0644)
+	if err := (Verify{}).Run(j); err == nil {
+		t.Error("leftover .stat not detected")
+	}
+	os.Remove(name + ".stat")
+
+	dst := filepath.Join(dir, "done")
+	if err := (Move{Dir: dst}).Run(j); err != nil {
+		t.Fatal(err)
+	}
+	if j.Files[0] != filepath.Join(dst, "a.bin") {
+		t.Errorf("moved to %q", j.Files[0])
+	}
+	if _, err := os.Stat(j.Files[0]); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(&Config{Steps: "verify,move"}); err == nil {
+		t.Error("move without target accepted")
+	}
+	p, err := New(&Config{Steps: "verify,test,extract", Exec: "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Steps) != 3 || len(p.Notify) != 1 {
+		t.Errorf("steps %d notify %d", len(p.Steps), len(p.Notify))
+	}
+
+	// 有密码来源时在第一个需要密码的步骤之前找密码
+	p, err = New(&Config{Steps: "verify,test,extract", Passwords: new(passwords.Provider)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, s := range p.Steps {
+		names = append(names, s.Name())
+	}
+	if got := strings.Join(names, ","); got != "verify,password,test,extract" {
+		t.Errorf("steps %s", got)
+	}
+}
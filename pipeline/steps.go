@@ -0,0 +1,269 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"downloader/layout"
+	"downloader/passwords"
+	"downloader/repair"
+
+	rar "github.com/nwaples/rardecode"
+)
+
+// Verify 检查文件存在、没有残留的 .stat，大小与期望一致
+type Verify struct{}
+
+func (Verify) Name() string { return "verify" }
+
+func (Verify) Run(j *Job) error {
+	for i, name := range j.Files {
+		st, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		if _, err = os.Stat(name + ".stat"); err == nil {
+			return fmt.Errorf("%s 仍有未完成的范围", name)
+		}
+		if i < len(j.Sizes) && j.Sizes[i] > 0 && st.Size() != j.Sizes[i] {
+			return fmt.Errorf("%s 大小不一致 %d!=%d", name, st.Size(), j.Sizes[i])
+		}
+	}
+	return nil
+}
+
+func isRar(name string) bool {
+	_, _, ok := rar.VolumeInfo(filepath.Base(name))
+	return ok
+}
+
+// Password 用 Provider 找出加密压缩包的密码写入 Job，未加密时为空
+type Password struct {
+	Provider *passwords.Provider
+}
+
+func (Password) Name() string { return "password" }
+
+func (p Password) Run(j *Job) error {
+	if len(j.Files) == 0 || !isRar(j.Files[0]) {
+		return nil
+	}
+	pw, err := p.Provider.Find(j.Files[0], j.Pages)
+	if err != nil {
+		return err
+	}
+	j.Password = pw
+	return nil
+}
+
+// Test 读取压缩包中全部文件校验 CRC，非 rar 文件跳过
+type Test struct{}
+
+func (Test) Name() string { return "test" }
+
+func (Test) Run(j *Job) error {
+	if len(j.Files) == 0 || !isRar(j.Files[0]) {
+		return nil
+	}
+	r, err := rar.OpenReader(j.Files[0], j.Password)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, err = io.Copy(ioutil.Discard, r); err != nil {
+			return fmt.Errorf("%s: %w", h.Name, err)
+		}
+	}
+}
+
+// Repair 与 Test 一样校验全部文件，先用恢复记录和 .rev 恢复卷在本地修复，
+// 仍然损坏的文件把它们在各分卷中的范围写入 .stat，重新下载这些分卷时只下载损坏的部分
+type Repair struct{}
+
+func (Repair) Name() string { return "repair" }
+
+func (Repair) Run(j *Job) error {
+	if len(j.Files) == 0 || !isRar(j.Files[0]) {
+		return nil
+	}
+	damages, err := repair.Run(j.Files[0], j.Password)
+	for _, d := range damages {
+		j.Damaged = append(j.Damaged, d.File)
+	}
+	if err != nil {
+		return err
+	}
+	if len(damages) > 0 {
+		return fmt.Errorf("%d 个文件损坏，已标记需要重新下载的范围：%w", len(damages), damages[0].Err)
+	}
+	return nil
+}
+
+// Extract 解压到 Layout 生成的目录，默认模板为 {set}。
+// 文件名不安全或损坏的文件不解压，出错的文件在返回的错误中列出
+type Extract struct {
+	Layout  layout.Layout
+	Options rar.UnpackOptions // 已存在文件和符号链接的处理方式
+}
+
+func (Extract) Name() string { return "extract" }
+
+func (e Extract) Run(j *Job) error {
+	if len(j.Files) == 0 || !isRar(j.Files[0]) || j.Extracted {
+		return nil
+	}
+	return e.unpack(j, nil)
+}
+
+// Stream 在分卷仍在下载时解压，open 打开下载中的分卷，读到未下载的范围时阻塞。
+// 完成后设置 j.Extracted，之后的 extract 步骤不再重复解压
+func (e Extract) Stream(j *Job, open rar.OpenFunc) error {
+	if len(j.Files) == 0 || !isRar(j.Files[0]) {
+		return nil
+	}
+	if err := e.unpack(j, open); err != nil {
+		return err
+	}
+	j.Extracted = true
+	return nil
+}
+
+// unpack 把 j 解压到 Layout 生成的目录，open 为 nil 时直接打开文件
+func (e Extract) unpack(j *Job, open rar.OpenFunc) error {
+	l := e.Layout
+	if l.Template == "" {
+		l.Template = "{set}"
+	}
+	j.Output = l.Path(layout.Vars{Name: filepath.Base(j.Files[0])})
+	r, err := rar.OpenReaderFunc(j.Files[0], j.Password, open)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return r.Unpack(j.Output, e.Options)
+}
+
+// Move 把下载的文件移动到 Dir
+type Move struct {
+	Dir string
+}
+
+func (Move) Name() string { return "move" }
+
+func (m Move) Run(j *Job) error {
+	if err := os.MkdirAll(m.Dir, 0755); err != nil {
+		return err
+	}
+	for i, name := range j.Files {
+		dst := filepath.Join(m.Dir, filepath.Base(name))
+		if _, err := os.Lstat(dst); err == nil {
+			dst = layout.Unique(dst, nil)
+		}
+		if err := moveFile(name, dst); err != nil {
+			return err
+		}
+		j.Files[i] = dst
+	}
+	return nil
+}
+
+// moveFile 优先 rename，跨分区时复制后删除
+func moveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil {
+		return nil
+	}
+	var linkErr *os.LinkError
+	if !errors.As(err, &linkErr) {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cErr := out.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	in.Close()
+	return os.Remove(src)
+}
+
+// Exec 执行用户命令，Job 信息通过环境变量传入：
+// JOB_NAME JOB_STATE JOB_ERROR JOB_OUTPUT JOB_FILES（换行分隔）
+type Exec struct {
+	Command string
+}
+
+func (Exec) Name() string { return "exec" }
+
+func (e Exec) Run(j *Job) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", e.Command)
+	} else {
+		cmd = exec.Command("sh", "-c", e.Command)
+	}
+	cmd.Env = append(os.Environ(),
+		"JOB_NAME="+j.Name,
+		"JOB_STATE="+j.State.String(),
+		"JOB_ERROR="+j.Err,
+		"JOB_OUTPUT="+j.Output,
+		"JOB_FILES="+strings.Join(j.Files, "\n"),
+	)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// Webhook 把 Job 以 JSON POST 到 URL
+type Webhook struct {
+	URL string
+}
+
+func (Webhook) Name() string { return "webhook" }
+
+func (w Webhook) Run(j *Job) error {
+	body, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(w.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected resp: %d", resp.StatusCode)
+	}
+	return nil
+}
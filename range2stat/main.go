@@ -1,22 +1,358 @@
+// range2stat .stat 文件工具：集合运算、放宽收缩、从 CSV 和 RAR 错误报告导入。
+//
+//	range2stat [-len 长度] [-o 输出] [-clamp] 命令 参数...
+//
+// 命令：
+//
+//	check    a.stat...             校验范围并显示未下载的字节数
+//	merge    [-gap N] a.stat       合并重叠和间隔不超过 N 字节的范围
+//	union    a.stat b.stat...      并集
+//	subtract a.stat b.stat...      a 减去其余文件中的范围
+//	intersect a.stat b.stat        交集
+//	invert   a.stat                取反，即已下载的部分
+//	widen    -by N a.stat          每个范围向两侧放宽 N 字节
+//	narrow   -by N a.stat          每个范围向内收缩 N 字节
+//	csv      [-cols start,len] [-data] [-margin N] 文件.csv
+//	         每行两个数（十进制或 0x 十六进制），-data 表示这些是已下载的数据，取其空隙
+//	report   [-write] 报告.txt
+//	         读取 infect_detector 输出的 "文件名 分卷 起点 终点 错误" 行，
+//	         按分卷输出损坏的范围，-write 时合并到各分卷的 .stat
+//
+// 输入范围超出文件长度时报错，-clamp 时截断。文件长度默认取 .stat 对应文件的大小。
 package main
 
-import "fmt"
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"downloader/statfile"
+)
+
+var (
+	length = flag.Int64("len", 0, "文件长度，为 0 时取第一个 .stat 对应文件的大小")
+	output = flag.String("o", "", "结果写入该文件，默认输出到标准输出")
+	clamp  = flag.Bool("clamp", false, "截断超出文件长度的范围而不是报错")
+)
+
+type command struct {
+	run   func(fs *flag.FlagSet, args []string) ([]statfile.Range, error)
+	flags func(fs *flag.FlagSet)
+}
+
+var (
+	gap      int64
+	by       int64
+	cols     string
+	isData   bool
+	margin   int64
+	writeOut bool
+)
+
+var commands = map[string]command{
+	"check": {run: cmdCheck},
+	"merge": {
+		run: func(fs *flag.FlagSet, args []string) ([]statfile.Range, error) {
+			sets, err := loadAll(args, 1, 1)
+			if err != nil {
+				return nil, err
+			}
+			return statfile.Merge(sets[0], gap), nil
+		},
+		flags: func(fs *flag.FlagSet) { fs.Int64Var(&gap, "gap", 0, "合并间隔不超过该字节数的范围") },
+	},
+	"union": {run: func(fs *flag.FlagSet, args []string) ([]statfile.Range, error) {
+		sets, err := loadAll(args, 1, -1)
+		if err != nil {
+			return nil, err
+		}
+		return statfile.Union(sets...), nil
+	}},
+	"subtract": {run: func(fs *flag.FlagSet, args []string) ([]statfile.Range, error) {
+		sets, err := loadAll(args, 2, -1)
+		if err != nil {
+			return nil, err
+		}
+		return statfile.Subtract(sets[0], statfile.Union(sets[1:]...)), nil
+	}},
+	"intersect": {run: func(fs *flag.FlagSet, args []string) ([]statfile.Range, error) {
+		sets, err := loadAll(args, 2, 2)
+		if err != nil {
+			return nil, err
+		}
+		return statfile.Intersect(sets[0], sets[1]), nil
+	}},
+	"invert": {run: func(fs *flag.FlagSet, args []string) ([]statfile.Range, error) {
+		sets, err := loadAll(args, 1, 1)
+		if err != nil {
+			return nil, err
+		}
+		return statfile.Invert(sets[0], *length), nil
+	}},
+	"widen": {
+		run:   func(fs *flag.FlagSet, args []string) ([]statfile.Range, error) { return cmdWiden(args, by) },
+		flags: func(fs *flag.FlagSet) { fs.Int64Var(&by, "by", 16384, "字节数") },
+	},
+	"narrow": {
+		run:   func(fs *flag.FlagSet, args []string) ([]statfile.Range, error) { return cmdWiden(args, -by) },
+		flags: func(fs *flag.FlagSet) { fs.Int64Var(&by, "by", 16384, "字节数") },
+	},
+	"csv": {
+		run: cmdCSV,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&cols, "cols", "start,len", "两列的含义：start,len 或 start,end")
+			fs.BoolVar(&isData, "data", false, "输入为已下载的数据，输出它们之间的空隙")
+			fs.Int64Var(&margin, "margin", 0, "输出的范围向两侧放宽的字节数")
+		},
+	},
+	"report": {
+		run:   cmdReport,
+		flags: func(fs *flag.FlagSet) { fs.BoolVar(&writeOut, "write", false, "合并写入各分卷的 .stat") },
+	},
+}
 
 func main() {
-	ranges := []rg{
-		{0x0, 0x61c30000},
-		{0x62b90000, 0x2070000},
-		{0x65750000, 0x2070000},
-		{0x684d0000, 0x1b20000},
-		{0x6b080000, 0x6b080000},
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintln(os.Stderr, "未知的命令", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+	fs := flag.NewFlagSet(flag.Arg(0), flag.ExitOnError)
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	fs.Parse(flag.Args()[1:])
+	rs, err := cmd.run(fs, fs.Args())
+	if err == nil && rs != nil {
+		err = emit(rs)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "用法: range2stat [-len 长度] [-o 输出] [-clamp] check|merge|union|subtract|intersect|invert|widen|narrow|csv|report 参数...")
+	flag.PrintDefaults()
+}
+
+// emit 校验结果并输出，结果为空时输出空文件
+func emit(rs []statfile.Range) error {
+	if err := statfile.Validate(rs, *length); err != nil {
+		return err
+	}
+	if *output != "" {
+		return statfile.Write(*output, rs)
+	}
+	_, err := os.Stdout.Write(statfile.Format(rs))
+	return err
+}
+
+// loadAll 读取 min 到 max 个 .stat 文件（max < 0 为不限），并确定文件长度
+func loadAll(args []string, min, max int) ([][]statfile.Range, error) {
+	if len(args) < min || (max >= 0 && len(args) > max) {
+		if max < 0 {
+			return nil, fmt.Errorf("需要至少 %d 个 .stat 文件，得到 %d 个", min, len(args))
+		}
+		return nil, fmt.Errorf("需要 %d 到 %d 个 .stat 文件，得到 %d 个", min, max, len(args))
+	}
+	if *length <= 0 {
+		if st, err := os.Stat(strings.TrimSuffix(args[0], ".stat")); err == nil && !st.IsDir() {
+			*length = st.Size()
+		}
+	}
+	if *length <= 0 {
+		return nil, errors.New("无法确定文件长度，请用 -len 指定")
+	}
+	sets := make([][]statfile.Range, len(args))
+	for i, name := range args {
+		rs, err := statfile.Read(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if sets[i], err = limit(rs, *length); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return sets, nil
+}
+
+// limit 校验范围不超出 size，-clamp 时截断
+func limit(rs []statfile.Range, size int64) ([]statfile.Range, error) {
+	if *clamp {
+		return statfile.Normalize(rs, size), nil
+	}
+	return rs, statfile.Validate(rs, size)
+}
+
+func cmdCheck(fs *flag.FlagSet, args []string) ([]statfile.Range, error) {
+	if len(args) == 0 {
+		return nil, errors.New("需要 .stat 文件")
 	}
-	for i := 1; i < len(ranges); i++ {
-		end := ranges[i-1].len + ranges[i-1].start
-		fmt.Printf("%d:%d\n", end-16384, ranges[i].start+16384)
+	for _, name := range args {
+		size := *length
+		if size <= 0 {
+			st, err := os.Stat(strings.TrimSuffix(name, ".stat"))
+			if err != nil {
+				return nil, err
+			}
+			size = st.Size()
+		}
+		rs, err := statfile.Read(name)
+		if err == nil {
+			err = statfile.Validate(rs, size)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		fmt.Printf("%s: %d 个范围，未下载 %d / %d 字节\n", name, len(rs), statfile.Remain(rs, size), size)
+	}
+	return nil, nil
+}
+
+func cmdWiden(args []string, n int64) ([]statfile.Range, error) {
+	sets, err := loadAll(args, 1, 1)
+	if err != nil {
+		return nil, err
 	}
+	return statfile.Widen(sets[0], n, *length), nil
 }
 
-type rg struct {
-	start int
-	len   int
+// cmdCSV 读取 CSV，不是数字的行（如表头）被跳过
+func cmdCSV(fs *flag.FlagSet, args []string) ([]statfile.Range, error) {
+	if len(args) != 1 {
+		return nil, errors.New("需要一个 CSV 文件，- 为标准输入")
+	}
+	if *length <= 0 {
+		return nil, errors.New("请用 -len 指定文件长度")
+	}
+	if cols != "start,len" && cols != "start,end" {
+		return nil, fmt.Errorf("无效的 -cols %q", cols)
+	}
+	var in io.Reader = os.Stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		in = f
+	}
+	rd := csv.NewReader(in)
+	rd.FieldsPerRecord = -1
+	rd.TrimLeadingSpace = true
+	var rs []statfile.Range
+	for line := 1; ; line++ {
+		rec, err := rd.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rec) < 2 {
+			continue
+		}
+		a, err1 := parseNum(rec[0])
+		b, err2 := parseNum(rec[1])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		if cols == "start,len" {
+			b += a
+		}
+		rs = append(rs, statfile.Range{Start: a, End: b})
+		if err = statfile.Validate(rs[len(rs)-1:], 0); err != nil {
+			return nil, fmt.Errorf("第 %d 行: %w", line, err)
+		}
+	}
+	rs, err := limit(rs, *length)
+	if err != nil {
+		return nil, err
+	}
+	if isData {
+		rs = statfile.Invert(rs, *length)
+	}
+	return statfile.Widen(rs, margin, *length), nil
+}
+
+func parseNum(s string) (int64, error) {
+	return strconv.ParseInt(strings.TrimSpace(s), 0, 64)
+}
+
+// reportLine 匹配 "分卷 起点 终点"，分卷为 .rar .rNN .sNN 等
+var reportLine = regexp.MustCompile(`(\S+\.(?:rar|[r-z]\d\d))\s+(\d+)\s+(\d+)(?:\s|$)`)
+
+func cmdReport(fs *flag.FlagSet, args []string) ([]statfile.Range, error) {
+	if len(args) != 1 {
+		return nil, errors.New("需要一个报告文件，- 为标准输入")
+	}
+	var in io.Reader = os.Stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		in = f
+	}
+	vols := make(map[string][]statfile.Range)
+	scan := bufio.NewScanner(in)
+	for scan.Scan() {
+		m := reportLine.FindStringSubmatch(scan.Text())
+		if m == nil {
+			continue
+		}
+		start, _ := strconv.ParseInt(m[2], 10, 64)
+		end, _ := strconv.ParseInt(m[3], 10, 64)
+		vols[m[1]] = append(vols[m[1]], statfile.Range{Start: start, End: end})
+	}
+	if err := scan.Err(); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(vols))
+	for name := range vols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		st, err := os.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		rs, err := limit(vols[name], st.Size())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		rs = statfile.Union(rs)
+		if !writeOut {
+			fmt.Printf("# %s\n%s", name, statfile.Format(rs))
+			continue
+		}
+		old, err := statfile.Read(name + ".stat")
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err = statfile.Write(name+".stat", statfile.Union(old, rs)); err != nil {
+			return nil, err
+		}
+		fmt.Printf("%s: 写入 %d 个损坏的范围\n", name, len(rs))
+	}
+	return nil, nil
 }
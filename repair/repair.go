@@ -0,0 +1,254 @@
+// Package repair 找出压缩包中损坏的文件，先尝试用分卷中的恢复记录和 .rev 恢复卷
+// 在本地修复，修复不了的文件把它们在各分卷中占用的范围写入 .stat，之后重新下载时
+// 只会下载这些范围。
+package repair
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"downloader/statfile"
+
+	rar "github.com/nwaples/rardecode"
+)
+
+// Span 分卷文件中的一段 [Start, End)
+type Span struct {
+	Volume     string `json:"volume"`
+	Start, End int64
+}
+
+// Damage 一个校验或解压失败的文件
+type Damage struct {
+	File  string `json:"file"`
+	Err   error  `json:"-"`
+	Spans []Span `json:"spans"`
+}
+
+type pos struct {
+	vol string
+	off int64
+}
+
+// Check 读取压缩包中的全部文件，返回校验失败的文件及其数据所在的范围。
+// 解压库返回 ErrRange 时使用其中各分卷的数据范围；否则文件的范围从它的数据起点
+// 到下一个文件的数据起点，包含了下一个文件的头部。
+// 读取文件头失败时，从最后一个损坏的文件到最后一个分卷末尾都视为损坏；
+// 之前没有损坏的文件时，读取失败的分卷从最后一个文件头（不在该分卷时从开头）
+// 到末尾视为损坏，以该分卷名作为 File。两种情况都返回该错误
+func Check(first, password string) (damages []Damage, err error) {
+	r, err := rar.OpenReader(first, password)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var bad *Damage
+	var from, prev pos
+	for {
+		h, err := r.Next()
+		var to pos
+		if err == nil {
+			to = pos{h.VolName, h.Offset}
+		} else {
+			vols := r.Volumes()
+			last := vols[len(vols)-1]
+			to = pos{last, fileSize(last)}
+			if bad == nil && err != io.EOF {
+				bad = &Damage{File: last, Err: err}
+				from = pos{last, 0}
+				if prev.vol == last {
+					from = prev
+				}
+			}
+		}
+		prev = to
+		if bad != nil {
+			if bad.Spans == nil {
+				bad.Spans = spans(r.Volumes(), from, to)
+			}
+			damages = append(damages, *bad)
+			bad = nil
+		}
+		if err == io.EOF {
+			return damages, nil
+		}
+		if err != nil {
+			return damages, err
+		}
+		if _, err = io.Copy(ioutil.Discard, r); err != nil {
+			bad = &Damage{File: h.Name, Err: err}
+			from = to
+			var re *rar.ErrRange
+			if errors.As(err, &re) {
+				for _, p := range re.Ranges() {
+					bad.Spans = append(bad.Spans, Span{Volume: p.File, Start: p.Start, End: p.End})
+				}
+			}
+		}
+	}
+}
+
+// spans 把 from 到 to 之间的数据按分卷拆开，vols 为按顺序打开的分卷
+func spans(vols []string, from, to pos) (ss []Span) {
+	in := false
+	for _, v := range vols {
+		if v == from.vol {
+			in = true
+		}
+		if !in {
+			continue
+		}
+		s := Span{Volume: v, End: fileSize(v)}
+		if v == from.vol {
+			s.Start = from.off
+		}
+		if v == to.vol {
+			s.End = to.off
+		}
+		if s.Start < s.End {
+			ss = append(ss, s)
+		}
+		if v == to.vol {
+			break
+		}
+	}
+	return
+}
+
+func fileSize(name string) int64 {
+	st, err := os.Stat(name)
+	if err != nil {
+		return 0
+	}
+	return st.Size()
+}
+
+// Mark 把范围合并到各分卷的 .stat，返回写入的分卷，按文件名排序
+func Mark(ss []Span) ([]string, error) {
+	vols := make(map[string][]statfile.Range)
+	for _, s := range ss {
+		vols[s.Volume] = append(vols[s.Volume], statfile.Range{Start: s.Start, End: s.End})
+	}
+	names := make([]string, 0, len(vols))
+	for name := range vols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		rs := statfile.Union(vols[name])
+		if err := statfile.Validate(rs, fileSize(name)); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		old, err := statfile.Read(name + ".stat")
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err = statfile.Write(name+".stat", statfile.Union(old, rs)); err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
+
+// Local 用恢复记录检查并修复分卷集合中的每个分卷，再用 .rev 恢复卷重建缺失的分卷、
+// 恢复记录修复不了的分卷和 damaged 中仍未修复的分卷，返回修复或重建的分卷。
+// 没有恢复数据或损坏太多时不返回错误
+func Local(first, password string, damaged []string) ([]string, error) {
+	vols, err := volumes(first)
+	if err != nil {
+		return nil, err
+	}
+	var fixed, bad []string
+	done := make(map[string]bool)
+	for _, v := range vols {
+		rr, err := rar.ReadRecoveryRecord(v, password)
+		if err == rar.ErrNoRecoveryRecord {
+			continue
+		}
+		n := 0
+		if err == nil {
+			n, err = rr.Repair()
+		}
+		switch {
+		case err != nil:
+			bad = append(bad, v)
+		case n > 0:
+			fixed = append(fixed, v)
+		}
+		done[v] = err == nil
+	}
+	for _, v := range damaged {
+		if _, ok := done[v]; !ok {
+			bad = append(bad, v)
+		}
+	}
+	rebuilt, err := rar.RebuildVolumes(first, bad)
+	fixed = append(fixed, rebuilt...)
+	if errors.Is(err, rar.ErrNoRecoveryVolumes) || errors.Is(err, rar.ErrTooDamaged) {
+		err = nil
+	}
+	return fixed, err
+}
+
+// volumes 与 first 同一集合的已存在分卷，按文件名排序
+func volumes(first string) ([]string, error) {
+	dir, file := filepath.Split(first)
+	key, ok := rar.FirstVolumeName(file)
+	if !ok {
+		return []string{first}, nil
+	}
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var vols []string
+	for _, e := range entries {
+		if k, ok := rar.FirstVolumeName(e.Name()); ok && k == key && !e.IsDir() {
+			vols = append(vols, filepath.Join(filepath.Dir(first), e.Name()))
+		}
+	}
+	return vols, nil
+}
+
+// damagedVolumes 损坏范围涉及的分卷，按出现顺序排列
+func damagedVolumes(damages []Damage) []string {
+	var vols []string
+	seen := make(map[string]bool)
+	for _, d := range damages {
+		for _, s := range d.Spans {
+			if !seen[s.Volume] {
+				seen[s.Volume] = true
+				vols = append(vols, s.Volume)
+			}
+		}
+	}
+	return vols
+}
+
+// Run 检查压缩包，有损坏或缺少分卷时先在本地修复并重新检查，
+// 然后标记仍然损坏的范围，返回损坏的文件。本地修复出错时仍按检查结果标记
+func Run(first, password string) ([]Damage, error) {
+	damages, err := Check(first, password)
+	if len(damages) > 0 || err != nil {
+		fixed, _ := Local(first, password, damagedVolumes(damages))
+		if len(fixed) > 0 {
+			damages, err = Check(first, password)
+		}
+	}
+	var ss []Span
+	for _, d := range damages {
+		ss = append(ss, d.Spans...)
+	}
+	if _, merr := Mark(ss); merr != nil && err == nil {
+		err = merr
+	}
+	return damages, err
+}
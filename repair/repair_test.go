@@ -0,0 +1,255 @@
+package repair
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"downloader/statfile"
+)
+
+// block 生成一个 RAR 1.5 格式的块，头部 CRC 取 crc32 的低 16 位
+func block(typ byte, flags uint16, body []byte) []byte {
+	h := make([]byte, 7, 7+len(body))
+	h[2] = typ
+	binary.LittleEndian.PutUint16(h[3:], flags)
+	binary.LittleEndian.PutUint16(h[5:], uint16(7+len(body)))
+	h = append(h, body...)
+	binary.LittleEndian.PutUint16(h, uint16(crc32.ChecksumIEEE(h[2:])))
+	return h
+}
+
+// fileBlock 不压缩的文件块，crc 为整个文件的 CRC
+func fileBlock(name string, flags uint16, size int, crc uint32, data []byte) []byte {
+	b := make([]byte, 25, 25+len(name))
+	binary.LittleEndian.PutUint32(b, uint32(len(data)))
+	binary.LittleEndian.PutUint32(b[4:], uint32(size))
+	b[8] = 2 // windows
+	binary.LittleEndian.PutUint32(b[9:], crc)
+	b[17] = 29
+	b[18] = 0x30 // stored
+	binary.LittleEndian.PutUint16(b[19:], uint16(len(name)))
+	b = append(b, name...)
+	return append(block(0x74, flags|0x8000, b), data...)
+}
+
+type entry struct {
+	name string
+	data []byte
+}
+
+// writeVolumes 写入不压缩的分卷，cut 为每个分卷中数据的字节数，返回各分卷的路径
+func writeVolumes(t *testing.T, dir string, files []entry, cut int) []string {
+	var vols [][]byte
+	cur := new(bytes.Buffer)
+	room := cut
+	start := func(n int) {
+		cur.Reset()
+		cur.Write([]byte("Rar!\x1a\x07\x00"))
+		flags := uint16(0x0001 | 0x0010) // volume, new naming
+		if n == 0 {
+			flags |= 0x0100
+		}
+		cur.Write(block(0x73, flags, make([]byte, 6)))
+		room = cut
+	}
+	finish := func(last bool) {
+		var flags uint16
+		if !last {
+			flags = 0x0001
+		}
+		cur.Write(block(0x7b, flags, nil))
+		vols = append(vols, append([]byte(nil), cur.Bytes()...))
+	}
+	start(0)
+	for _, f := range files {
+		crc := crc32.ChecksumIEEE(f.data)
+		data := f.data
+		var flags uint16
+		for {
+			if room == 0 {
+				finish(false)
+				start(len(vols))
+			}
+			n := len(data)
+			if n > room {
+				n = room
+			}
+			fl := flags
+			if n < len(data) {
+				fl |= 0x0002
+			}
+			cur.Write(fileBlock(f.name, fl, len(f.data), crc, data[:n]))
+			room -= n
+			data = data[n:]
+			flags = 0x0001
+			if len(data) == 0 {
+				break
+			}
+		}
+	}
+	finish(true)
+	var names []string
+	for i, v := range vols {
+		name := filepath.Join(dir, "a.part"+string(rune('1'+i))+".rar")
+		if err := os.WriteFile(name, v, 0644); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func fill(n int, c byte) []byte {
+	return bytes.Repeat([]byte{c}, n)
+}
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	vols := writeVolumes(t, dir, []entry{
+		{"one.bin", fill(300, 0x11)},
+		{"two.bin", fill(500, 0x22)}, // 跨越第 1、2 个分卷
+		{"three.bin", fill(100, 0x33)},
+	}, 600)
+	if len(vols) != 2 {
+		t.Fatalf("%d volumes", len(vols))
+	}
+	damages, err := Check(vols[0], "")
+	if err != nil || len(damages) != 0 {
+		t.Fatalf("clean archive: %v %v", damages, err)
+	}
+
+	// 破坏 two.bin 在第 2 个分卷中的数据
+	b, _ := os.ReadFile(vols[1])
+	i := bytes.IndexByte(b, 0x22)
+	b[i+10] ^= 0xff
+	os.WriteFile(vols[1], b, 0644)
+
+	damages, err = Run(vols[0], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(damages) != 1 || damages[0].File != "two.bin" || damages[0].Err == nil {
+		t.Fatalf("got %+v", damages)
+	}
+	ss := damages[0].Spans
+	if len(ss) != 2 || ss[0].Volume != vols[0] || ss[1].Volume != vols[1] {
+		t.Fatalf("spans %+v", ss)
+	}
+	v0, _ := os.ReadFile(vols[0])
+	if ss[0].Start != int64(bytes.Index(v0, fill(300, 0x22))) || ss[0].End != ss[0].Start+300 {
+		t.Errorf("first span %+v", ss[0])
+	}
+	if ss[1].Start != int64(i) || ss[1].End != int64(i+200) {
+		t.Errorf("second span %+v, data at %d", ss[1], i)
+	}
+	for _, s := range ss {
+		rs, err := statfile.Read(s.Volume + ".stat")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []statfile.Range{{Start: s.Start, End: s.End}}; !reflect.DeepEqual(rs, want) {
+			t.Errorf("%s.stat = %v, want %v", s.Volume, rs, want)
+		}
+	}
+}
+
+func TestCheckHeader(t *testing.T) {
+	dir := t.TempDir()
+	vols := writeVolumes(t, dir, []entry{
+		{"one.bin", fill(300, 0x11)},
+		{"two.bin", fill(500, 0x22)},
+		{"three.bin", fill(100, 0x33)},
+	}, 600)
+	// 破坏第 2 个分卷中 three.bin 的文件头，之前没有损坏的文件
+	b, _ := os.ReadFile(vols[1])
+	b[bytes.Index(b, []byte("three.bin"))] ^= 0xff
+	os.WriteFile(vols[1], b, 0644)
+
+	damages, err := Check(vols[0], "")
+	if err == nil {
+		t.Fatal("no error for a damaged header")
+	}
+	want := []Span{{Volume: vols[1], Start: 0, End: int64(len(b))}}
+	if len(damages) != 1 || !reflect.DeepEqual(damages[0].Spans, want) {
+		t.Fatalf("got %+v, want spans %+v", damages, want)
+	}
+}
+
+func TestVolumes(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "[x]")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	vols := writeVolumes(t, dir, []entry{{"one.bin", fill(900, 0x11)}}, 600)
+	for _, name := range []string{"a.rar", "a.part1.rar.stat", "b.part1.rar"} {
+		os.WriteFile(filepath.Join(dir, name), nil, 0644)
+	}
+	got, err := volumes(vols[0])
+	if err != nil || !reflect.DeepEqual(got, vols) {
+		t.Errorf("volumes = %q %v, want %q", got, err, vols)
+	}
+}
+
+// addRecoveryRecord 在分卷的结束块之前插入 RR 恢复记录：每 512 字节一个 CRC 低 16 位，
+// 后面是 sectors 个异或校验扇区
+func addRecoveryRecord(t *testing.T, name string, sectors int) {
+	v, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	end := append([]byte(nil), v[len(v)-7:]...)
+	v = v[:len(v)-7]
+	n := (len(v) + 511) / 512
+	data := make([]byte, n*2+sectors*512)
+	for i := 0; i < n; i++ {
+		s := make([]byte, 512)
+		copy(s, v[i*512:])
+		binary.LittleEndian.PutUint16(data[i*2:], uint16(crc32.ChecksumIEEE(s)))
+		p := data[n*2+i%sectors*512:]
+		for j, c := range s {
+			p[j] ^= c
+		}
+	}
+	b := make([]byte, 25, 64)
+	binary.LittleEndian.PutUint32(b, uint32(len(data)))
+	b[17] = 29
+	b[18] = 0x30
+	binary.LittleEndian.PutUint16(b[19:], 2)
+	b = append(b, "RRProtect+\x00\x00\x00\x00"...)
+	binary.LittleEndian.PutUint32(b[len(b)-4:], uint32(sectors))
+	v = append(append(v, block(0x7a, 0x8000, b)...), data...)
+	if err = os.WriteFile(name, append(v, end...), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunRecoveryRecord(t *testing.T) {
+	dir := t.TempDir()
+	vols := writeVolumes(t, dir, []entry{
+		{"one.bin", fill(300, 0x11)},
+		{"two.bin", fill(500, 0x22)},
+		{"three.bin", fill(100, 0x33)},
+	}, 600)
+	addRecoveryRecord(t, vols[1], 2)
+	want, _ := os.ReadFile(vols[1])
+
+	b := append([]byte(nil), want...)
+	b[bytes.Index(b, fill(100, 0x33))+5] ^= 0xff
+	os.WriteFile(vols[1], b, 0644)
+
+	damages, err := Run(vols[0], "")
+	if err != nil || len(damages) != 0 {
+		t.Fatalf("Run = %+v, %v", damages, err)
+	}
+	if b, _ = os.ReadFile(vols[1]); !bytes.Equal(b, want) {
+		t.Error("volume not repaired")
+	}
+	if _, err = os.Stat(vols[1] + ".stat"); !os.IsNotExist(err) {
+		t.Errorf("repaired volume marked for download: %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+// Package serve 以 HTTP 提供下载目录中的文件，支持 Range 请求。
+//
+// 下载完成的文件直接从磁盘读取；下载中的文件通过 engine.TaskReader 读取，
+// 请求的范围尚未下载时等待，并让代理优先下载该位置，
+// 播放器等工具可以在下载过程中读取文件。
+package serve
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"downloader/engine"
+)
+
+// Server 下载目录的 HTTP 文件服务
+type Server struct {
+	Root string
+
+	files http.Handler
+	mu    sync.Mutex
+	tasks []*engine.DownloadTask
+}
+
+// New 返回提供 root 目录中文件的 Server
+func New(root string) *Server {
+	return &Server{Root: root, files: http.FileServer(http.Dir(root))}
+}
+
+// Track 记录下载任务，请求它的文件且未下载完成时从任务读取。
+// 可在 EventQueued 中调用，重新下载的任务也会加入
+func (s *Server) Track(t *engine.DownloadTask) {
+	s.mu.Lock()
+	s.tasks = append(s.tasks, t)
+	s.mu.Unlock()
+}
+
+// task 返回输出文件为 name 的任务，后加入的优先
+func (s *Server) task(name string) *engine.DownloadTask {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.tasks) - 1; i >= 0; i-- {
+		if abs(s.tasks[i].Filename()) == name {
+			return s.tasks[i]
+		}
+	}
+	return nil
+}
+
+func abs(name string) string {
+	if a, err := filepath.Abs(name); err == nil {
+		return a
+	}
+	return filepath.Clean(name)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := abs(filepath.Join(s.Root, filepath.FromSlash(path.Clean("/"+r.URL.Path))))
+	if !engine.Finished(name) {
+		if t := s.task(name); t != nil {
+			s.serveTask(w, r, t, name)
+			return
+		}
+		if _, err := os.Stat(name + ".stat"); err == nil {
+			http.Error(w, "文件未下载完成", http.StatusServiceUnavailable)
+			return
+		}
+	}
+	s.files.ServeHTTP(w, r)
+}
+
+// serveTask 从下载中的任务读取文件，客户端断开时结束等待
+func (s *Server) serveTask(w http.ResponseWriter, r *http.Request, t *engine.DownloadTask, name string) {
+	tr := t.NewReader()
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		tr.Close()
+	}()
+	go func() {
+		select {
+		case <-r.Context().Done():
+			tr.Close()
+		case <-done:
+		}
+	}()
+	http.ServeContent(w, r, filepath.Base(name), time.Time{}, tr)
+}
@@ -0,0 +1,67 @@
+package serve
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"downloader/engine"
+	"downloader/layout"
+)
+
+func TestServe(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.bin"), []byte("0123456789"), 0644)
+	os.WriteFile(filepath.Join(dir, "part.bin"), make([]byte, 10), 0644)
+	os.WriteFile(filepath.Join(dir, "part.bin.stat"), []byte("0:10\n"), 0644)
+
+	s := New(dir)
+	sess := engine.NewClient(engine.Options{Layout: layout.Layout{Root: dir, Template: "{name}"}}).NewSession()
+	s.Track(sess.Add("https://rosefile.net/abc/b.bin.html"))
+	srv := httptest.NewServer(s)
+
+	req, _ := http.NewRequest("GET", srv.URL+"/a.bin", nil)
+	req.Header.Set("Range", "bytes=2-5")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent || string(b) != "2345" {
+		t.Errorf("range of a finished file: %d %q", resp.StatusCode, b)
+	}
+
+	resp, err = http.Get(srv.URL + "/part.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("unfinished file without a task: %d", resp.StatusCode)
+	}
+
+	// 任务还没有开始，请求等待到客户端放弃，处理函数随之返回
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req, _ = http.NewRequestWithContext(ctx, "GET", srv.URL+"/b.bin", nil)
+	if resp, err = http.DefaultClient.Do(req); err == nil {
+		resp.Body.Close()
+		t.Errorf("request for a task not started returned %d", resp.StatusCode)
+	}
+	closed := make(chan struct{})
+	go func() {
+		srv.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler still waiting after the client went away")
+	}
+}
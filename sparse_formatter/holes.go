@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"downloader/statfile"
+)
+
+const blockSize = 0x10000
+
+// errNoSparse 系统不支持查询文件的空洞
+var errNoSparse = errors.New("不支持查询稀疏文件")
+
+// findHoles 返回文件中的空洞，按起点排序。
+// 文件系统不支持查询时改为查找全零的块
+func findHoles(f *os.File, size int64, zeros bool) ([]statfile.Range, error) {
+	if !zeros {
+		data, err := dataRanges(f, size)
+		if err == nil {
+			return invert(data, size), nil
+		}
+		if err != errNoSparse {
+			return nil, err
+		}
+	}
+	return zeroRuns(f, size)
+}
+
+// invert 返回 [0, size) 中不在 data 里的部分，data 需按起点排序且不重叠
+func invert(data []statfile.Range, size int64) (holes []statfile.Range) {
+	var pos int64
+	for _, d := range data {
+		if d.Start > pos {
+			holes = append(holes, statfile.Range{Start: pos, End: d.Start})
+		}
+		if d.End > pos {
+			pos = d.End
+		}
+	}
+	if pos < size {
+		holes = append(holes, statfile.Range{Start: pos, End: size})
+	}
+	return
+}
+
+// zeroRuns 查找以 blockSize 对齐的全零块，连续的合并为一个范围
+func zeroRuns(r io.ReaderAt, size int64) (holes []statfile.Range, err error) {
+	buf := make([]byte, blockSize)
+	for off := int64(0); off < size; off += blockSize {
+		n, err := r.ReadAt(buf, off)
+		if err != nil && !(err == io.EOF && off+int64(n) == size) {
+			return nil, err
+		}
+		if !allZero(buf[:n]) {
+			continue
+		}
+		end := off + int64(n)
+		if k := len(holes); k > 0 && holes[k-1].End == off {
+			holes[k-1].End = end
+		} else {
+			holes = append(holes, statfile.Range{Start: off, End: end})
+		}
+	}
+	return
+}
+
+// pendingRanges 把空洞转换为 .stat 中的未下载范围：
+// 起点向前收缩到上一段数据的最后一个非 0 字节，终点向后延伸到下一段数据的第一个非 0 字节，
+// 再向两侧放宽 margin
+func pendingRanges(r io.ReaderAt, size int64, holes []statfile.Range, margin int64) ([]statfile.Range, error) {
+	rs := make([]statfile.Range, 0, len(holes))
+	var prevEnd int64
+	for i, h := range holes {
+		start, err := lastNonZero(r, prevEnd, h.Start)
+		if err != nil {
+			return nil, err
+		}
+		next := size
+		if i+1 < len(holes) {
+			next = holes[i+1].Start
+		}
+		end, err := firstNonZero(r, h.End, next)
+		if err != nil {
+			return nil, err
+		}
+		rg := statfile.Range{Start: start - margin, End: end + margin}
+		if rg.Start < 0 {
+			rg.Start = 0
+		}
+		if rg.End > size {
+			rg.End = size
+		}
+		rs = append(rs, rg)
+		prevEnd = h.End
+	}
+	return statfile.Normalize(rs, size), nil
+}
+
+// lastNonZero 在 [lo, hi) 中从后向前查找最后一个非 0 字节的位置，全为 0 时返回 lo
+func lastNonZero(r io.ReaderAt, lo, hi int64) (int64, error) {
+	buf := make([]byte, blockSize)
+	for hi > lo {
+		start := hi - blockSize
+		if start < lo {
+			start = lo
+		}
+		b := buf[:hi-start]
+		if _, err := r.ReadAt(b, start); err != nil && err != io.EOF {
+			return 0, err
+		}
+		for i := len(b) - 1; i >= 0; i-- {
+			if b[i] != 0 {
+				return start + int64(i), nil
+			}
+		}
+		hi = start
+	}
+	return lo, nil
+}
+
+// firstNonZero 在 [lo, hi) 中从前向后查找第一个非 0 字节的位置，全为 0 时返回 hi
+func firstNonZero(r io.ReaderAt, lo, hi int64) (int64, error) {
+	buf := make([]byte, blockSize)
+	for lo < hi {
+		end := lo + blockSize
+		if end > hi {
+			end = hi
+		}
+		b := buf[:end-lo]
+		n, err := r.ReadAt(b, lo)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		for i, c := range b[:n] {
+			if c != 0 {
+				return lo + int64(i), nil
+			}
+		}
+		if n < len(b) {
+			return hi, nil
+		}
+		lo = end
+	}
+	return hi, nil
+}
+
+func allZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
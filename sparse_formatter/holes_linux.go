@@ -0,0 +1,39 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+
+	"downloader/statfile"
+)
+
+// dataRanges 用 SEEK_DATA/SEEK_HOLE 列出已分配的区域
+func dataRanges(f *os.File, size int64) (data []statfile.Range, err error) {
+	fd := int(f.Fd())
+	var off int64
+	for off < size {
+		start, err := unix.Seek(fd, off, unix.SEEK_DATA)
+		if err == unix.ENXIO { // 之后全是空洞
+			break
+		}
+		if err == unix.EINVAL {
+			return nil, errNoSparse
+		}
+		if err != nil {
+			return nil, err
+		}
+		end, err := unix.Seek(fd, start, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, err
+		}
+		if end > size {
+			end = size
+		}
+		data = append(data, statfile.Range{Start: start, End: end})
+		off = end
+	}
+	return data, nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !windows
+
+package main
+
+import (
+	"os"
+
+	"downloader/statfile"
+)
+
+func dataRanges(f *os.File, size int64) ([]statfile.Range, error) {
+	return nil, errNoSparse
+}
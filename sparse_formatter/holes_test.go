@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"downloader/statfile"
+)
+
+// sparseFile 1M 的文件，只有 [0, 0x10010) 和 [0x80000, 0x90000) 写入了非 0 数据
+func sparseFile(t *testing.T) *os.File {
+	f, err := os.Create(filepath.Join(t.TempDir(), "a.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	head := make([]byte, 0x10010)
+	for i := range head {
+		head[i] = 1
+	}
+	f.WriteAt(head, 0)
+	f.WriteAt(head[:0x10000], 0x80000)
+	f.Truncate(1 << 20)
+	return f
+}
+
+func TestZeroRuns(t *testing.T) {
+	f := sparseFile(t)
+	holes, err := findHoles(f, 1<<20, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []statfile.Range{{Start: 0x20000, End: 0x80000}, {Start: 0x90000, End: 1 << 20}}
+	if !reflect.DeepEqual(holes, want) {
+		t.Errorf("got %v, want %v", holes, want)
+	}
+	rs, err := pendingRanges(f, 1<<20, holes, 0x100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 第一个空洞前的块只写了 0x10 字节，收缩到最后一个非 0 字节 0x1000f
+	want = []statfile.Range{{Start: 0x1000f - 0x100, End: 0x80100}, {Start: 0x8ffff - 0x100, End: 1 << 20}}
+	if !reflect.DeepEqual(rs, want) {
+		t.Errorf("got %v, want %v", rs, want)
+	}
+}
+
+func TestDataRanges(t *testing.T) {
+	f := sparseFile(t)
+	holes, err := findHoles(f, 1<<20, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 文件系统按自己的块大小分配，只检查空洞落在全零的区域内
+	for _, h := range holes {
+		if h.Start < 0x10010 || (h.Start < 0x90000 && h.End > 0x80000) {
+			t.Errorf("hole %v overlaps data", h)
+		}
+	}
+	if len(holes) == 0 {
+		t.Log("file system reports no holes")
+	}
+}
+
+func TestPendingRangesUnaligned(t *testing.T) {
+	// 数据不按块对齐：[0, 0x100) 和 [0x30100, 0x30200)，
+	// 块 0x30000 开头未下载的 0 也要算作未下载
+	f, err := os.Create(filepath.Join(t.TempDir(), "a.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data := make([]byte, 0x100)
+	for i := range data {
+		data[i] = 1
+	}
+	f.WriteAt(data, 0)
+	f.WriteAt(data, 0x30100)
+	f.Truncate(0x60000)
+	holes, err := findHoles(f, 0x60000, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []statfile.Range{{Start: 0x10000, End: 0x30000}, {Start: 0x40000, End: 0x60000}}
+	if !reflect.DeepEqual(holes, want) {
+		t.Fatalf("got %v, want %v", holes, want)
+	}
+	rs, err := pendingRanges(f, 0x60000, holes, 0x10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []statfile.Range{{Start: 0xff - 0x10, End: 0x30100 + 0x10}, {Start: 0x301ff - 0x10, End: 0x60000}}
+	if !reflect.DeepEqual(rs, want) {
+		t.Errorf("got %v, want %v", rs, want)
+	}
+}
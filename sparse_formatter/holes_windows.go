@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"downloader/statfile"
+)
+
+type fileAllocatedRangeBuffer struct {
+	FileOffset int64
+	Length     int64
+}
+
+// dataRanges 用 FSCTL_QUERY_ALLOCATED_RANGES 列出已分配的区域，
+// 结果超过缓冲区时从最后一段之后继续查询
+func dataRanges(f *os.File, size int64) (data []statfile.Range, err error) {
+	h := windows.Handle(f.Fd())
+	query := fileAllocatedRangeBuffer{0, size}
+	var resp [1024]fileAllocatedRangeBuffer
+	for query.Length > 0 {
+		var ret uint32
+		err = windows.DeviceIoControl(h, windows.FSCTL_QUERY_ALLOCATED_RANGES,
+			(*byte)(unsafe.Pointer(&query)), uint32(unsafe.Sizeof(query)),
+			(*byte)(unsafe.Pointer(&resp)), uint32(unsafe.Sizeof(resp)),
+			&ret, nil)
+		more := err == windows.ERROR_MORE_DATA
+		if err != nil && !more {
+			if err == windows.ERROR_INVALID_FUNCTION {
+				return nil, errNoSparse
+			}
+			return nil, err
+		}
+		n := int(ret) / int(unsafe.Sizeof(resp[0]))
+		for _, r := range resp[:n] {
+			data = append(data, statfile.Range{Start: r.FileOffset, End: r.FileOffset + r.Length})
+		}
+		if !more || n == 0 {
+			break
+		}
+		end := data[len(data)-1].End
+		query = fileAllocatedRangeBuffer{end, size - end}
+	}
+	return data, nil
+}
@@ -1,87 +1,102 @@
+// sparse_formatter 根据稀疏文件中未分配的空洞重建 .stat。
+//
+// Linux 使用 SEEK_DATA/SEEK_HOLE，Windows 使用 FSCTL_QUERY_ALLOCATED_RANGES，
+// 其他系统或指定 -zeros 时按 64K 块查找全零的区域。空洞前的数据末尾如果是 0，
+// 可能是写到一半的块，向前收缩到最后一个非 0 字节，再向两侧各放宽 -margin 字节。
+//
+//	sparse_formatter [-margin 16384] [-zeros] [-force] [-n] [文件或目录...]
 package main
 
 import (
-	"bytes"
+	"flag"
 	"fmt"
-	"golang.org/x/sys/windows"
-	"io"
 	"os"
-	"syscall"
-	"unsafe"
+	"path/filepath"
+	"strings"
+
+	"downloader/statfile"
 )
 
-type FileAllocatedRangeBuffer struct {
-	FileOffset int64
-	Length     int64
-}
+var (
+	margin = flag.Int64("margin", 16384, "每个未下载范围向两侧放宽的字节数")
+	zeros  = flag.Bool("zeros", false, "不查询文件系统，按全零的块查找空洞")
+	force  = flag.Bool("force", false, "覆盖已存在的 .stat")
+	dryRun = flag.Bool("n", false, "只显示结果，不写入 .stat")
+)
 
 func main() {
-	curDir, err := os.Open(".")
-	if err != nil {
-		panic(err)
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 {
+		args = []string{"."}
 	}
-	list, err := curDir.ReadDir(0)
-	if err != nil {
-		panic(err)
-	}
-	var queryRange FileAllocatedRangeBuffer
-	var respRange [1024]FileAllocatedRangeBuffer
-	buf := make([]byte, 0x10000)
-	for _, file := range list {
-		if file.IsDir() {
+	code := 0
+	for _, arg := range args {
+		st, err := os.Stat(arg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = 1
 			continue
 		}
-		f, err := os.Open(file.Name())
-		if err != nil {
-			panic(err)
+		if !st.IsDir() {
+			if err = recoverFile(arg); err != nil {
+				fmt.Fprintln(os.Stderr, arg, err)
+				code = 1
+			}
+			continue
 		}
-		queryRange.Length, _ = f.Seek(0, io.SeekEnd)
-		h := syscall.Handle(f.Fd())
-		var ret uint32
-		err = syscall.DeviceIoControl(h, windows.FSCTL_QUERY_ALLOCATED_RANGES,
-			(*byte)(unsafe.Pointer(&queryRange)),
-			uint32(unsafe.Sizeof(queryRange)),
-			(*byte)(unsafe.Pointer(&respRange)),
-			uint32(unsafe.Sizeof(queryRange))*1024,
-			&ret, nil)
+		list, err := os.ReadDir(arg)
 		if err != nil {
-			panic(err)
+			fmt.Fprintln(os.Stderr, err)
+			code = 1
+			continue
 		}
-		ret /= 8 * 2
-		var i uint32
-		var end int64
-		var stat bytes.Buffer
-		for end != queryRange.Length {
-			if i > ret {
-				break
+		for _, e := range list {
+			if e.IsDir() || strings.HasSuffix(e.Name(), ".stat") {
+				continue
 			}
-			for {
-				if end < 0x10000 {
-					end = 0
-					break
-				}
-				end -= 0x10000
-				f.ReadAt(buf, end)
-				var last int64
-				for i, v := range buf {
-					if v != 0 {
-						last = int64(i)
-					}
-				}
-				if last != 0 {
-					end = end + last
-					break
-				}
+			if err = recoverFile(filepath.Join(arg, e.Name())); err != nil {
+				fmt.Fprintln(os.Stderr, e.Name(), err)
+				code = 1
 			}
-			if respRange[i].FileOffset != 0 {
-				fmt.Fprintf(&stat, "%d-%d\n", end, respRange[i].FileOffset)
-				fmt.Println(f.Name(), end, respRange[i].FileOffset)
-			}
-			end = respRange[i].FileOffset + respRange[i].Length
-			i++
-		}
-		if stat.Len() != 0 {
-			os.WriteFile(f.Name()+".stat", stat.Bytes(), 0644)
 		}
 	}
+	os.Exit(code)
+}
+
+func recoverFile(name string) error {
+	statName := name + ".stat"
+	if _, err := os.Stat(statName); err == nil && !*force && !*dryRun {
+		fmt.Println(name, "已有 .stat，跳过")
+		return nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	holes, err := findHoles(f, st.Size(), *zeros)
+	if err != nil {
+		return err
+	}
+	rs, err := pendingRanges(f, st.Size(), holes, *margin)
+	if err != nil {
+		return err
+	}
+	if len(rs) == 0 {
+		fmt.Println(name, "没有空洞")
+		return nil
+	}
+	fmt.Printf("%s %d 个未下载范围，共 %d 字节\n", name, len(rs), statfile.Remain(rs, st.Size()))
+	for _, r := range rs {
+		fmt.Println(" ", r)
+	}
+	if *dryRun {
+		return nil
+	}
+	return statfile.Write(statName, rs)
 }
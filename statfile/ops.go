@@ -0,0 +1,105 @@
+package statfile
+
+import "fmt"
+
+// 以下运算的结果都经过 Normalize：按起点排序、互不重叠，Active 标记被丢弃
+
+// Union 返回出现在任意一组中的范围
+func Union(sets ...[]Range) []Range {
+	var all []Range
+	for _, rs := range sets {
+		all = append(all, rs...)
+	}
+	return clean(Normalize(all, 0))
+}
+
+// Merge 合并重叠以及间隔不超过 gap 字节的范围
+func Merge(rs []Range, gap int64) []Range {
+	rs = clean(Normalize(rs, 0))
+	out := rs[:0]
+	for _, r := range rs {
+		if n := len(out); n > 0 && r.Start-out[n-1].End <= gap {
+			if r.End > out[n-1].End {
+				out[n-1].End = r.End
+			}
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// Subtract 返回 a 中不在 b 里的部分
+func Subtract(a, b []Range) []Range {
+	a = clean(Normalize(a, 0))
+	b = clean(Normalize(b, 0))
+	var out []Range
+	j := 0
+	for _, r := range a {
+		for j < len(b) && b[j].End <= r.Start {
+			j++
+		}
+		start := r.Start
+		for k := j; k < len(b) && b[k].Start < r.End; k++ {
+			if b[k].Start > start {
+				out = append(out, Range{Start: start, End: b[k].Start})
+			}
+			if b[k].End > start {
+				start = b[k].End
+			}
+		}
+		if start < r.End {
+			out = append(out, Range{Start: start, End: r.End})
+		}
+	}
+	return out
+}
+
+// Intersect 返回同时在 a 和 b 中的部分
+func Intersect(a, b []Range) []Range {
+	return Subtract(a, Subtract(a, b))
+}
+
+// Invert 返回 [0, length) 中不在 rs 里的部分
+func Invert(rs []Range, length int64) []Range {
+	return Subtract([]Range{{Start: 0, End: length}}, rs)
+}
+
+// Widen 把每个范围向两侧扩大 n 字节并截断到 [0, length)，n 为负数时向内收缩，收缩到空的范围被丢弃
+func Widen(rs []Range, n, length int64) []Range {
+	out := make([]Range, 0, len(rs))
+	for _, r := range clean(Normalize(rs, 0)) {
+		r.Start -= n
+		r.End += n
+		if r.Start < 0 {
+			r.Start = 0
+		}
+		if length > 0 && r.End > length {
+			r.End = length
+		}
+		if r.Start < r.End {
+			out = append(out, r)
+		}
+	}
+	return clean(Normalize(out, 0))
+}
+
+// Validate 检查每个范围都满足 0 <= Start < End <= length
+func Validate(rs []Range, length int64) error {
+	for _, r := range rs {
+		switch {
+		case r.Start < 0 || r.Start >= r.End:
+			return fmt.Errorf("无效的范围 %d:%d", r.Start, r.End)
+		case length > 0 && r.End > length:
+			return fmt.Errorf("范围 %d:%d 超出文件长度 %d", r.Start, r.End, length)
+		}
+	}
+	return nil
+}
+
+func clean(rs []Range) []Range {
+	for i := range rs {
+		rs[i].Active = false
+	}
+	return rs
+}
@@ -0,0 +1,235 @@
+// Package statfile 读写下载任务的 .stat 分片文件。
+//
+// 每行一个未下载的范围 "cur:end"，分隔符可以是任意一个非数字字符（sparse_formatter
+// 输出的是 "cur-end"），# 开头的行为注释。引擎保存时在正在接收数据的范围后加 " *"，
+// 旧的解析代码读到 end 后即停止，不受影响。
+package statfile
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Range 未下载的范围 [Start, End)
+type Range struct {
+	Start, End int64
+	Active     bool // 保存时正在接收数据
+}
+
+func (r Range) Len() int64 { return r.End - r.Start }
+
+func (r Range) String() string {
+	if r.Active {
+		return fmt.Sprintf("%d:%d *", r.Start, r.End)
+	}
+	return fmt.Sprintf("%d:%d", r.Start, r.End)
+}
+
+// Parse 读取全部范围，保持文件中的顺序，跳过 start >= end 的行。
+// 遇到无效的行时跳过并继续，返回已读到的范围和第一个错误
+func Parse(r io.Reader) (rs []Range, err error) {
+	scan := bufio.NewScanner(r)
+	n := 0
+	for scan.Scan() {
+		n++
+		line := strings.TrimSpace(scan.Text())
+		if len(line) < 3 || line[0] == '#' {
+			continue
+		}
+		rg, e := ParseLine(line)
+		if e != nil {
+			if err == nil {
+				err = fmt.Errorf("第 %d 行: %w", n, e)
+			}
+			continue
+		}
+		if rg.Start < rg.End {
+			rs = append(rs, rg)
+		}
+	}
+	if err == nil {
+		err = scan.Err()
+	}
+	return
+}
+
+// ParseLine 解析一行 "cur:end"，后面可以跟 " *"
+func ParseLine(line string) (r Range, err error) {
+	i := 0
+	for i < len(line) && line[i] >= '0' && line[i] <= '9' {
+		i++
+	}
+	if i == 0 || i+1 >= len(line) {
+		return r, fmt.Errorf("无效的范围 %q", line)
+	}
+	j := i + 1
+	for j < len(line) && line[j] >= '0' && line[j] <= '9' {
+		j++
+	}
+	if j == i+1 {
+		return r, fmt.Errorf("无效的范围 %q", line)
+	}
+	if r.Start, err = strconv.ParseInt(line[:i], 10, 64); err != nil {
+		return
+	}
+	if r.End, err = strconv.ParseInt(line[i+1:j], 10, 64); err != nil {
+		return
+	}
+	r.Active = strings.TrimSpace(line[j:]) == "*"
+	return
+}
+
+// Read 读取 name 中的范围
+func Read(name string) ([]Range, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Format 生成文件内容
+func Format(rs []Range) []byte {
+	var buf bytes.Buffer
+	for _, r := range rs {
+		buf.WriteString(r.String())
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// Write 覆盖写入 name
+func Write(name string, rs []Range) error {
+	return os.WriteFile(name, Format(rs), 0644)
+}
+
+// Remain 统计未下载的字节数，超出 length 的部分不计，length <= 0 时不限制
+func Remain(rs []Range, length int64) (n int64) {
+	for _, r := range Normalize(rs, length) {
+		n += r.Len()
+	}
+	return
+}
+
+// Normalize 按起点排序，截断到 length，合并重叠的范围，合并后只要有一段正在接收即为 Active。
+// length <= 0 时不截断
+func Normalize(rs []Range, length int64) []Range {
+	out := make([]Range, 0, len(rs))
+	for _, r := range rs {
+		if length > 0 && r.End > length {
+			r.End = length
+		}
+		if r.Start < r.End {
+			out = append(out, r)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Start < out[j].Start })
+	merged := out[:0]
+	for _, r := range out {
+		if n := len(merged); n > 0 && r.Start <= merged[n-1].End {
+			last := &merged[n-1]
+			if r.End > last.End {
+				last.End = r.End
+			}
+			last.Active = last.Active || r.Active
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// State 一段的状态
+type State string
+
+const (
+	Done    State = "done"
+	Pending State = "pending"
+	Active  State = "active"
+)
+
+// Extent 文件中连续的一段
+type Extent struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+	State State `json:"state"`
+}
+
+// Extents 把未下载的范围展开为覆盖整个文件的连续分段
+func Extents(rs []Range, length int64) (es []Extent) {
+	var pos int64
+	for _, r := range Normalize(rs, length) {
+		if r.Start > pos {
+			es = append(es, Extent{pos, r.Start, Done})
+		}
+		st := Pending
+		if r.Active {
+			st = Active
+		}
+		es = append(es, Extent{r.Start, r.End, st})
+		pos = r.End
+	}
+	if pos < length {
+		es = append(es, Extent{pos, length, Done})
+	}
+	return
+}
+
+// Map 把长度为 length 的文件画成 n 格：# 已下载，. 未下载，= 部分下载，> 正在接收的位置
+func Map(rs []Range, length int64, n int) string {
+	cells := make([]byte, n)
+	if length <= 0 {
+		for i := range cells {
+			cells[i] = '.'
+		}
+		return string(cells)
+	}
+	rs = Normalize(rs, length)
+	j := 0
+	for i := range cells {
+		lo := length * int64(i) / int64(n)
+		hi := length * int64(i+1) / int64(n)
+		var missing int64
+		for j < len(rs) && rs[j].End <= lo {
+			j++
+		}
+		for k := j; k < len(rs) && rs[k].Start < hi; k++ {
+			missing += min64(rs[k].End, hi) - max64(rs[k].Start, lo)
+		}
+		switch {
+		case missing <= 0:
+			cells[i] = '#'
+		case missing >= hi-lo:
+			cells[i] = '.'
+		default:
+			cells[i] = '='
+		}
+	}
+	for _, r := range rs {
+		if r.Active {
+			cells[r.Start*int64(n)/length] = '>'
+		}
+	}
+	return string(cells)
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
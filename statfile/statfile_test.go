@@ -0,0 +1,84 @@
+package statfile
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	in := "# a.rar\n0:100\n200-300 *\n400:400\n\n500:600 x\n"
+	rs, err := Parse(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Range{{0, 100, false}, {200, 300, true}, {500, 600, false}}
+	if !reflect.DeepEqual(rs, want) {
+		t.Errorf("got %v, want %v", rs, want)
+	}
+	if got := string(Format(want)); got != "0:100\n200:300 *\n500:600\n" {
+		t.Errorf("format %q", got)
+	}
+	rs, err = Parse(strings.NewReader("abc\n1:2\n"))
+	if err == nil || len(rs) != 1 {
+		t.Errorf("got %v, %v", rs, err)
+	}
+}
+
+func TestExtents(t *testing.T) {
+	rs := []Range{{50, 80, false}, {10, 20, true}, {15, 30, false}, {90, 200, false}}
+	want := []Extent{
+		{0, 10, Done}, {10, 30, Active}, {30, 50, Done}, {50, 80, Pending}, {80, 90, Done}, {90, 100, Pending},
+	}
+	if got := Extents(rs, 100); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := Remain(rs, 100); got != 60 {
+		t.Errorf("remain %d", got)
+	}
+}
+
+func TestMap(t *testing.T) {
+	rs := []Range{{20, 40, true}, {75, 100, false}}
+	if got, want := Map(rs, 100, 10), "##>.###=.."; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := Map(nil, 0, 4); got != "...." {
+		t.Errorf("unknown length: %q", got)
+	}
+}
+
+func TestOps(t *testing.T) {
+	a := []Range{{Start: 0, End: 10}, {Start: 20, End: 30}, {Start: 50, End: 60}}
+	b := []Range{{Start: 5, End: 25}, {Start: 55, End: 70}}
+	cases := []struct {
+		name      string
+		got, want []Range
+	}{
+		{"union", Union(a, b), []Range{{0, 30, false}, {50, 70, false}}},
+		{"subtract", Subtract(a, b), []Range{{0, 5, false}, {25, 30, false}, {50, 55, false}}},
+		{"intersect", Intersect(a, b), []Range{{5, 10, false}, {20, 25, false}, {55, 60, false}}},
+		{"invert", Invert(a, 100), []Range{{10, 20, false}, {30, 50, false}, {60, 100, false}}},
+		{"merge", Merge(a, 10), []Range{{0, 30, false}, {50, 60, false}}},
+		{"widen", Widen(a, 5, 62), []Range{{0, 35, false}, {45, 62, false}}},
+		{"narrow", Widen(a, -4, 0), []Range{{4, 6, false}, {24, 26, false}, {54, 56, false}}},
+		{"narrow-empty", Widen(a, -5, 0), []Range{}},
+	}
+	for _, c := range cases {
+		if len(c.got) == 0 && len(c.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(c.got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, c.got, c.want)
+		}
+	}
+	if err := Validate(a, 55); err == nil {
+		t.Error("expected length error")
+	}
+	if err := Validate([]Range{{Start: 5, End: 5}}, 0); err == nil {
+		t.Error("expected empty range error")
+	}
+	if err := Validate(a, 60); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,136 @@
+// status 显示下载中文件的已下载、未下载和正在下载的部分。
+//
+//	status [-w 64] [-l] [-json] 文件或 .stat 文件...
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"downloader/statfile"
+)
+
+var (
+	width   = flag.Int("w", 64, "分布图的宽度")
+	detail  = flag.Bool("l", false, "列出每一段")
+	asJSON  = flag.Bool("json", false, "以 JSON 输出")
+	sizeArg = flag.Int64("size", 0, "文件长度，默认取文件大小")
+)
+
+type report struct {
+	File    string            `json:"file"`
+	Length  int64             `json:"length"`
+	Done    int64             `json:"done"`
+	Pending int64             `json:"pending"`
+	Active  int               `json:"active"`
+	Extents []statfile.Extent `json:"extents"`
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "用法: status [-w 64] [-l] [-json] 文件...")
+		os.Exit(2)
+	}
+	var reports []report
+	code := 0
+	for _, arg := range flag.Args() {
+		r, err := load(arg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, arg, err)
+			code = 1
+			continue
+		}
+		if *asJSON {
+			reports = append(reports, r)
+		} else {
+			printReport(r)
+		}
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(reports)
+	}
+	os.Exit(code)
+}
+
+func load(arg string) (r report, err error) {
+	name := strings.TrimSuffix(arg, ".stat")
+	r.File = name
+	rs, err := statfile.Read(name + ".stat")
+	if os.IsNotExist(err) {
+		// 没有 .stat 的文件已下载完成
+		if _, e := os.Stat(name); e != nil {
+			return r, e
+		}
+		err = nil
+	} else if err != nil && rs == nil {
+		return
+	}
+	r.Length = *sizeArg
+	if r.Length <= 0 {
+		if st, e := os.Stat(name); e == nil {
+			r.Length = st.Size()
+		}
+	}
+	if r.Length <= 0 {
+		for _, rg := range rs {
+			if rg.End > r.Length {
+				r.Length = rg.End
+			}
+		}
+	}
+	r.Extents = statfile.Extents(rs, r.Length)
+	for _, e := range r.Extents {
+		switch e.State {
+		case statfile.Done:
+			r.Done += e.End - e.Start
+		case statfile.Active:
+			r.Active++
+			fallthrough
+		default:
+			r.Pending += e.End - e.Start
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, arg, err)
+	}
+	return r, nil
+}
+
+func printReport(r report) {
+	pct := 100.0
+	if r.Length > 0 {
+		pct = float64(r.Done) * 100 / float64(r.Length)
+	}
+	fmt.Printf("%s  %s  已下载 %.1f%%  未下载 %s  正在下载 %d\n",
+		r.File, formatSize(r.Length), pct, formatSize(r.Pending), r.Active)
+	var rs []statfile.Range
+	for _, e := range r.Extents {
+		if e.State != statfile.Done {
+			rs = append(rs, statfile.Range{Start: e.Start, End: e.End, Active: e.State == statfile.Active})
+		}
+	}
+	fmt.Printf("[%s]\n", statfile.Map(rs, r.Length, *width))
+	if !*detail {
+		return
+	}
+	for _, e := range r.Extents {
+		fmt.Printf("  %-8s %14d %14d  %s\n", e.State, e.Start, e.End, formatSize(e.End-e.Start))
+	}
+}
+
+func formatSize(size int64) string {
+	ending := []string{" B", "KB", "MB", "GB", "TB"}
+	sf := float64(size)
+	n := 0
+	for sf > 1024 && n < len(ending)-1 {
+		sf /= 1024
+		n++
+	}
+	return fmt.Sprintf("%.03f%s", sf, ending[n])
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !windows
+
+package tui
+
+import "os"
+
+func IsTerminal(f *os.File) bool {
+	st, err := f.Stat()
+	return err == nil && st.Mode()&os.ModeCharDevice != 0
+}
+
+func Size(f *os.File) (w, h int) { return 80, 24 }
+
+func enableANSI(f *os.File) error { return nil }
@@ -0,0 +1,413 @@
+// Package tui 全屏显示下载队列、各任务的分片分布、代理状态和最近的日志。
+//
+// UI 通过 Handle 接收引擎事件，通过 Write 接收日志，Start 之后按固定间隔重绘。
+// 输出不是终端时调用方应继续使用普通的进度输出，见 IsTerminal。
+package tui
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"downloader/engine"
+	"downloader/statfile"
+)
+
+const logKeep = 500
+
+type taskState byte
+
+const (
+	taskQueued taskState = iota
+	taskRunning
+	taskDone
+	taskFailed
+	taskSkipped
+)
+
+var taskMark = [...]string{" ", ">", "✓", "x", "-"}
+
+type taskRow struct {
+	t      *engine.DownloadTask
+	state  taskState
+	done   int64
+	total  int64
+	speed  int64
+	active int
+}
+
+type proxyRow struct {
+	addr    string
+	task    *engine.DownloadTask
+	start   int64 // 当前分片的起点
+	busy    bool
+	base    int64 // 已结束的分片下载的字节
+	errors  int
+	lastErr string
+
+	last  int64 // 上次重绘时的累计字节
+	speed float64
+}
+
+// UI 可在多个 goroutine 中并发调用 Handle 和 Write
+type UI struct {
+	// Label 返回任务在队列和进度中显示的名称，默认为文件名
+	Label func(t *engine.DownloadTask) string
+
+	out *os.File
+
+	mu      sync.Mutex
+	tasks   []*taskRow
+	byTask  map[*engine.DownloadTask]*taskRow
+	proxies map[string]*proxyRow
+	logs    []string
+	partial []byte
+	drawn   time.Time
+
+	stop   chan struct{}
+	done   chan struct{}
+	once   sync.Once
+	closed bool // Close 之后日志直接输出到 out
+}
+
+func New(out *os.File) *UI {
+	return &UI{
+		out:     out,
+		byTask:  make(map[*engine.DownloadTask]*taskRow),
+		proxies: make(map[string]*proxyRow),
+	}
+}
+
+// Handle 记录事件，不调用任何引擎方法，可以在持有引擎锁时调用
+func (u *UI) Handle(e engine.Event) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	row := u.byTask[e.Task]
+	if row == nil && e.Task != nil {
+		row = &taskRow{t: e.Task}
+		u.byTask[e.Task] = row
+		u.tasks = append(u.tasks, row)
+	}
+	switch e.Kind {
+	case engine.EventStarted:
+		row.state = taskRunning
+	case engine.EventProgress:
+		row.state = taskRunning
+		row.done, row.total, row.speed, row.active = e.Done, e.Total, e.Speed, e.Active
+	case engine.EventCompleted:
+		row.state = taskDone
+		row.done = row.total
+		row.speed, row.active = 0, 0
+	case engine.EventFailed:
+		row.state = taskFailed
+	case engine.EventSkipped:
+		row.state = taskSkipped
+	case engine.EventRangeAssigned:
+		p := u.proxy(e.Proxy)
+		p.task, p.start, p.busy = e.Task, e.Start, true
+	case engine.EventRangeDone:
+		p := u.proxy(e.Proxy)
+		p.base += e.End - e.Start
+		p.busy = false
+	case engine.EventProxyError:
+		p := u.proxy(e.Proxy)
+		if p.busy && e.Start > p.start {
+			p.base += e.Start - p.start
+		}
+		p.busy = false
+		p.errors++
+		if e.Err != nil {
+			p.lastErr = e.Err.Error()
+		}
+	}
+}
+
+func (u *UI) proxy(addr string) *proxyRow {
+	p := u.proxies[addr]
+	if p == nil {
+		p = &proxyRow{addr: addr}
+		u.proxies[addr] = p
+	}
+	return p
+}
+
+// Write 按行保存日志，只保留最近的 logKeep 行
+func (u *UI) Write(p []byte) (int, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.closed {
+		return u.out.Write(p)
+	}
+	u.partial = append(u.partial, p...)
+	for {
+		i := bytes.IndexByte(u.partial, '\n')
+		if i == -1 {
+			break
+		}
+		u.logs = append(u.logs, string(u.partial[:i]))
+		u.partial = u.partial[i+1:]
+	}
+	if len(u.logs) > logKeep*2 {
+		u.logs = append(u.logs[:0], u.logs[len(u.logs)-logKeep:]...)
+	}
+	return len(p), nil
+}
+
+// Start 切换到备用屏幕，每隔 interval 重绘一次
+func (u *UI) Start(interval time.Duration) {
+	enableANSI(u.out)
+	u.out.WriteString("\x1b[?1049h\x1b[?25l")
+	u.stop = make(chan struct{})
+	u.done = make(chan struct{})
+	go func() {
+		defer close(u.done)
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+		for {
+			u.draw()
+			select {
+			case <-tick.C:
+			case <-u.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close 停止重绘并恢复屏幕，最后几行日志输出到普通屏幕上，之后的日志直接输出。
+// 可以多次调用，也可以在信号处理等其他 goroutine 中调用
+func (u *UI) Close() {
+	if u.stop == nil {
+		return
+	}
+	u.once.Do(func() {
+		close(u.stop)
+		<-u.done
+		u.out.WriteString("\x1b[?25h\x1b[?1049l")
+		u.mu.Lock()
+		tail := u.logs
+		if len(tail) > 20 {
+			tail = tail[len(tail)-20:]
+		}
+		for _, l := range tail {
+			fmt.Fprintln(u.out, l)
+		}
+		u.closed = true
+		u.mu.Unlock()
+	})
+}
+
+func (u *UI) draw() {
+	w, h := Size(u.out)
+	lines := u.render(w, h, time.Now())
+	var buf bytes.Buffer
+	buf.WriteString("\x1b[H")
+	for i, l := range lines {
+		buf.WriteString(l)
+		buf.WriteString("\x1b[K")
+		if i < len(lines)-1 {
+			buf.WriteString("\r\n")
+		}
+	}
+	buf.WriteString("\x1b[J")
+	u.out.Write(buf.Bytes())
+}
+
+// render 生成一屏内容，每行不超过 w 列，共不超过 h 行
+func (u *UI) render(w, h int, now time.Time) []string {
+	// 先在锁外取各任务的分片，任务的锁可能在 Handle 调用期间被持有
+	u.mu.Lock()
+	var running []*engine.DownloadTask
+	for _, r := range u.tasks {
+		if r.state == taskRunning {
+			running = append(running, r.t)
+		}
+	}
+	u.mu.Unlock()
+	ranges := make(map[*engine.DownloadTask][]engine.Range, len(running))
+	cur := make(map[string]int64) // 代理 -> 当前分片已下载到的位置
+	for _, t := range running {
+		rs := t.Ranges()
+		ranges[t] = rs
+		for _, r := range rs {
+			if r.Proxy != "" {
+				cur[r.Proxy] = r.Start
+			}
+		}
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	dt := now.Sub(u.drawn).Seconds()
+	u.drawn = now
+	var total float64
+	proxies := make([]*proxyRow, 0, len(u.proxies))
+	busy := 0
+	for _, p := range u.proxies {
+		n := p.base
+		if c, ok := cur[p.addr]; ok && p.busy && c > p.start {
+			n += c - p.start
+		}
+		if dt > 0 && dt < 60 {
+			p.speed = float64(n-p.last) / dt
+		}
+		p.last = n
+		total += p.speed
+		if p.busy {
+			busy++
+		}
+		proxies = append(proxies, p)
+	}
+	sort.Slice(proxies, func(i, j int) bool {
+		if proxies[i].speed != proxies[j].speed {
+			return proxies[i].speed > proxies[j].speed
+		}
+		return proxies[i].addr < proxies[j].addr
+	})
+
+	var lines []string
+	add := func(s string) { lines = append(lines, fit(s, w)) }
+	finished := 0
+	for _, r := range u.tasks {
+		if r.state == taskDone || r.state == taskSkipped {
+			finished++
+		}
+	}
+	add(fmt.Sprintf("任务 %d/%d  代理 %d（活动 %d）  速度 %s/s",
+		finished, len(u.tasks), len(u.proxies), busy, engine.FormatSize(int64(total))))
+
+	// 队列：保证第一个进行中的任务可见
+	queueRows := min(len(u.tasks), max(3, h/5))
+	add("── 队列 " + strings.Repeat("─", max(0, w-8)))
+	first := 0
+	for i, r := range u.tasks {
+		if r.state == taskRunning || r.state == taskQueued {
+			first = max(0, i-1)
+			break
+		}
+	}
+	if first+queueRows > len(u.tasks) {
+		first = max(0, len(u.tasks)-queueRows)
+	}
+	for _, r := range u.tasks[first : first+queueRows] {
+		size := ""
+		if r.total > 0 {
+			size = engine.FormatSize(r.total)
+		}
+		add(fmt.Sprintf(" %s %-10s %s", taskMark[r.state], size, u.label(r.t)))
+	}
+
+	// 进行中的任务
+	add("── 下载 " + strings.Repeat("─", max(0, w-8)))
+	for _, r := range u.tasks {
+		if r.state != taskRunning {
+			continue
+		}
+		pct := 0.0
+		if r.total > 0 {
+			pct = float64(r.done) * 100 / float64(r.total)
+		}
+		add(fmt.Sprintf(" %s  %5.1f%% %s/s #%d", u.label(r.t), pct, engine.FormatSize(r.speed), r.active))
+		add(" [" + rangeMap(ranges[r.t], r.t.Length(), max(10, w-4)) + "]")
+	}
+
+	// 日志至少占 4 行，其余给代理表
+	proxyRows := min(len(proxies), max(0, h-len(lines)-2-5))
+	add("── 代理 " + strings.Repeat("─", max(0, w-8)))
+	for _, p := range proxies[:proxyRows] {
+		state := "空闲"
+		if p.busy {
+			state = "下载"
+		}
+		name := ""
+		if p.busy && p.task != nil {
+			name = p.task.Name()
+		}
+		add(fmt.Sprintf(" %-15s %s %10s/s 错误 %-4d %-24s %s", p.addr, state,
+			engine.FormatSize(int64(p.speed)), p.errors, fit(name, 24), p.lastErr))
+	}
+
+	add("── 日志 " + strings.Repeat("─", max(0, w-8)))
+	logRows := max(0, h-len(lines))
+	tail := u.logs
+	if len(tail) > logRows {
+		tail = tail[len(tail)-logRows:]
+	}
+	for _, l := range tail {
+		add(l)
+	}
+	if len(lines) > h {
+		lines = lines[:h]
+	}
+	return lines
+}
+
+func (u *UI) label(t *engine.DownloadTask) string {
+	if u.Label != nil {
+		return u.Label(t)
+	}
+	if t.Filename() != "" {
+		return t.Filename()
+	}
+	return t.Name()
+}
+
+// rangeMap 画出任务的分片分布，见 statfile.Map
+func rangeMap(ranges []engine.Range, length int64, n int) string {
+	rs := make([]statfile.Range, len(ranges))
+	for i, r := range ranges {
+		rs[i] = statfile.Range{Start: r.Start, End: r.End, Active: r.Active}
+	}
+	return statfile.Map(rs, length, n)
+}
+
+// fit 按显示宽度截断到 w 列
+func fit(s string, w int) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range s {
+		rw := runeWidth(r)
+		if n+rw > w {
+			break
+		}
+		b.WriteRune(r)
+		n += rw
+	}
+	return b.String()
+}
+
+// runeWidth 东亚宽字符占两列，控制字符不占列
+func runeWidth(r rune) int {
+	switch {
+	case r < 0x20 || r == 0x7f:
+		return 0
+	case r >= 0x1100 && r <= 0x115f,
+		r >= 0x2e80 && r <= 0xa4cf && r != 0x303f,
+		r >= 0xac00 && r <= 0xd7a3,
+		r >= 0xf900 && r <= 0xfaff,
+		r >= 0xfe30 && r <= 0xfe4f,
+		r >= 0xff00 && r <= 0xff60,
+		r >= 0xffe0 && r <= 0xffe6,
+		r >= 0x20000 && r <= 0x3fffd:
+		return 2
+	}
+	return 1
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,46 @@
+package tui
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"downloader/engine"
+)
+
+func TestFit(t *testing.T) {
+	if got := fit("下载abc", 5); got != "下载a" {
+		t.Errorf("got %q", got)
+	}
+	if got := fit("下载abc", 3); got != "下" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestRender(t *testing.T) {
+	u := New(nil)
+	s := engine.NewClient(engine.Options{OnEvent: u.Handle}).NewSession()
+	a := s.Add("https://rosefile.net/abc/a.part1.rar.html")
+	b := s.Add("https://rosefile.net/abc/a.part2.rar.html")
+	u.Handle(engine.Event{Kind: engine.EventCompleted, Task: a})
+	u.Handle(engine.Event{Kind: engine.EventRangeAssigned, Task: b, Proxy: "1.2.3.4", Start: 0, End: 100})
+	u.Handle(engine.Event{Kind: engine.EventProxyError, Task: b, Proxy: "1.2.3.4", Start: 40, End: 100, Err: errors.New("响应无效 502")})
+	u.Write([]byte("first\nsecond"))
+
+	now := time.Now()
+	u.render(100, 24, now.Add(-time.Second))
+	lines := u.render(100, 24, now)
+	out := strings.Join(lines, "\n")
+	for _, want := range []string{"任务 1/2", "✓", "a.part2.rar", "1.2.3.4", "错误 1", "响应无效 502", "first"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "second") {
+		t.Error("partial log line shown")
+	}
+	if len(lines) > 24 {
+		t.Errorf("%d lines", len(lines))
+	}
+}
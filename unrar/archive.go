@@ -22,17 +22,16 @@ const (
 )
 
 var (
-	errNoSig              = errors.New("rardecode: RAR signature not found")
-	errVerMismatch        = errors.New("rardecode: volume version mistmatch")
-	errCorruptHeader      = errors.New("rardecode: corrupt block header")
-	errCorruptFileHeader  = errors.New("rardecode: corrupt file header")
-	errBadHeaderCrc       = errors.New("rardecode: bad header crc")
-	errUnknownArc         = errors.New("rardecode: unknown archive version")
-	errUnknownDecoder     = errors.New("rardecode: unknown decoder version")
-	errUnsupportedDecoder = errors.New("rardecode: unsupported decoder version")
-	errArchiveContinues   = errors.New("rardecode: archive continues in next volume")
-	errArchiveEnd         = errors.New("rardecode: archive end reached")
-	errDecoderOutOfData   = errors.New("rardecode: decoder expected more data than is in packed file")
+	errNoSig             = errors.New("rardecode: RAR signature not found")
+	errVerMismatch       = errors.New("rardecode: volume version mistmatch")
+	errCorruptHeader     = errors.New("rardecode: corrupt block header")
+	errCorruptFileHeader = errors.New("rardecode: corrupt file header")
+	errBadHeaderCrc      = errors.New("rardecode: bad header crc")
+	errUnknownArc        = errors.New("rardecode: unknown archive version")
+	errUnknownDecoder    = errors.New("rardecode: unknown decoder version")
+	errArchiveContinues  = errors.New("rardecode: archive continues in next volume")
+	errArchiveEnd        = errors.New("rardecode: archive end reached")
+	ErrDecoderOutOfData  = errors.New("rardecode: decoder expected more data than is in packed file")
 
 	reDigits = regexp.MustCompile(`\d+`)
 )
@@ -57,6 +56,11 @@ func (b *readBuf) uint32() uint32 {
 	return v
 }
 
+func (b *readBuf) uint64() uint64 {
+	v := uint64(b.uint32())
+	return v | uint64(b.uint32())<<32
+}
+
 func (b *readBuf) bytes(n int) []byte {
 	v := (*b)[:n]
 	*b = (*b)[n:]
@@ -91,9 +95,10 @@ func readFull(r io.Reader, buf []byte) error {
 }
 
 // findSig searches for the RAR signature and version at the beginning of a file.
-// It searches no more than maxSfxSize bytes.
-func findSig(br *bufio.Reader) (int, error) {
-	for n := 0; n <= maxSfxSize; {
+// It searches no more than maxSfxSize bytes. n is the number of bytes read
+// from br, up to the end of the signature.
+func findSig(br *bufio.Reader) (ver, n int, err error) {
+	for n <= maxSfxSize {
 		b, err := br.ReadSlice(sigPrefix[0])
 		n += len(b)
 		if err == bufio.ErrBufferFull {
@@ -102,7 +107,7 @@ func findSig(br *bufio.Reader) (int, error) {
 			if err == io.EOF {
 				err = errNoSig
 			}
-			return 0, err
+			return 0, n, err
 		}
 
 		b, err = br.Peek(len(sigPrefix[1:]) + 2)
@@ -110,14 +115,13 @@ func findSig(br *bufio.Reader) (int, error) {
 			if err == io.EOF {
 				err = errNoSig
 			}
-			return 0, err
+			return 0, n, err
 		}
 		if !bytes.HasPrefix(b, []byte(sigPrefix[1:])) {
 			continue
 		}
 		b = b[len(sigPrefix)-1:]
 
-		var ver int
 		switch {
 		case b[0] == 0:
 			ver = fileFmt15
@@ -126,28 +130,57 @@ func findSig(br *bufio.Reader) (int, error) {
 		default:
 			continue
 		}
-		_, _ = br.ReadSlice('\x00')
+		b, _ = br.ReadSlice('\x00')
+		n += len(b)
 
-		return ver, nil
+		return ver, n, nil
 	}
-	return 0, errNoSig
+	return 0, n, errNoSig
+}
+
+// VolumeFile is a volume file opened by an OpenFunc.
+type VolumeFile interface {
+	io.Reader
+	io.Seeker
+	io.Closer
 }
 
+// OpenFunc opens the volume file name. Reads from the returned file may block
+// until the data is available, e.g. while the volume is still downloading.
+// Errors for missing volumes must satisfy os.IsNotExist for the end of a
+// multi-volume archive to be detected.
+type OpenFunc func(name string) (VolumeFile, error)
+
 // volume extends a fileBlockReader to be used across multiple
 // files in a multi-volume archive
 type volume struct {
 	fileBlockReader
-	f     *os.File      // current file handle
+	f     VolumeFile    // current file handle
+	open  OpenFunc      // opens volume files, nil for os.Open
 	br    *bufio.Reader // buffered reader for current volume file
 	dir   string        // volume directory
 	file  string        // current volume file (not including directory)
 	files []string      // full path names for current volume files processed
 	num   int           // volume number
 	old   bool          // uses old naming scheme
+	pass  string        // password, keys for it are derived ahead of reading
+	stop  chan struct{} // closed to stop deriving keys ahead
+}
+
+// openVolumeFile opens a volume file with open, or os.Open if open is nil.
+func openVolumeFile(name string, open OpenFunc) (VolumeFile, error) {
+	if open != nil {
+		return open(name)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
 }
 
 func (v *volume) openFile(file string) error {
-	f, err := os.Open(v.dir + file)
+	f, err := openVolumeFile(v.dir+file, v.open)
 	if err != nil {
 		return err
 	}
@@ -156,7 +189,8 @@ func (v *volume) openFile(file string) error {
 	return nil
 }
 
-func nextNewVolName(file string) string {
+// newVolNumIndex returns the position of the volume number in a new style volume name.
+func newVolNumIndex(file string) (lo, hi int) {
 	// find all numbers in volume name
 	m := reDigits.FindAllStringIndex(file, -1)
 	if l := len(m); l > 1 {
@@ -169,8 +203,12 @@ func nextNewVolName(file string) string {
 			m = m[1:]
 		}
 	}
+	return m[0][0], m[0][1]
+}
+
+func nextNewVolName(file string) string {
 	// extract and increment volume number
-	lo, hi := m[0][0], m[0][1]
+	lo, hi := newVolNumIndex(file)
 	n, err := strconv.Atoi(file[lo:hi])
 	if err != nil {
 		n = 0
@@ -213,6 +251,66 @@ func nextOldVolName(file string) string {
 	return file
 }
 
+// VolumeInfo splits a volume file name into the name shared by every volume of
+// the set and the zero based volume number, following the same naming rules used
+// to open the next volume. New style names look like name.part01.rar, old style
+// names are name.rar, name.r00, name.r01 ... ok is false if file doesn't look like
+// a RAR volume. A plain name.rar is reported as volume 0 of its own set.
+//
+// The set name doesn't include the naming scheme, name.rar and name.part1.rar
+// have the same set name. Use FirstVolumeName to tell sets apart.
+func VolumeInfo(file string) (set string, num int, ok bool) {
+	set, num, _, ok = volumeInfo(file)
+	return
+}
+
+// FirstVolumeName returns the name of the first volume of the set file belongs
+// to, with a .rar extension. Unlike the set name from VolumeInfo it depends on
+// the naming scheme, so it identifies the set among other archives in the same
+// directory.
+func FirstVolumeName(file string) (string, bool) {
+	_, _, first, ok := volumeInfo(file)
+	return first, ok
+}
+
+func volumeInfo(file string) (set string, num int, first string, ok bool) {
+	i := strings.LastIndex(file, ".")
+	if i < 0 {
+		return "", 0, "", false
+	}
+	ext := strings.ToLower(file[i+1:])
+	if ext == "rar" || ext == "exe" || ext == "sfx" {
+		base := file[:i]
+		if reDigits.FindStringIndex(base) != nil {
+			lo, hi := newVolNumIndex(file)
+			if strings.HasSuffix(strings.ToLower(file[:lo]), "part") {
+				n, err := strconv.Atoi(file[lo:hi])
+				if err == nil && n > 0 {
+					first = file[:lo] + strings.Repeat("0", hi-lo-1) + "1" + file[hi:i] + ".rar"
+					return strings.TrimRight(file[:lo-4], "."), n - 1, first, true
+				}
+			}
+		}
+		return base, 0, base + ".rar", true
+	}
+	// old style: .r00 => 1 ... .r99 => 100, .s00 => 101 ...
+	if len(ext) != 3 || ext[0] < 'r' || ext[0] > 'z' ||
+		ext[1] < '0' || ext[1] > '9' || ext[2] < '0' || ext[2] > '9' {
+		return "", 0, "", false
+	}
+	n := int(ext[0]-'r')*100 + int(ext[1]-'0')*10 + int(ext[2]-'0')
+	return file[:i], n + 1, file[:i] + ".rar", true
+}
+
+// NextVolumeName returns the name of the volume following file. old selects the
+// old style naming scheme (name.rar, name.r00, ...).
+func NextVolumeName(file string, old bool) string {
+	if old {
+		return nextOldVolName(file)
+	}
+	return nextNewVolName(file)
+}
+
 // openNextFile opens the next volume file in the archive.
 func (v *volume) openNextFile() error {
 	file := v.file
@@ -271,6 +369,10 @@ func (v *volume) next() (*fileBlockHeader, error) {
 			// to tell if the archive continues is to try to open the next volume.
 			atEOF = true
 		default:
+			if h != nil {
+				h.VolName = v.dir + v.file
+				h.Offset = v.pos()
+			}
 			return h, err
 		}
 
@@ -285,7 +387,7 @@ func (v *volume) next() (*fileBlockHeader, error) {
 		}
 		v.num++
 		v.br.Reset(v.f)
-		ver, err := findSig(v.br)
+		ver, _, err := findSig(v.br)
 		if err != nil {
 			return nil, err
 		}
@@ -294,10 +396,38 @@ func (v *volume) next() (*fileBlockHeader, error) {
 		}
 		v.files = append(v.files, v.dir+v.file)
 		v.reset() // reset encryption
+		v.prefetch()
+	}
+}
+
+// prefetch starts deriving the keys needed by the current volume file in the
+// background if the archive has a password. Volumes opened with an OpenFunc
+// are skipped as their data may not be available yet.
+func (v *volume) prefetch() {
+	if v.pass == "" || v.open != nil {
+		return
+	}
+	if v.stop == nil {
+		v.stop = make(chan struct{})
 	}
+	go prefetchKeys(v.dir+v.file, v.pass, v.stop)
+}
+
+// pos returns the position in the current volume file of the next byte
+// that will be read from the buffered reader.
+func (v *volume) pos() int64 {
+	off, err := v.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return -1
+	}
+	return off - int64(v.br.Buffered())
 }
 
 func (v *volume) Close() error {
+	if v.stop != nil {
+		close(v.stop)
+		v.stop = nil
+	}
 	// may be nil if os.Open fails in next()
 	if v.f == nil {
 		return nil
@@ -306,10 +436,15 @@ func (v *volume) Close() error {
 }
 
 func openVolume(name, password string) (*volume, error) {
+	return openVolumeFunc(name, password, nil)
+}
+
+// openVolumeFunc opens the volume name, opening volume files with open.
+func openVolumeFunc(name, password string, open OpenFunc) (*volume, error) {
 	var err error
-	v := new(volume)
+	v := &volume{open: open}
 	v.dir, v.file = filepath.Split(name)
-	v.f, err = os.Open(name)
+	v.f, err = openVolumeFile(name, open)
 	if err != nil {
 		return nil, err
 	}
@@ -320,23 +455,76 @@ func openVolume(name, password string) (*volume, error) {
 		return nil, err
 	}
 	v.files = append(v.files, name)
+	v.pass = password
+	v.prefetch()
 	return v, nil
 }
 
+// blockScanner reads the block headers of a single volume file, seeking past
+// the block data instead of reading it.
+type blockScanner struct {
+	f   *os.File
+	br  *bufio.Reader
+	fbr fileBlockReader
+}
+
+func openBlockScanner(name, password string) (*blockScanner, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	s := &blockScanner{f: f, br: bufio.NewReader(f)}
+	s.fbr, err = newFileBlockReader(s.br, password)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// pos returns the position in the file of the next byte read from br.
+func (s *blockScanner) pos() (int64, error) {
+	off, err := s.f.Seek(0, io.SeekCurrent)
+	return off - int64(s.br.Buffered()), err
+}
+
+// skip moves past n bytes of block data.
+func (s *blockScanner) skip(n int64) error {
+	off, err := s.pos()
+	if err != nil {
+		return err
+	}
+	if _, err = s.f.Seek(off+n, io.SeekStart); err != nil {
+		return err
+	}
+	s.br.Reset(s.f)
+	return nil
+}
+
+func (s *blockScanner) Close() error {
+	return s.f.Close()
+}
+
 func newFileBlockReader(br *bufio.Reader, pass string) (fileBlockReader, error) {
+	ver, _, err := findSig(br)
+	if err != nil {
+		return nil, err
+	}
+	return newArchive(br, ver, pass)
+}
+
+// newArchive creates a fileBlockReader for version ver reading from r, which
+// is positioned after the signature.
+func newArchive(r byteReader, ver int, pass string) (fileBlockReader, error) {
 	runes := []rune(pass)
 	if len(runes) > maxPassword {
 		pass = string(runes[:maxPassword])
 	}
-	ver, err := findSig(br)
-	if err != nil {
-		return nil, err
-	}
 	switch ver {
 	case fileFmt15:
-		return newArchive15(br, pass), nil
+		return newArchive15(r, pass), nil
 	case fileFmt50:
-		return newArchive50(br, pass), nil
+		return newArchive50(r, pass), nil
 	}
 	return nil, errUnknownArc
 }
@@ -1,10 +1,8 @@
 package rardecode
 
 import (
-	"bufio"
 	"bytes"
 	"crypto/sha1"
-	"errors"
 	"hash"
 	"hash/crc32"
 	"io"
@@ -46,13 +44,8 @@ const (
 	// end block flags
 	endArcNotLast = 0x0001
 
-	saltSize    = 8 // size of salt for calculating AES keys
-	cacheSize30 = 4 // number of AES keys to cache
-	hashRounds  = 0x40000
-)
-
-var (
-	errMultipleDecoders = errors.New("rardecode: multiple decoders in a single archive not supported")
+	saltSize   = 8 // size of salt for calculating AES keys
+	hashRounds = 0x40000
 )
 
 type blockHeader15 struct {
@@ -74,22 +67,16 @@ func (h *fileHash32) valid() bool {
 
 // archive15 implements fileBlockReader for RAR 1.5 file format archives
 type archive15 struct {
-	byteReader               // reader for current block data
-	v          *bufio.Reader // reader for current archive volume
-	dec        decoder       // current decoder
-	decVer     byte          // current decoder version
-	multi      bool          // archive is multi-volume
-	old        bool          // archive uses old naming scheme
-	solid      bool          // archive is a solid archive
+	byteReader                  // reader for current block data
+	v          byteReader       // reader for current archive volume
+	decs       map[byte]decoder // decoders by unpack version
+	multi      bool             // archive is multi-volume
+	old        bool             // archive uses old naming scheme
+	solid      bool             // archive is a solid archive
 	encrypted  bool
-	pass       []uint16              // password in UTF-16
-	checksum   fileHash32            // file checksum
-	buf        readBuf               // temporary buffer
-	keyCache   [cacheSize30]struct { // cache of previously calculated decryption keys
-		salt []byte
-		key  []byte
-		iv   []byte
-	}
+	pass       []uint16   // password in UTF-16
+	checksum   fileHash32 // file checksum
+	buf        readBuf    // temporary buffer
 }
 
 // Calculates the key and iv for AES decryption given a password and salt.
@@ -233,21 +220,11 @@ func readExtTimes(f *fileBlockHeader, b *readBuf) {
 }
 
 func (a *archive15) getKeys(salt []byte) (key, iv []byte) {
-	// check cache of keys
-	for _, v := range a.keyCache {
-		if bytes.Equal(v.salt[:], salt) {
-			return v.key, v.iv
-		}
-	}
-	key, iv = calcAes30Params(a.pass, salt)
-
-	// save a copy in the cache
-	copy(a.keyCache[1:], a.keyCache[:])
-	a.keyCache[0].salt = append([]byte(nil), salt...) // copy so byte slice can be reused
-	a.keyCache[0].key = key
-	a.keyCache[0].iv = iv
-
-	return key, iv
+	keys := sharedKeys.get(keyID15(a.pass, salt), func() [][]byte {
+		key, iv := calcAes30Params(a.pass, salt)
+		return [][]byte{key, iv}
+	})
+	return keys[0], keys[1]
 }
 
 func (a *archive15) parseFileHeader(h *blockHeader15) (*fileBlockHeader, error) {
@@ -338,23 +315,63 @@ func (a *archive15) parseFileHeader(h *blockHeader15) (*fileBlockHeader, error)
 	if method == 0 {
 		return f, nil
 	}
-	if a.dec == nil {
+	if unpackver == 26 {
+		unpackver = 20 // 2.6 only added larger files to the 2.0 format
+	}
+	// files added by different RAR versions each use the decoder of their version
+	dec := a.decs[unpackver]
+	if dec == nil {
 		switch unpackver {
-		case 15, 20, 26:
-			return nil, errUnsupportedDecoder
+		case 15:
+			dec = new(decoder15)
+		case 20:
+			dec = new(decoder20)
 		case 29:
-			a.dec = new(decoder29)
+			dec = new(decoder29)
 		default:
 			return nil, errUnknownDecoder
 		}
-		a.decVer = unpackver
-	} else if a.decVer != unpackver {
-		return nil, errMultipleDecoders
+		if a.decs == nil {
+			a.decs = make(map[byte]decoder)
+		}
+		a.decs[unpackver] = dec
 	}
-	f.decoder = a.dec
+	f.decoder = dec
 	return f, nil
 }
 
+// fileNameSalt15 returns the raw name and salt of a file or service block,
+// and the header data that follows them.
+func fileNameSalt15(h *blockHeader15) (name string, salt []byte, rest readBuf, err error) {
+	b := h.data
+	if len(b) < 21 {
+		return "", nil, nil, errCorruptFileHeader
+	}
+	b = b[15:] // unpacked size, host OS, CRC, time, version and method
+	n := int(b.uint16())
+	_ = b.uint32() // attributes
+	if h.flags&fileLargeData > 0 {
+		if len(b) < 8 {
+			return "", nil, nil, errCorruptFileHeader
+		}
+		b = b[8:]
+	}
+	if len(b) < n {
+		return "", nil, nil, errCorruptFileHeader
+	}
+	name = string(b.bytes(n))
+	if h.flags&fileSalt > 0 {
+		if len(b) < saltSize {
+			return "", nil, nil, errCorruptFileHeader
+		}
+		salt = b.bytes(saltSize)
+	}
+	if h.flags&fileExtTime > 0 {
+		readExtTimes(new(fileBlockHeader), &b)
+	}
+	return name, salt, b, nil
+}
+
 // readBlockHeader returns the next block header in the archive.
 // It will return io.EOF if there were no bytes read.
 func (a *archive15) readBlockHeader() (*blockHeader15, error) {
@@ -384,6 +401,10 @@ func (a *archive15) readBlockHeader() (*blockHeader15, error) {
 	h.htype = b.byte()
 	h.flags = b.uint16()
 	size := b.uint16()
+	if a.encrypted && (h.htype < blockArc || h.htype > blockEnd || size < 7) {
+		// a wrong key decrypts to a random header
+		return nil, errBadPassword
+	}
 	if size < 7 {
 		return nil, errCorruptHeader
 	}
@@ -397,6 +418,9 @@ func (a *archive15) readBlockHeader() (*blockHeader15, error) {
 	}
 	hash.Write(h.data)
 	if crc != uint16(hash.Sum32()) {
+		if a.encrypted {
+			return nil, errBadPassword
+		}
 		return nil, errBadHeaderCrc
 	}
 	if h.flags&blockHasData > 0 {
@@ -458,7 +482,7 @@ func (a *archive15) isSolid() bool {
 }
 
 // newArchive15 creates a new fileBlockReader for a Version 1.5 archive
-func newArchive15(r *bufio.Reader, password string) fileBlockReader {
+func newArchive15(r byteReader, password string) fileBlockReader {
 	a := new(archive15)
 	a.v = r
 	a.pass = utf16.Encode([]rune(password)) // convert to UTF-16
@@ -1,7 +1,6 @@
 package rardecode
 
 import (
-	"bufio"
 	"bytes"
 	"crypto/hmac"
 	"crypto/sha256"
@@ -43,11 +42,21 @@ const (
 	file5HasCRC32       = 0x0004
 	file5UnpSizeUnknown = 0x0008
 
+	// file time record flags
+	time5Unix    = 0x0001 // unix time_t instead of windows FILETIME
+	time5Mtime   = 0x0002
+	time5Ctime   = 0x0004
+	time5Atime   = 0x0008
+	time5UnixNan = 0x0010 // nanoseconds follow unix times
+
+	// file owner record flags
+	owner5User  = 0x0001
+	owner5Group = 0x0002
+
 	// file encryption record flags
 	file5EncCheckPresent = 0x0001 // password check data is present
 	file5EncUseMac       = 0x0002 // use MAC instead of plain checksum
 
-	cacheSize50   = 4
 	maxPbkdf2Salt = 64
 	pwCheckSize   = 8
 	maxKdfCount   = 24
@@ -115,20 +124,16 @@ func (h *hash50) valid() bool {
 
 // archive50 implements fileBlockReader for RAR 5 file format archives
 type archive50 struct {
-	byteReader               // reader for current block data
-	v          *bufio.Reader // reader for current archive volume
+	byteReader            // reader for current block data
+	v          byteReader // reader for current archive volume
 	pass       []byte
-	blockKey   []byte                // key used to encrypt blocks
-	multi      bool                  // archive is multi-volume
-	solid      bool                  // is a solid archive
-	checksum   hash50                // file checksum
-	dec        decoder               // optional decoder used to unpack file
-	buf        readBuf               // temporary buffer
-	keyCache   [cacheSize50]struct { // encryption key cache
-		kdfCount int
-		salt     []byte
-		keys     [][]byte
-	}
+	blockKey   []byte  // key used to encrypt blocks
+	encrypted  bool    // volume has an encryption block
+	multi      bool    // archive is multi-volume
+	solid      bool    // is a solid archive
+	checksum   hash50  // file checksum
+	dec        decoder // optional decoder used to unpack file
+	buf        readBuf // temporary buffer
 }
 
 // calcKeys50 calculates the keys used in RAR 5 archive processing.
@@ -189,22 +194,9 @@ func (a *archive50) getKeys(b *readBuf) (keys [][]byte, err error) {
 	kdfCount = 1 << uint(kdfCount)
 	salt := b.bytes(16)
 
-	// check cache of keys for match
-	for _, v := range a.keyCache {
-		if kdfCount == v.kdfCount && bytes.Equal(salt, v.salt) {
-			return v.keys, nil
-		}
-	}
-	// not found, calculate keys
-	keys = calcKeys50(a.pass, salt, kdfCount)
-
-	// store in cache
-	copy(a.keyCache[1:], a.keyCache[:])
-	a.keyCache[0].kdfCount = kdfCount
-	a.keyCache[0].salt = append([]byte(nil), salt...)
-	a.keyCache[0].keys = keys
-
-	return keys, nil
+	return sharedKeys.get(keyID50(a.pass, salt, kdfCount), func() [][]byte {
+		return calcKeys50(a.pass, salt, kdfCount)
+	}), nil
 }
 
 // checkPassword calculates if a password is correct given password check data and keys.
@@ -240,6 +232,7 @@ func (a *archive50) parseFileEncryptionRecord(b readBuf, f *fileBlockHeader) err
 	f.iv = b.bytes(16)
 
 	if flags&file5EncCheckPresent > 0 {
+		f.checked = len(b) >= 12
 		if err := checkPassword(&b, keys); err != nil {
 			return err
 		}
@@ -250,6 +243,53 @@ func (a *archive50) parseFileEncryptionRecord(b readBuf, f *fileBlockHeader) err
 	return nil
 }
 
+// readString50 reads a string preceded by its length.
+func readString50(b *readBuf) (string, error) {
+	n := int(b.uvarint())
+	if len(*b) < n {
+		return "", errCorruptFileHeader
+	}
+	return string(b.bytes(n)), nil
+}
+
+// fileTime converts a windows FILETIME to a time.Time.
+func fileTime(t uint64) time.Time {
+	const epoch = 11644473600 // seconds from 1601 to 1970
+	return time.Unix(int64(t/1e7)-epoch, int64(t%1e7)*100)
+}
+
+func parseFileTimeRecord(b readBuf, f *fileBlockHeader) error {
+	flags := b.uvarint()
+	unix := flags&time5Unix > 0
+	var set []*time.Time
+	for i, t := range []*time.Time{&f.ModificationTime, &f.CreationTime, &f.AccessTime} {
+		if flags&(time5Mtime<<uint(i)) == 0 {
+			continue
+		}
+		if unix {
+			if len(b) < 4 {
+				return errCorruptFileHeader
+			}
+			*t = time.Unix(int64(b.uint32()), 0)
+		} else {
+			if len(b) < 8 {
+				return errCorruptFileHeader
+			}
+			*t = fileTime(b.uint64())
+		}
+		set = append(set, t)
+	}
+	if unix && flags&time5UnixNan > 0 {
+		for _, t := range set {
+			if len(b) < 4 {
+				return errCorruptFileHeader
+			}
+			*t = time.Unix(t.Unix(), int64(b.uint32()))
+		}
+	}
+	return nil
+}
+
 func (a *archive50) parseFileHeader(h *blockHeader50) (*fileBlockHeader, error) {
 	a.checksum.sum = nil
 	a.checksum.key = nil
@@ -316,17 +356,34 @@ func (a *archive50) parseFileHeader(h *blockHeader50) (*fileBlockHeader, error)
 		switch e.ftype {
 		case 1: // encryption
 			err = a.parseFileEncryptionRecord(e.data, f)
-		case 2:
-			// TODO: hash
-		case 3:
-			// TODO: time
+		case 2: // hash
+			if e.data.uvarint() == 0 { // BLAKE2sp
+				if len(e.data) < blake2sSize {
+					return nil, errCorruptFileHeader
+				}
+				a.checksum.sum = append([]byte(nil), e.data.bytes(blake2sSize)...)
+				if f.first {
+					a.checksum.Hash = newBlake2sp()
+					f.cksum = &a.checksum
+				}
+			}
+		case 3: // time
+			err = parseFileTimeRecord(e.data, f)
 		case 4: // version
 			_ = e.data.uvarint() // ignore flags field
 			f.Version = int(e.data.uvarint())
-		case 5:
-			// TODO: redirection
-		case 6:
-			// TODO: owner
+		case 5: // redirection
+			f.Redir = int(e.data.uvarint())
+			f.RedirDir = e.data.uvarint()&1 > 0
+			f.RedirTarget, err = readString50(&e.data)
+		case 6: // owner
+			flags := e.data.uvarint()
+			if flags&owner5User > 0 {
+				f.User, err = readString50(&e.data)
+			}
+			if err == nil && flags&owner5Group > 0 {
+				f.Group, err = readString50(&e.data)
+			}
 		}
 		if err != nil {
 			return nil, err
@@ -337,6 +394,7 @@ func (a *archive50) parseFileHeader(h *blockHeader50) (*fileBlockHeader, error)
 
 // parseEncryptionBlock calculates the key for block encryption.
 func (a *archive50) parseEncryptionBlock(b readBuf) error {
+	a.encrypted = true
 	if ver := b.uvarint(); ver != 0 {
 		return errUnknownEncMethod
 	}
@@ -459,6 +517,7 @@ func (a *archive50) version() int { return fileFmt50 }
 
 func (a *archive50) reset() {
 	a.blockKey = nil // reset encryption when opening new volume file
+	a.encrypted = false
 }
 
 func (a *archive50) isSolid() bool {
@@ -466,7 +525,7 @@ func (a *archive50) isSolid() bool {
 }
 
 // newArchive50 creates a new fileBlockReader for a Version 5 archive.
-func newArchive50(r *bufio.Reader, password string) fileBlockReader {
+func newArchive50(r byteReader, password string) fileBlockReader {
 	a := new(archive50)
 	a.v = r
 	a.pass = []byte(password)
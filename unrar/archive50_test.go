@@ -0,0 +1,161 @@
+package rardecode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func appendVint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+// block50 returns a RAR 5 block header with its CRC and size fields.
+func block50(htype, flags uint64, fields, extra []byte, dataSize int) []byte {
+	var b []byte
+	b = appendVint(b, htype)
+	if len(extra) > 0 {
+		flags |= block5HasExtra
+	}
+	if dataSize > 0 {
+		flags |= block5HasData
+	}
+	b = appendVint(b, flags)
+	if len(extra) > 0 {
+		b = appendVint(b, uint64(len(extra)))
+	}
+	if dataSize > 0 {
+		b = appendVint(b, uint64(dataSize))
+	}
+	b = append(append(b, fields...), extra...)
+	h := appendVint(nil, uint64(len(b)))
+	h = append(h, b...)
+	crc := make([]byte, 4, 4+len(h))
+	binary.LittleEndian.PutUint32(crc, crc32.ChecksumIEEE(h))
+	return append(crc, h...)
+}
+
+// record50 returns an extra record of type ftype.
+func record50(ftype uint64, data []byte) []byte {
+	b := appendVint(nil, ftype)
+	b = append(b, data...)
+	return append(appendVint(nil, uint64(len(b))), b...)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func vints(vs ...uint64) []byte {
+	var b []byte
+	for _, v := range vs {
+		b = appendVint(b, v)
+	}
+	return b
+}
+
+func str50(s string) []byte {
+	return append(appendVint(nil, uint64(len(s))), s...)
+}
+
+// stored50 returns a RAR 5 archive with a single stored file.
+func stored50(name string, data []byte, host uint64, extra []byte) []byte {
+	b := []byte(sigPrefix + "\x01\x00")
+	b = append(b, block50(block5Arc, 0, vints(0), nil, 0)...)
+	fields := vints(0, uint64(len(data)), 0o644, 0, host) // flags, size, attributes, method, host OS
+	fields = append(fields, str50(name)...)
+	b = append(b, block50(block5File, 0, fields, extra, len(data))...)
+	b = append(b, data...)
+	return append(b, block50(block5End, 0, vints(0), nil, 0)...)
+}
+
+func TestFileRecords50(t *testing.T) {
+	data := fill(1000, 0x5a)
+	h := newBlake2sp()
+	h.Write(data)
+	sum := h.Sum(nil)
+
+	mtime := time.Date(2024, 5, 6, 7, 8, 9, 123456789, time.UTC)
+	atime := time.Date(2023, 1, 2, 3, 4, 5, 987654321, time.UTC)
+	var times []byte
+	times = appendVint(times, time5Unix|time5Mtime|time5Atime|time5UnixNan)
+	for _, t := range []time.Time{mtime, atime} {
+		times = appendUint32(times, uint32(t.Unix()))
+	}
+	for _, t := range []time.Time{mtime, atime} {
+		times = appendUint32(times, uint32(t.Nanosecond()))
+	}
+	var extra []byte
+	extra = append(extra, record50(2, append(vints(0), sum...))...)
+	extra = append(extra, record50(3, times)...)
+	extra = append(extra, record50(5, append(vints(RedirUnixSymlink, 0), str50("../target")...))...)
+	extra = append(extra, record50(6, append(append(vints(owner5User|owner5Group), str50("alice")...), str50("staff")...))...)
+
+	arc := stored50("link", data, 1, extra)
+	r, err := NewReader(bytes.NewReader(arc), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fh.ModificationTime.Equal(mtime) || !fh.AccessTime.Equal(atime) || !fh.CreationTime.IsZero() {
+		t.Errorf("times %v %v %v", fh.ModificationTime, fh.AccessTime, fh.CreationTime)
+	}
+	if fh.Redir != RedirUnixSymlink || fh.RedirTarget != "../target" || fh.RedirDir {
+		t.Errorf("redirection %d %q %v", fh.Redir, fh.RedirTarget, fh.RedirDir)
+	}
+	if fh.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("mode %v", fh.Mode())
+	}
+	if fh.User != "alice" || fh.Group != "staff" {
+		t.Errorf("owner %q %q", fh.User, fh.Group)
+	}
+	if b, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(b, data) {
+		t.Fatalf("read %d bytes, %v", len(b), err)
+	}
+
+	// a changed byte has to fail the BLAKE2sp check
+	arc[bytes.Index(arc, data)+500] ^= 1
+	r, _ = NewReader(bytes.NewReader(arc), "")
+	if _, err = r.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.Copy(ioutil.Discard, r); !errors.Is(err, ErrBadFileChecksum) {
+		t.Errorf("damaged data: %v", err)
+	}
+}
+
+func TestFileTime50(t *testing.T) {
+	want := time.Date(2020, 2, 29, 12, 0, 0, 1234500, time.UTC)
+	ft := uint64(want.Unix()+11644473600)*1e7 + uint64(want.Nanosecond()/100)
+	var times []byte
+	times = appendVint(times, time5Ctime)
+	times = appendUint32(appendUint32(times, uint32(ft)), uint32(ft>>32))
+
+	r, err := NewReader(bytes.NewReader(stored50("a.txt", []byte("x"), 0, record50(3, times))), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fh.CreationTime.Equal(want) || !fh.ModificationTime.IsZero() {
+		t.Errorf("creation %v, modification %v", fh.CreationTime, fh.ModificationTime)
+	}
+	if fh.Redir != 0 || fh.Mode()&os.ModeSymlink != 0 {
+		t.Errorf("regular file reported as link: %d %v", fh.Redir, fh.Mode())
+	}
+}
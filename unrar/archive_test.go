@@ -0,0 +1,72 @@
+package rardecode
+
+import "testing"
+
+func TestVolumeInfo(t *testing.T) {
+	for _, c := range []struct {
+		file string
+		set  string
+		num  int
+		ok   bool
+	}{
+		{"2205092.part1.rar", "2205092", 0, true},
+		{"2205092.part12.rar", "2205092", 11, true},
+		{"a.b.part001.rar", "a.b", 0, true},
+		{"single.rar", "single", 0, true},
+		{"2205092.rar", "2205092", 0, true},
+		{"old.r00", "old", 1, true},
+		{"old.r99", "old", 100, true},
+		{"old.s00", "old", 101, true},
+		{"video.mp4", "", 0, false},
+	} {
+		set, num, ok := VolumeInfo(c.file)
+		if set != c.set || num != c.num || ok != c.ok {
+			t.Errorf("VolumeInfo(%q) = %q %d %v, want %q %d %v", c.file, set, num, ok, c.set, c.num, c.ok)
+		}
+	}
+}
+
+func TestFirstVolumeName(t *testing.T) {
+	for _, c := range []struct {
+		file  string
+		first string
+	}{
+		{"x.part1.rar", "x.part1.rar"},
+		{"x.part3.rar", "x.part1.rar"},
+		{"x.part007.rar", "x.part001.rar"},
+		{"x.part1.exe", "x.part1.rar"},
+		{"x.rar", "x.rar"},
+		{"x.r05", "x.rar"},
+		{"x.exe", "x.rar"},
+	} {
+		if first, ok := FirstVolumeName(c.file); !ok || first != c.first {
+			t.Errorf("FirstVolumeName(%q) = %q %v, want %q", c.file, first, ok, c.first)
+		}
+	}
+}
+
+func TestNextVolumeName(t *testing.T) {
+	for _, c := range []struct {
+		file string
+		old  bool
+		want string
+	}{
+		{"2205092.part1.rar", false, "2205092.part2.rar"},
+		{"x.part09.rar", false, "x.part10.rar"},
+		{"old.rar", true, "old.r00"},
+		{"old.r99", true, "old.s00"},
+	} {
+		if got := NextVolumeName(c.file, c.old); got != c.want {
+			t.Errorf("NextVolumeName(%q) = %q, want %q", c.file, got, c.want)
+		}
+	}
+	// every name produced by NextVolumeName belongs to the same set
+	file := "2205092.part1.rar"
+	for i := 0; i < 12; i++ {
+		set, num, _ := VolumeInfo(file)
+		if set != "2205092" || num != i {
+			t.Fatalf("VolumeInfo(%q) = %q %d", file, set, num)
+		}
+		file = NextVolumeName(file, false)
+	}
+}
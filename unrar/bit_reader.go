@@ -117,3 +117,81 @@ func (r *rarBitReader) readFull(p []byte) error {
 func newRarBitReader(r io.ByteReader) *rarBitReader {
 	return &rarBitReader{r: r}
 }
+
+// bitInput reads bits MSB first for the RAR 1.5 and 2.0 decoders, which peek
+// at up to 16 bits before deciding how many to use. As in unrar, bits past the
+// end of the input read as zero; over counts the ones that were consumed.
+type bitInput struct {
+	r    io.ByteReader
+	v    uint64 // buffered bits, left aligned
+	n    uint   // number of buffered bits
+	eof  bool   // r has no more data
+	err  error  // read error other than io.EOF
+	over uint   // bits consumed past the end of input
+}
+
+func (b *bitInput) reset(r io.ByteReader) {
+	*b = bitInput{r: r}
+}
+
+func (b *bitInput) fill() {
+	for b.n <= 56 && !b.eof {
+		c, err := b.r.ReadByte()
+		if err != nil {
+			b.eof = true
+			if err != io.EOF {
+				b.err = err
+			}
+			return
+		}
+		b.v |= uint64(c) << (56 - b.n)
+		b.n += 8
+	}
+}
+
+// peek returns the next n bits without consuming them, n must be <= 32.
+func (b *bitInput) peek(n uint) uint {
+	if b.n < n {
+		b.fill()
+	}
+	return uint(b.v >> (64 - n))
+}
+
+// skip consumes n bits.
+func (b *bitInput) skip(n uint) {
+	if b.n < n {
+		b.fill()
+		if b.n < n {
+			b.over += n - b.n
+			b.v, b.n = 0, 0
+			return
+		}
+	}
+	b.v <<= n
+	b.n -= n
+}
+
+// bits reads n bits.
+func (b *bitInput) bits(n uint) uint {
+	v := b.peek(n)
+	b.skip(n)
+	return v
+}
+
+// ahead reports whether at least n bytes of input remain, n must be <= 7.
+func (b *bitInput) ahead(n uint) bool {
+	b.fill()
+	return b.n >= n*8
+}
+
+// error returns the read error, or ErrDecoderOutOfData if bits past the end
+// of the input were used.
+func (b *bitInput) error() error {
+	if b.err != nil {
+		return b.err
+	}
+	if b.over > 0 {
+		return ErrDecoderOutOfData
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package rardecode
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+// BLAKE2sp is used by RAR 5 archives for file checksums. It hashes the input
+// with 8 BLAKE2s leaves, each taking every eighth 64 byte block, and a root
+// node that hashes the concatenated leaf digests.
+
+const (
+	blake2sBlockSize = 64
+	blake2sSize      = 32
+	blake2spLeaves   = 8
+)
+
+var blake2sIV = [8]uint32{
+	0x6A09E667, 0xBB67AE85, 0x3C6EF372, 0xA54FF53A,
+	0x510E527F, 0x9B05688C, 0x1F83D9AB, 0x5BE0CD19,
+}
+
+var blake2sSigma = [10][16]byte{
+	{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+	{14, 10, 4, 8, 9, 15, 13, 6, 1, 12, 0, 2, 11, 7, 5, 3},
+	{11, 8, 12, 0, 5, 2, 15, 13, 10, 14, 3, 6, 7, 1, 9, 4},
+	{7, 9, 3, 1, 13, 12, 11, 14, 2, 6, 5, 10, 4, 0, 15, 8},
+	{9, 0, 5, 7, 2, 4, 10, 15, 14, 1, 11, 12, 6, 8, 3, 13},
+	{2, 12, 6, 10, 0, 11, 8, 3, 4, 13, 7, 5, 15, 14, 1, 9},
+	{12, 5, 1, 15, 14, 13, 4, 10, 0, 7, 6, 3, 9, 2, 8, 11},
+	{13, 11, 7, 14, 12, 1, 3, 9, 5, 0, 15, 4, 8, 6, 2, 10},
+	{6, 15, 14, 9, 11, 3, 0, 8, 12, 2, 13, 7, 1, 4, 10, 5},
+	{10, 2, 8, 4, 7, 6, 1, 5, 15, 11, 9, 14, 3, 12, 13, 0},
+}
+
+// blake2s is a BLAKE2s node of a BLAKE2sp tree.
+type blake2s struct {
+	h    [8]uint32
+	t    uint64 // bytes compressed so far
+	buf  [blake2sBlockSize]byte
+	n    int  // bytes in buf
+	last bool // last node at its tree level
+}
+
+// init sets the parameter block for a BLAKE2sp node with the given offset and depth.
+func (s *blake2s) init(offset uint32, depth byte, last bool) {
+	s.h = blake2sIV
+	s.h[0] ^= blake2sSize | blake2spLeaves<<16 | 2<<24 // digest size, fanout, max depth
+	s.h[2] ^= offset
+	s.h[3] ^= uint32(depth)<<16 | blake2sSize<<24 // node depth, inner length
+	s.t = 0
+	s.n = 0
+	s.last = last
+}
+
+func blake2sG(v *[16]uint32, a, b, c, d int, x, y uint32) {
+	v[a] += v[b] + x
+	v[d] = bits.RotateLeft32(v[d]^v[a], -16)
+	v[c] += v[d]
+	v[b] = bits.RotateLeft32(v[b]^v[c], -12)
+	v[a] += v[b] + y
+	v[d] = bits.RotateLeft32(v[d]^v[a], -8)
+	v[c] += v[d]
+	v[b] = bits.RotateLeft32(v[b]^v[c], -7)
+}
+
+func (s *blake2s) compress(block []byte, final bool) {
+	var m [16]uint32
+	for i := range m {
+		m[i] = binary.LittleEndian.Uint32(block[i*4:])
+	}
+	var v [16]uint32
+	copy(v[:8], s.h[:])
+	copy(v[8:], blake2sIV[:])
+	v[12] ^= uint32(s.t)
+	v[13] ^= uint32(s.t >> 32)
+	if final {
+		v[14] = ^v[14]
+		if s.last {
+			v[15] = ^v[15]
+		}
+	}
+	for _, p := range blake2sSigma {
+		blake2sG(&v, 0, 4, 8, 12, m[p[0]], m[p[1]])
+		blake2sG(&v, 1, 5, 9, 13, m[p[2]], m[p[3]])
+		blake2sG(&v, 2, 6, 10, 14, m[p[4]], m[p[5]])
+		blake2sG(&v, 3, 7, 11, 15, m[p[6]], m[p[7]])
+		blake2sG(&v, 0, 5, 10, 15, m[p[8]], m[p[9]])
+		blake2sG(&v, 1, 6, 11, 12, m[p[10]], m[p[11]])
+		blake2sG(&v, 2, 7, 8, 13, m[p[12]], m[p[13]])
+		blake2sG(&v, 3, 4, 9, 14, m[p[14]], m[p[15]])
+	}
+	for i := range s.h {
+		s.h[i] ^= v[i] ^ v[i+8]
+	}
+}
+
+// write adds p to the node. The last block is kept in buf as it has to be
+// compressed with the final flag.
+func (s *blake2s) write(p []byte) {
+	for len(p) > 0 {
+		if s.n == blake2sBlockSize {
+			s.t += blake2sBlockSize
+			s.compress(s.buf[:], false)
+			s.n = 0
+		}
+		n := copy(s.buf[s.n:], p)
+		s.n += n
+		p = p[n:]
+	}
+}
+
+// sum appends the digest of s to b without changing s.
+func (s blake2s) sum(b []byte) []byte {
+	s.t += uint64(s.n)
+	for i := s.n; i < blake2sBlockSize; i++ {
+		s.buf[i] = 0
+	}
+	s.compress(s.buf[:], true)
+	var d [blake2sSize]byte
+	for i, h := range s.h {
+		binary.LittleEndian.PutUint32(d[i*4:], h)
+	}
+	return append(b, d[:]...)
+}
+
+// blake2sp implements hash.Hash for BLAKE2sp with a 32 byte digest.
+type blake2sp struct {
+	leaves [blake2spLeaves]blake2s
+	n      uint64 // bytes written
+}
+
+func newBlake2sp() hash.Hash {
+	h := new(blake2sp)
+	h.Reset()
+	return h
+}
+
+func (h *blake2sp) Reset() {
+	for i := range h.leaves {
+		h.leaves[i].init(uint32(i), 0, i == blake2spLeaves-1)
+	}
+	h.n = 0
+}
+
+func (h *blake2sp) Write(p []byte) (int, error) {
+	size := len(p)
+	for len(p) > 0 {
+		leaf := h.n / blake2sBlockSize % blake2spLeaves
+		n := blake2sBlockSize - int(h.n%blake2sBlockSize)
+		if n > len(p) {
+			n = len(p)
+		}
+		h.leaves[leaf].write(p[:n])
+		h.n += uint64(n)
+		p = p[n:]
+	}
+	return size, nil
+}
+
+func (h *blake2sp) Sum(b []byte) []byte {
+	var root blake2s
+	root.init(0, 1, true)
+	var d []byte
+	for _, l := range h.leaves {
+		d = l.sum(d[:0])
+		root.write(d)
+	}
+	return root.sum(b)
+}
+
+func (h *blake2sp) Size() int      { return blake2sSize }
+func (h *blake2sp) BlockSize() int { return blake2sBlockSize }
@@ -0,0 +1,44 @@
+package rardecode
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestBlake2sp(t *testing.T) {
+	for _, c := range []struct {
+		n   int
+		sum string
+	}{
+		{0, "dd0e891776933f43c7d032b08a917e25741f8aa9a12c12e1cac8801500f2ca4f"},
+		{1, "a6b9eecc25227ad788c99d3f236debc8da408849e9a5178978727a81457f7239"},
+		{64, "52603b6cbfad4966cb044cb267568385cf35f21e6c45cf30aed19832cb51e9f5"},
+		{511, "8e1e8ee1ffa0a01028fff3bff0ae9df2565a82e55a04e9541bb78b9c4778336f"},
+		{512, "8d9e357863298dd8364b7caf4234317f8a49f180d788b7abffb521925f1e1ff1"},
+		{513, "8a4bc3330497e681f15daf24fc496044a1c32bf0a837a210399e1ae4af7e92be"},
+		{1000, "611f1af6610cdaf674ec2c9178f6376ebe234ef50998a3be3f1fa698fb779274"},
+		{4113, "88825457c2ddc6fdda3de11d7ab27b68faf0b5f3224ba16e67c4952bf6b8e780"},
+	} {
+		data := make([]byte, c.n)
+		for i := range data {
+			data[i] = byte(i % 251)
+		}
+		h := newBlake2sp()
+		// write in uneven pieces to cross block and leaf boundaries
+		for p, step := data, 1; len(p) > 0; step = step*3 + 1 {
+			if step > len(p) {
+				step = len(p)
+			}
+			h.Write(p[:step])
+			p = p[step:]
+		}
+		if got := hex.EncodeToString(h.Sum(nil)); got != c.sum {
+			t.Errorf("BLAKE2sp of %d bytes = %s, want %s", c.n, got, c.sum)
+		}
+		h.Reset()
+		h.Write(data)
+		if got := hex.EncodeToString(h.Sum(nil)); got != c.sum {
+			t.Errorf("BLAKE2sp of %d bytes after Reset = %s", c.n, got)
+		}
+	}
+}
@@ -0,0 +1,484 @@
+package rardecode
+
+import "io"
+
+// Code tables for the RAR 1.5 decoder. A value is decoded from the next 16 bits
+// with decodeNum: dec holds the upper limits of each code length, starting
+// with start bits, and pos the first value of each length.
+var (
+	decL1 = []uint{0x8000, 0xa000, 0xc000, 0xd000, 0xe000, 0xea00, 0xee00, 0xf000, 0xf200, 0xf200, 0xffff}
+	posL1 = []uint{0, 0, 0, 2, 3, 5, 7, 11, 16, 20, 24, 32, 32}
+
+	decL2 = []uint{0xa000, 0xc000, 0xd000, 0xe000, 0xea00, 0xee00, 0xf000, 0xf200, 0xf240, 0xffff}
+	posL2 = []uint{0, 0, 0, 0, 5, 7, 9, 13, 18, 22, 26, 34, 36}
+
+	decHf0 = []uint{0x8000, 0xc000, 0xe000, 0xf200, 0xf200, 0xf200, 0xf200, 0xf200, 0xffff}
+	posHf0 = []uint{0, 0, 0, 0, 0, 8, 16, 24, 33, 33, 33, 33, 33}
+
+	decHf1 = []uint{0x2000, 0xc000, 0xe000, 0xf000, 0xf200, 0xf200, 0xf7e0, 0xffff}
+	posHf1 = []uint{0, 0, 0, 0, 0, 0, 4, 44, 60, 76, 80, 80, 127}
+
+	decHf2 = []uint{0x1000, 0x2400, 0x8000, 0xc000, 0xfa00, 0xffff, 0xffff, 0xffff}
+	posHf2 = []uint{0, 0, 0, 0, 0, 0, 2, 7, 53, 117, 233, 0, 0}
+
+	decHf3 = []uint{0x800, 0x2400, 0xee00, 0xfe80, 0xffff, 0xffff, 0xffff}
+	posHf3 = []uint{0, 0, 0, 0, 0, 0, 0, 2, 16, 218, 251, 0, 0}
+
+	decHf4 = []uint{0xff00, 0xffff, 0xffff, 0xffff, 0xffff, 0xffff}
+	posHf4 = []uint{0, 0, 0, 0, 0, 0, 0, 0, 0, 255, 0, 0, 0}
+
+	shortLen1 = [16]uint{1, 3, 4, 4, 5, 6, 7, 8, 8, 4, 4, 5, 6, 6, 4, 0}
+	shortXor1 = [16]uint{0, 0xa0, 0xd0, 0xe0, 0xf0, 0xf8, 0xfc, 0xfe, 0xff, 0xc0, 0x80, 0x90, 0x98, 0x9c, 0xb0}
+	shortLen2 = [16]uint{2, 3, 3, 3, 4, 4, 5, 6, 6, 4, 4, 5, 6, 6, 4, 0}
+	shortXor2 = [16]uint{0, 0x40, 0x60, 0xa0, 0xd0, 0xe0, 0xf0, 0xf8, 0xfc, 0xc0, 0x80, 0x90, 0x98, 0x9c, 0xb0}
+)
+
+const (
+	startL1  = 2
+	startL2  = 3
+	startHf0 = 4
+	startHf1 = 5
+	startHf2 = 5
+	startHf3 = 6
+	startHf4 = 8
+)
+
+// codeTable15 is one of the static code tables used by decodeNum.
+type codeTable15 struct {
+	start uint
+	dec   []uint
+	pos   []uint
+}
+
+var (
+	tabL1  = codeTable15{startL1, decL1, posL1}
+	tabL2  = codeTable15{startL2, decL2, posL2}
+	tabHf0 = codeTable15{startHf0, decHf0, posHf0}
+	tabHf1 = codeTable15{startHf1, decHf1, posHf1}
+	tabHf2 = codeTable15{startHf2, decHf2, posHf2}
+	tabHf3 = codeTable15{startHf3, decHf3, posHf3}
+	tabHf4 = codeTable15{startHf4, decHf4, posHf4}
+)
+
+// decode returns the value coded in the 16 bits of num and its length in bits.
+func (t *codeTable15) decode(num uint) (v, bits uint) {
+	num &= 0xfff0
+	bits = t.start
+	i := 0
+	for t.dec[i] <= num {
+		bits++
+		i++
+	}
+	var base uint
+	if i > 0 {
+		base = t.dec[i-1]
+	}
+	return (num-base)>>(16-bits) + t.pos[bits], bits
+}
+
+// decoder15 implements the decoder interface for RAR 1.5 compression
+// (unpack version 15). It has no code tables in the data, literals and
+// distances are coded by their rank in adaptively sorted lists.
+//
+// It has not been checked against archives made by RAR itself yet;
+// TestLegacyArchives fails until they are in testdata/legacy.
+type decoder15 struct {
+	br   bitInput
+	size int64 // bytes left to decode in the current file
+
+	chSet, chSetA, chSetB, chSetC [256]uint
+	nToPl, nToPlB, nToPlC         [256]byte
+
+	avrPlc, avrPlcB              uint
+	avrLn1, avrLn2, avrLn3       uint
+	numHuf, nhfb, nlzb, maxDist3 uint
+	buf60                        uint
+	flagBuf                      uint
+	flagsCnt                     int
+	stMode                       bool
+	lCount                       int
+	oldDist                      [4]uint
+	oldDistPtr                   int
+	lastDist, lastLength         uint
+}
+
+func (d *decoder15) setSize(n int64) { d.size = unpackSize(n) }
+
+func (d *decoder15) init(r io.ByteReader, reset bool) error {
+	d.br.reset(r)
+	if reset {
+		d.avrPlcB, d.avrLn1, d.avrLn2, d.avrLn3 = 0, 0, 0, 0
+		d.numHuf, d.buf60 = 0, 0
+		d.avrPlc = 0x3500
+		d.maxDist3 = 0x2001
+		d.nhfb, d.nlzb = 0x80, 0x80
+		d.oldDist = [4]uint{}
+		d.oldDistPtr = 0
+		d.lastDist, d.lastLength = 0, 0
+		d.initHuff()
+	}
+	d.flagsCnt = 0
+	d.flagBuf = 0
+	d.stMode = false
+	d.lCount = 0
+	if d.size > 0 {
+		d.getFlagsBuf()
+		d.flagsCnt = 8
+	}
+	return d.br.error()
+}
+
+func (d *decoder15) initHuff() {
+	for i := uint(0); i < 256; i++ {
+		d.chSet[i] = i << 8
+		d.chSetB[i] = i << 8
+		d.chSetA[i] = i
+		d.chSetC[i] = ((^i + 1) & 0xff) << 8
+	}
+	d.nToPl = [256]byte{}
+	d.nToPlB = [256]byte{}
+	d.nToPlC = [256]byte{}
+	corrHuff(&d.chSetB, &d.nToPlB)
+}
+
+// corrHuff resets the usage counts kept in the low byte of the chSet entries.
+func corrHuff(chSet *[256]uint, nToPl *[256]byte) {
+	k := 0
+	for i := 7; i >= 0; i-- {
+		for j := 0; j < 32; j++ {
+			chSet[k] = chSet[k]&^0xff | uint(i)
+			k++
+		}
+	}
+	*nToPl = [256]byte{}
+	for i := 6; i >= 0; i-- {
+		nToPl[i] = byte((7 - i) * 32)
+	}
+}
+
+// decodeNum reads a value coded with t.
+func (d *decoder15) decodeNum(t *codeTable15) uint {
+	v, bits := t.decode(d.br.peek(16))
+	d.br.skip(bits)
+	return v
+}
+
+func (d *decoder15) copyString(w *window, dist, length uint) {
+	d.size -= int64(length)
+	w.copyBytes(int(length), int(dist))
+}
+
+func (d *decoder15) getFlagsBuf() {
+	place := d.decodeNum(&tabHf2)
+	if place >= 256 {
+		return // corrupt data
+	}
+	for {
+		flags := d.chSetC[place]
+		d.flagBuf = flags >> 8
+		newPlace := d.nToPlC[flags&0xff]
+		d.nToPlC[flags&0xff]++
+		flags++
+		if flags&0xff != 0 {
+			d.chSetC[place] = d.chSetC[newPlace]
+			d.chSetC[newPlace] = flags
+			return
+		}
+		corrHuff(&d.chSetC, &d.nToPlC)
+	}
+}
+
+func (d *decoder15) shortLZ(w *window) error {
+	d.numHuf = 0
+	bitField := d.br.peek(16)
+	if d.lCount == 2 {
+		d.br.skip(1)
+		if bitField >= 0x8000 {
+			d.copyString(w, d.lastDist, d.lastLength)
+			return nil
+		}
+		bitField <<= 1
+		d.lCount = 0
+	}
+	bitField >>= 8
+
+	lens, xors := &shortLen1, &shortXor1
+	special := uint(1)
+	if d.avrLn1 >= 37 {
+		lens, xors = &shortLen2, &shortXor2
+		special = 3
+	}
+	var length, bits uint
+	for ; ; length++ {
+		if length == 15 {
+			return ErrCorruptDecodeHeader
+		}
+		bits = lens[length]
+		if length == special {
+			bits = d.buf60 + 3
+		}
+		if (bitField^xors[length])&^(0xff>>bits) == 0 {
+			break
+		}
+	}
+	d.br.skip(bits)
+
+	if length >= 9 {
+		if length == 9 {
+			d.lCount++
+			d.copyString(w, d.lastDist, d.lastLength)
+			return nil
+		}
+		if length == 14 {
+			d.lCount = 0
+			length = d.decodeNum(&tabL2) + 5
+			dist := d.br.peek(16)>>1 | 0x8000
+			d.br.skip(15)
+			d.lastLength = length
+			d.lastDist = dist
+			d.copyString(w, dist, length)
+			return nil
+		}
+		d.lCount = 0
+		save := length
+		dist := d.oldDist[(d.oldDistPtr-int(length-9))&3]
+		length = d.decodeNum(&tabL1) + 2
+		if length == 0x101 && save == 10 {
+			d.buf60 ^= 1
+			return nil
+		}
+		if dist > 256 {
+			length++
+		}
+		if dist >= d.maxDist3 {
+			length++
+		}
+		d.pushDist(dist, length)
+		d.copyString(w, dist, length)
+		return nil
+	}
+
+	d.lCount = 0
+	d.avrLn1 += length
+	d.avrLn1 -= d.avrLn1 >> 4
+
+	place := int(d.decodeNum(&tabHf2) & 0xff)
+	dist := d.chSetA[place]
+	if place--; place != -1 {
+		d.chSetA[place+1] = d.chSetA[place]
+		d.chSetA[place] = dist
+	}
+	length += 2
+	dist++
+	d.pushDist(dist, length)
+	d.copyString(w, dist, length)
+	return nil
+}
+
+// pushDist saves a new distance and length for later repeats.
+func (d *decoder15) pushDist(dist, length uint) {
+	d.oldDist[d.oldDistPtr] = dist
+	d.oldDistPtr = (d.oldDistPtr + 1) & 3
+	d.lastLength = length
+	d.lastDist = dist
+}
+
+func (d *decoder15) longLZ(w *window) {
+	d.numHuf = 0
+	d.nlzb += 16
+	if d.nlzb > 0xff {
+		d.nlzb = 0x90
+		d.nhfb >>= 1
+	}
+	oldAvr2 := d.avrLn2
+
+	var length uint
+	switch bitField := d.br.peek(16); {
+	case d.avrLn2 >= 122:
+		length = d.decodeNum(&tabL2)
+	case d.avrLn2 >= 64:
+		length = d.decodeNum(&tabL1)
+	case bitField < 0x100:
+		length = bitField
+		d.br.skip(16)
+	default:
+		for bitField<<length&0x8000 == 0 {
+			length++
+		}
+		d.br.skip(length + 1)
+	}
+	d.avrLn2 += length
+	d.avrLn2 -= d.avrLn2 >> 5
+
+	var place uint
+	switch {
+	case d.avrPlcB > 0x28ff:
+		place = d.decodeNum(&tabHf2)
+	case d.avrPlcB > 0x6ff:
+		place = d.decodeNum(&tabHf1)
+	default:
+		place = d.decodeNum(&tabHf0)
+	}
+	d.avrPlcB += place
+	d.avrPlcB -= d.avrPlcB >> 8
+
+	var dist, newPlace uint
+	for {
+		dist = d.chSetB[place&0xff]
+		newPlace = uint(d.nToPlB[dist&0xff])
+		d.nToPlB[dist&0xff]++
+		dist++
+		if dist&0xff != 0 {
+			break
+		}
+		corrHuff(&d.chSetB, &d.nToPlB)
+	}
+	d.chSetB[place&0xff] = d.chSetB[newPlace]
+	d.chSetB[newPlace] = dist & 0xffff
+
+	dist = (dist&0xff00 | d.br.peek(16)>>8) >> 1
+	d.br.skip(7)
+
+	oldAvr3 := d.avrLn3
+	if length != 1 && length != 4 {
+		if length == 0 && dist <= d.maxDist3 {
+			d.avrLn3++
+			d.avrLn3 -= d.avrLn3 >> 8
+		} else if d.avrLn3 > 0 {
+			d.avrLn3--
+		}
+	}
+	length += 3
+	if dist >= d.maxDist3 {
+		length++
+	}
+	if dist <= 256 {
+		length += 8
+	}
+	if oldAvr3 > 0xb0 || d.avrPlc >= 0x2a00 && oldAvr2 < 0x40 {
+		d.maxDist3 = 0x7f00
+	} else {
+		d.maxDist3 = 0x2001
+	}
+	d.pushDist(dist, length)
+	d.copyString(w, dist, length)
+}
+
+func (d *decoder15) huffDecode(w *window) {
+	bitField := d.br.peek(16)
+	var place int
+	switch {
+	case d.avrPlc > 0x75ff:
+		place = int(d.decodeNum(&tabHf4))
+	case d.avrPlc > 0x5dff:
+		place = int(d.decodeNum(&tabHf3))
+	case d.avrPlc > 0x35ff:
+		place = int(d.decodeNum(&tabHf2))
+	case d.avrPlc > 0x0dff:
+		place = int(d.decodeNum(&tabHf1))
+	default:
+		place = int(d.decodeNum(&tabHf0))
+	}
+	place &= 0xff
+	if d.stMode {
+		if place == 0 && bitField > 0xfff {
+			place = 0x100
+		}
+		if place--; place == -1 {
+			bitField = d.br.peek(16)
+			d.br.skip(1)
+			if bitField&0x8000 != 0 {
+				d.numHuf = 0
+				d.stMode = false
+				return
+			}
+			length := uint(3)
+			if bitField&0x4000 != 0 {
+				length = 4
+			}
+			d.br.skip(1)
+			dist := d.decodeNum(&tabHf2)
+			dist = dist<<5 | d.br.peek(16)>>11
+			d.br.skip(5)
+			d.copyString(w, dist, length)
+			return
+		}
+	} else if d.numHuf++; d.numHuf > 16 && d.flagsCnt == 0 {
+		d.stMode = true
+	}
+	d.avrPlc += uint(place)
+	d.avrPlc -= d.avrPlc >> 8
+	d.nhfb += 16
+	if d.nhfb > 0xff {
+		d.nhfb = 0x90
+		d.nlzb >>= 1
+	}
+
+	w.writeByte(byte(d.chSet[place] >> 8))
+	d.size--
+
+	var cur, newPlace uint
+	for {
+		cur = d.chSet[place]
+		newPlace = uint(d.nToPl[cur&0xff])
+		d.nToPl[cur&0xff]++
+		cur++
+		if cur&0xff <= 0xa1 {
+			break
+		}
+		corrHuff(&d.chSet, &d.nToPl)
+	}
+	d.chSet[place] = d.chSet[newPlace]
+	d.chSet[newPlace] = cur & 0xffff
+}
+
+// flag returns the next bit of the flags that select the decode operation.
+func (d *decoder15) flag() bool {
+	if d.flagsCnt--; d.flagsCnt < 0 {
+		d.getFlagsBuf()
+		d.flagsCnt = 7
+	}
+	f := d.flagBuf&0x80 != 0
+	d.flagBuf <<= 1
+	return f
+}
+
+// decode performs a single decode operation.
+func (d *decoder15) decode(w *window) error {
+	if d.stMode {
+		d.huffDecode(w)
+		return nil
+	}
+	switch {
+	case d.flag():
+		if d.nlzb > d.nhfb {
+			d.longLZ(w)
+		} else {
+			d.huffDecode(w)
+		}
+	case d.flag():
+		if d.nlzb > d.nhfb {
+			d.huffDecode(w)
+		} else {
+			d.longLZ(w)
+		}
+	default:
+		return d.shortLZ(w)
+	}
+	return nil
+}
+
+func (d *decoder15) fill(w *window) ([]*filterBlock, error) {
+	for w.available() > 0 {
+		if d.size <= 0 {
+			return nil, io.EOF
+		}
+		if err := d.decode(w); err != nil {
+			return nil, err
+		}
+		if err := d.br.error(); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,445 @@
+package rardecode
+
+import (
+	"bytes"
+	"testing"
+)
+
+var codes15 = map[*codeTable15]map[uint]bitList{}
+
+// code15 returns the shortest input decoded by t as v.
+func code15(t *codeTable15, v uint) (bitList, bool) {
+	m := codes15[t]
+	if m == nil {
+		m = make(map[uint]bitList)
+		for num := uint(0); num < 1<<16; num += 16 {
+			x, n := t.decode(num)
+			if _, ok := m[x]; !ok {
+				m[x] = bitsOf(num>>(16-n), n)
+			}
+		}
+		codes15[t] = m
+	}
+	b, ok := m[v]
+	return append(bitList(nil), b...), ok
+}
+
+// place15 returns the index of the entry with high byte v in set.
+func place15(set *[256]uint, v uint) uint {
+	for i, x := range set {
+		if x>>8 == v {
+			return uint(i)
+		}
+	}
+	panic("rardecode: value not in set")
+}
+
+// encoder15 writes RAR 1.5 compressed data. It keeps a copy of the decoder
+// state which it updates by running the decoder on every code it writes, so
+// it only has to choose codes for the current state.
+type encoder15 struct {
+	t     testing.TB
+	d     decoder15
+	w     window
+	parts []*bitList
+	group *bitList // flags not yet known
+	flags bitList
+	hist  []byte
+	pos   int
+	chain map[uint32][]int // positions of 3 byte strings in hist
+}
+
+func newEncoder15(t testing.TB) *encoder15 {
+	e := &encoder15{t: t, chain: make(map[uint32][]int)}
+	e.w.reset(16, true)
+	e.d.init(bytes.NewReader(nil), true)
+	e.d.size = 1 << 62
+	return e
+}
+
+// pack15 compresses files, as a solid stream if solid is set.
+func pack15(t testing.TB, solid bool, files []testFile) [][]byte {
+	var packed [][]byte
+	e := newEncoder15(t)
+	for _, f := range files {
+		if !solid {
+			e = newEncoder15(t)
+		}
+		packed = append(packed, e.encode(f.data))
+	}
+	return packed
+}
+
+func (e *encoder15) encode(data []byte) []byte {
+	e.parts = nil
+	e.d.flagsCnt, e.d.flagBuf = 0, 0
+	e.d.stMode = false
+	e.d.lCount = 0
+	if len(data) > 0 {
+		e.newGroup()
+		e.d.flagsCnt = 8
+	}
+	e.hist = append(e.hist, data...)
+	for e.pos < len(e.hist) {
+		e.next()
+	}
+	if e.group != nil {
+		e.closeGroup()
+	}
+	var b bitList
+	for _, p := range e.parts {
+		b = append(b, *p...)
+	}
+	return b.bytes()
+}
+
+func (e *encoder15) run(b bitList, op func(), n int) {
+	e.t.Helper()
+	runBits(e.t, &e.d.br, &e.w, b, op, e.hist[e.pos:e.pos+n])
+	e.parts = append(e.parts, &b)
+	for end := e.pos + n; e.pos < end; e.pos++ {
+		if e.pos+3 <= len(e.hist) {
+			k := e.key(e.pos)
+			e.chain[k] = append(e.chain[k], e.pos)
+		}
+	}
+}
+
+func (e *encoder15) key(i int) uint32 {
+	return uint32(e.hist[i]) | uint32(e.hist[i+1])<<8 | uint32(e.hist[i+2])<<16
+}
+
+func (e *encoder15) newGroup() {
+	e.group = new(bitList)
+	e.parts = append(e.parts, e.group)
+	e.flags = nil
+}
+
+// closeGroup writes the code for the flags collected in the current group.
+func (e *encoder15) closeGroup() {
+	var f uint
+	for i := 0; i < 8; i++ {
+		f <<= 1
+		if i < len(e.flags) {
+			f |= uint(e.flags[i])
+		}
+	}
+	b, ok := code15(&tabHf2, place15(&e.d.chSetC, f))
+	if !ok {
+		e.t.Fatalf("no code for flags %x", f)
+	}
+	runBits(e.t, &e.d.br, &e.w, b, e.d.getFlagsBuf, nil)
+	if e.d.flagBuf != f {
+		e.t.Fatalf("flags %x decoded as %x", f, e.d.flagBuf)
+	}
+	*e.group = b
+	e.group = nil
+}
+
+func (e *encoder15) flag(bit byte) {
+	if e.d.flagsCnt--; e.d.flagsCnt < 0 {
+		e.newGroup()
+		e.d.flagsCnt = 7
+	}
+	e.flags = append(e.flags, bit)
+	if len(e.flags) == 8 {
+		e.closeGroup()
+	}
+}
+
+// flagOp writes the flags selecting huffDecode if huff is set, otherwise longLZ.
+func (e *encoder15) flagOp(huff bool) {
+	if huff != (e.d.nlzb > e.d.nhfb) {
+		e.flag(1)
+	} else {
+		e.flag(0)
+		e.flag(1)
+	}
+}
+
+func (e *encoder15) huffTable() *codeTable15 {
+	switch {
+	case e.d.avrPlc > 0x75ff:
+		return &tabHf4
+	case e.d.avrPlc > 0x5dff:
+		return &tabHf3
+	case e.d.avrPlc > 0x35ff:
+		return &tabHf2
+	case e.d.avrPlc > 0x0dff:
+		return &tabHf1
+	}
+	return &tabHf0
+}
+
+func (e *encoder15) huffDecode() { e.d.huffDecode(&e.w) }
+func (e *encoder15) longLZ()     { e.d.longLZ(&e.w) }
+func (e *encoder15) shortLZ() {
+	if err := e.d.shortLZ(&e.w); err != nil {
+		e.t.Fatal(err)
+	}
+}
+
+func (e *encoder15) leaveStMode() {
+	b, ok := code15(e.huffTable(), 0)
+	if !ok {
+		e.t.Fatal("no code for place 0")
+	}
+	e.run(append(b, 1), e.huffDecode, 0)
+	if e.d.stMode {
+		e.t.Fatal("still in stMode")
+	}
+}
+
+func (e *encoder15) literal() {
+	p := place15(&e.d.chSet, uint(e.hist[e.pos]))
+	if e.d.stMode {
+		if b, ok := code15(e.huffTable(), p+1); ok && p < 255 {
+			e.run(b, e.huffDecode, 1)
+			return
+		}
+		e.leaveStMode()
+	}
+	b, ok := code15(e.huffTable(), p)
+	if !ok {
+		e.t.Fatalf("no code for place %d", p)
+	}
+	e.flagOp(true)
+	e.run(b, e.huffDecode, 1)
+}
+
+// next writes the next literal or match.
+func (e *encoder15) next() {
+	i := e.pos
+	length := func(dist int) int {
+		n := 0
+		for i+n < len(e.hist) && n < 0x100 && e.hist[i+n-dist] == e.hist[i+n] {
+			n++
+		}
+		return n
+	}
+	best, dist := 0, 0
+	try := func(d int) {
+		if d > 0 && d <= i && d <= 0xffff {
+			if n := length(d); n > best {
+				best, dist = n, d
+			}
+		}
+	}
+	for _, d := range e.d.oldDist {
+		try(int(d))
+	}
+	for d := 1; d <= 256; d++ {
+		try(d)
+	}
+	if i+3 <= len(e.hist) {
+		c := e.chain[e.key(i)]
+		for j := len(c) - 1; j >= 0 && j >= len(c)-32; j-- {
+			try(i - c[j])
+		}
+	}
+	for n := best; n >= 2; n-- {
+		if e.match(n, dist) {
+			return
+		}
+	}
+	e.literal()
+}
+
+// match writes a match of length n at distance dist if possible.
+func (e *encoder15) match(n, dist int) bool {
+	if e.d.stMode && (n == 3 || n == 4) {
+		if b, ok := e.stMatch(n, dist); ok {
+			e.run(b, e.huffDecode, n)
+			return true
+		}
+	}
+	if b, ok := e.shortBits(uint(n), uint(dist)); ok {
+		if e.d.stMode {
+			e.leaveStMode()
+		}
+		e.flag(0)
+		e.flag(0)
+		e.run(b, e.shortLZ, n)
+		return true
+	}
+	if b, ok := e.longBits(uint(n), uint(dist)); ok {
+		if e.d.stMode {
+			e.leaveStMode()
+		}
+		e.flagOp(false)
+		e.run(b, e.longLZ, n)
+		return true
+	}
+	return false
+}
+
+// stMatch returns the code for a match read by huffDecode in stMode.
+func (e *encoder15) stMatch(n, dist int) (bitList, bool) {
+	b, ok := code15(e.huffTable(), 0)
+	d, ok2 := code15(&tabHf2, uint(dist>>5))
+	if !ok || !ok2 {
+		return nil, false
+	}
+	b = append(b, 0, byte(n-3))
+	b = append(b, d...)
+	return append(b, bitsOf(uint(dist), 5)...), true
+}
+
+// shortBits returns the code for a match read by shortLZ.
+func (e *encoder15) shortBits(n, dist uint) (bitList, bool) {
+	d := &e.d
+	repeat := dist == d.lastDist && n == d.lastLength
+	var pre bitList
+	if d.lCount == 2 {
+		if repeat {
+			return bitList{1}, true
+		}
+		pre = bitList{0}
+	}
+	code := func(idx uint, rest bitList) (bitList, bool) {
+		lens, xors, special := &shortLen1, &shortXor1, uint(1)
+		if d.avrLn1 >= 37 {
+			lens, xors, special = &shortLen2, &shortXor2, 3
+		}
+		nbits := func(i uint) uint {
+			if i == special {
+				return d.buf60 + 3
+			}
+			return lens[i]
+		}
+		b := append(bitsOf(xors[idx]>>(8-nbits(idx)), nbits(idx)), rest...)
+		var field uint
+		for i := 0; i < 8; i++ {
+			field <<= 1
+			if i < len(b) {
+				field |= uint(b[i])
+			}
+		}
+		for i := uint(0); i < idx; i++ {
+			if (field^xors[i])&^(0xff>>nbits(i)) == 0 {
+				return nil, false
+			}
+		}
+		return append(pre, b...), true
+	}
+	if repeat {
+		if b, ok := code(9, nil); ok {
+			return b, true
+		}
+	}
+	if dist <= 256 && n >= 2 && n <= 10 {
+		var p uint
+		for d.chSetA[p] != dist-1 {
+			p++
+		}
+		if rest, ok := code15(&tabHf2, p); ok {
+			if b, ok := code(n-2, rest); ok {
+				return b, true
+			}
+		}
+	}
+	for k := 1; k <= 4; k++ {
+		if d.oldDist[(d.oldDistPtr-k)&3] != dist {
+			continue
+		}
+		l := int(n) - 2
+		if dist > 256 {
+			l--
+		}
+		if dist >= d.maxDist3 {
+			l--
+		}
+		if l < 0 || l+2 == 0x101 && k == 1 {
+			continue
+		}
+		if rest, ok := code15(&tabL1, uint(l)); ok {
+			if b, ok := code(uint(9+k), rest); ok {
+				return b, true
+			}
+		}
+	}
+	if dist >= 0x8000 && n >= 5 {
+		if rest, ok := code15(&tabL2, n-5); ok {
+			if b, ok := code(14, append(rest, bitsOf(dist, 15)...)); ok {
+				return b, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// longBits returns the code for a match read by longLZ.
+func (e *encoder15) longBits(n, dist uint) (bitList, bool) {
+	d := &e.d
+	if dist > 0x7fff {
+		return nil, false
+	}
+	l := int(n) - 3
+	if dist >= d.maxDist3 {
+		l--
+	}
+	if dist <= 256 {
+		l -= 8
+	}
+	var b bitList
+	ok := true
+	switch {
+	case l < 0:
+		return nil, false
+	case d.avrLn2 >= 122:
+		b, ok = code15(&tabL2, uint(l))
+	case d.avrLn2 >= 64:
+		b, ok = code15(&tabL1, uint(l))
+	case l < 8:
+		b = append(make(bitList, l), 1)
+	case l < 0x100:
+		b = bitsOf(uint(l), 16)
+	default:
+		return nil, false
+	}
+	if !ok {
+		return nil, false
+	}
+	t := &tabHf0
+	if d.avrPlcB > 0x28ff {
+		t = &tabHf2
+	} else if d.avrPlcB > 0x6ff {
+		t = &tabHf1
+	}
+	p, ok := code15(t, place15(&d.chSetB, dist>>7))
+	if !ok {
+		return nil, false
+	}
+	b = append(b, p...)
+	return append(b, bitsOf(dist, 7)...), true
+}
+
+func TestDecode15(t *testing.T) {
+	// data that switches between literals, short and long matches, and runs
+	// long enough for the decoder to enter and leave stMode
+	var data []byte
+	for i := 0; i < 40; i++ {
+		data = append(data, legacyText(200, uint32(i%7))...)
+		data = append(data, byte(i*37), byte(i*11), byte(i))
+		for j := 0; j < 30; j++ {
+			data = append(data, byte(j*j+i))
+		}
+	}
+	files := []testFile{{"a", data}, {"b", data[1000:3000]}, {"c", nil}, {"d", []byte("x")}}
+	for _, solid := range []bool{false, true} {
+		arc := legacyArchive(15, solid, files, pack15(t, solid, files))
+		r, err := NewReader(bytes.NewReader(arc), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range files {
+			if _, err := r.Next(); err != nil {
+				t.Fatal(err)
+			}
+			var b bytes.Buffer
+			if _, err := b.ReadFrom(r); err != nil || !bytes.Equal(b.Bytes(), f.data) {
+				t.Fatalf("solid %v, %s: read %d of %d bytes, %v", solid, f.name, b.Len(), len(f.data), err)
+			}
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package rardecode
+
+import (
+	"io"
+	"math"
+)
+
+const (
+	mainSize20   = 298 // literals, repeats, table change and lengths
+	offsetSize20 = 48
+	repeatSize20 = 28 // lengths used with old offsets
+	audioSize20  = 257
+	bitLength20  = 19
+	tableSize20  = mainSize20 + offsetSize20 + repeatSize20
+
+	maxChannels20 = 4
+)
+
+var (
+	offsetBase20      = offsetBase[:offsetSize20]
+	offsetExtraBits20 = [offsetSize20]uint{0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6,
+		6, 7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13, 14, 14,
+		15, 15, 16, 16, 16, 16, 16, 16, 16, 16, 16, 16, 16, 16, 16, 16}
+)
+
+// sizedDecoder is implemented by decoders for formats that don't mark the end
+// of a file in the compressed data. setSize is called before init with the
+// unpacked file size, or -1 if it is unknown.
+type sizedDecoder interface {
+	setSize(n int64)
+}
+
+// audio20 holds the predictor state of one audio channel.
+type audio20 struct {
+	k         [5]int
+	d         [4]int
+	lastDelta int
+	dif       [11]uint32
+	byteCount uint
+	lastChar  int
+}
+
+// decoder20 implements the decoder interface for RAR 2.0 compression
+// (unpack versions 20 and 26). Blocks are either LZ with three huffman
+// tables, or multimedia audio with one table per channel.
+//
+// It has not been checked against archives made by RAR itself yet;
+// TestLegacyArchives fails until they are in testdata/legacy.
+type decoder20 struct {
+	br   bitInput
+	size int64 // bytes left to decode in the current file
+
+	tablesRead bool
+	audio      bool // current block is audio
+	channels   int
+	channel    int // current audio channel
+	delta      int // last delta of any channel
+	aud        [maxChannels20]audio20
+
+	codeLength [audioSize20 * maxChannels20]byte // code lengths from the previous table
+	main       huffmanDecoder
+	offset     huffmanDecoder
+	length     huffmanDecoder
+	audioDec   [maxChannels20]huffmanDecoder
+
+	oldOffset  [4]int
+	offsetPtr  int
+	lastOffset int
+	lastLength int
+}
+
+func (d *decoder20) setSize(n int64) { d.size = unpackSize(n) }
+
+// unpackSize returns n, or the largest size if n is unknown.
+func unpackSize(n int64) int64 {
+	if n < 0 {
+		return math.MaxInt64
+	}
+	return n
+}
+
+func (d *decoder20) init(r io.ByteReader, reset bool) error {
+	d.br.reset(r)
+	if reset {
+		d.tablesRead = false
+		d.audio = false
+		d.channels = 1
+		d.channel = 0
+		d.delta = 0
+		d.aud = [maxChannels20]audio20{}
+		d.codeLength = [len(d.codeLength)]byte{}
+		d.oldOffset = [4]int{}
+		d.offsetPtr = 0
+		d.lastOffset = 0
+		d.lastLength = 0
+	}
+	if d.tablesRead || d.size <= 0 {
+		return nil
+	}
+	return d.readTables()
+}
+
+// readSym reads a symbol coded with h. Unlike huffmanDecoder.readSym it
+// can decode the last symbols when fewer than 15 bits are left.
+func readSym20(br *bitInput, h *huffmanDecoder) (int, error) {
+	v := int(br.peek(maxCodeLength))
+	if v < h.limit[h.quickbits] {
+		i := v >> (maxCodeLength - h.quickbits)
+		br.skip(h.quicklen[i])
+		return h.quicksym[i], nil
+	}
+	var bits uint
+	for i, n := range h.limit[h.min:] {
+		if v < n {
+			bits = h.min + uint(i)
+			break
+		}
+	}
+	if bits == 0 {
+		return 0, ErrHuffDecodeFailed
+	}
+	br.skip(bits)
+	pos := h.pos[bits] + (v-h.limit[bits-1])>>(maxCodeLength-bits)
+	if pos >= len(h.symbol) {
+		return 0, ErrHuffDecodeFailed
+	}
+	return h.symbol[pos], nil
+}
+
+// readTables reads the block type and code length tables for a new block.
+func (d *decoder20) readTables() error {
+	br := &d.br
+	d.audio = br.bits(1) > 0
+	if br.bits(1) == 0 {
+		d.codeLength = [len(d.codeLength)]byte{}
+	}
+	n := tableSize20
+	if d.audio {
+		d.channels = int(br.bits(2)) + 1
+		if d.channel >= d.channels {
+			d.channel = 0
+		}
+		n = audioSize20 * d.channels
+	}
+
+	var bl [bitLength20]byte
+	for i := range bl {
+		bl[i] = byte(br.bits(4))
+	}
+	var h huffmanDecoder
+	h.init(bl[:])
+
+	var cl [len(d.codeLength)]byte
+	for i := 0; i < n; {
+		sym, err := readSym20(br, &h)
+		if err != nil {
+			return err
+		}
+		switch {
+		case sym < 16:
+			cl[i] = (byte(sym) + d.codeLength[i]) & 0xf
+			i++
+		case sym == 16:
+			if i == 0 {
+				return ErrInvalidLengthTable
+			}
+			for c := br.bits(2) + 3; c > 0 && i < n; c-- {
+				cl[i] = cl[i-1]
+				i++
+			}
+		default:
+			var c uint
+			if sym == 17 {
+				c = br.bits(3) + 3
+			} else {
+				c = br.bits(7) + 11
+			}
+			for ; c > 0 && i < n; c-- {
+				cl[i] = 0
+				i++
+			}
+		}
+	}
+	if err := br.error(); err != nil {
+		return err
+	}
+	if d.audio {
+		for i := 0; i < d.channels; i++ {
+			d.audioDec[i].init(cl[i*audioSize20 : (i+1)*audioSize20])
+		}
+	} else {
+		d.main.init(cl[:mainSize20])
+		d.offset.init(cl[mainSize20 : mainSize20+offsetSize20])
+		d.length.init(cl[mainSize20+offsetSize20 : tableSize20])
+	}
+	copy(d.codeLength[:n], cl[:n])
+	d.tablesRead = true
+	return nil
+}
+
+// decodeAudio predicts the next sample of the current channel and returns
+// it corrected by delta.
+func (d *decoder20) decodeAudio(delta int) byte {
+	v := &d.aud[d.channel]
+	v.byteCount++
+	v.d[3] = v.d[2]
+	v.d[2] = v.d[1]
+	v.d[1] = v.lastDelta - v.d[0]
+	v.d[0] = v.lastDelta
+	p := 8*v.lastChar + v.k[0]*v.d[0] + v.k[1]*v.d[1] + v.k[2]*v.d[2] + v.k[3]*v.d[3] + v.k[4]*d.delta
+	p = (p >> 3) & 0xff
+
+	ch := uint32(p - delta)
+	dd := int(int8(delta)) << 3
+
+	v.dif[0] += uint32(abs(dd))
+	for i, x := range [5]int{v.d[0], v.d[1], v.d[2], v.d[3], d.delta} {
+		v.dif[2*i+1] += uint32(abs(dd - x))
+		v.dif[2*i+2] += uint32(abs(dd + x))
+	}
+
+	v.lastDelta = int(int8(ch - uint32(v.lastChar)))
+	d.delta = v.lastDelta
+	v.lastChar = int(int32(ch))
+
+	if v.byteCount&0x1f == 0 {
+		min, num := v.dif[0], 0
+		v.dif[0] = 0
+		for i := 1; i < len(v.dif); i++ {
+			if v.dif[i] < min {
+				min, num = v.dif[i], i
+			}
+			v.dif[i] = 0
+		}
+		if num > 0 {
+			k := &v.k[(num-1)/2]
+			if num&1 == 1 {
+				if *k >= -16 {
+					*k--
+				}
+			} else if *k < 16 {
+				*k++
+			}
+		}
+	}
+	return byte(ch)
+}
+
+func (d *decoder20) copyBytes(w *window, length, offset int) {
+	d.lastOffset = offset
+	d.oldOffset[d.offsetPtr&3] = offset
+	d.offsetPtr++
+	d.lastLength = length
+	w.copyBytes(length, offset)
+	d.size -= int64(length)
+}
+
+// decode performs a single decode operation.
+func (d *decoder20) decode(w *window) error {
+	br := &d.br
+	if d.audio {
+		sym, err := readSym20(br, &d.audioDec[d.channel])
+		if err != nil {
+			return err
+		}
+		if sym == 256 {
+			return d.readTables()
+		}
+		w.writeByte(d.decodeAudio(sym))
+		if d.channel++; d.channel == d.channels {
+			d.channel = 0
+		}
+		d.size--
+		return nil
+	}
+	sym, err := readSym20(br, &d.main)
+	if err != nil {
+		return err
+	}
+	switch {
+	case sym < 256:
+		w.writeByte(byte(sym))
+		d.size--
+	case sym == 256:
+		d.copyBytes(w, d.lastLength, d.lastOffset)
+	case sym < 261:
+		offset := d.oldOffset[(d.offsetPtr-(sym-256))&3]
+		i, err := readSym20(br, &d.length)
+		if err != nil {
+			return err
+		}
+		length := lengthBase[i] + 2 + int(br.bits(lengthExtraBits[i]))
+		if offset >= 0x101 {
+			length++
+			if offset >= 0x2000 {
+				length++
+				if offset >= 0x40000 {
+					length++
+				}
+			}
+		}
+		d.copyBytes(w, length, offset)
+	case sym < 269:
+		i := sym - 261
+		offset := shortOffsetBase[i] + 1 + int(br.bits(shortOffsetExtraBits[i]))
+		d.copyBytes(w, 2, offset)
+	case sym == 269:
+		return d.readTables()
+	default:
+		i := sym - 270
+		length := lengthBase[i] + 3 + int(br.bits(lengthExtraBits[i]))
+		i, err = readSym20(br, &d.offset)
+		if err != nil {
+			return err
+		}
+		offset := offsetBase20[i] + 1 + int(br.bits(offsetExtraBits20[i]))
+		if offset >= 0x2000 {
+			length++
+			if offset >= 0x40000 {
+				length++
+			}
+		}
+		d.copyBytes(w, length, offset)
+	}
+	return nil
+}
+
+func (d *decoder20) fill(w *window) ([]*filterBlock, error) {
+	for w.available() > 0 {
+		if d.size <= 0 {
+			return nil, io.EOF
+		}
+		if err := d.decode(w); err != nil {
+			return nil, err
+		}
+		if err := d.br.error(); err != nil {
+			return nil, err
+		}
+		if d.size <= 0 {
+			d.readLastTables()
+		}
+	}
+	return nil, nil
+}
+
+// readLastTables reads a table change following the last symbol of a file,
+// which applies to the next file in a solid archive.
+func (d *decoder20) readLastTables() {
+	if !d.br.ahead(5) {
+		return
+	}
+	if d.audio {
+		if sym, err := readSym20(&d.br, &d.audioDec[d.channel]); err == nil && sym == 256 {
+			d.readTables()
+		}
+	} else if sym, err := readSym20(&d.br, &d.main); err == nil && sym == 269 {
+		d.readTables()
+	}
+}
@@ -0,0 +1,456 @@
+package rardecode
+
+import (
+	"bytes"
+	"sort"
+	"testing"
+)
+
+// block20 describes a RAR 2.0 block to write.
+type block20 struct {
+	n        int // bytes in the block
+	channels int // audio channels, 0 for an LZ block
+}
+
+const (
+	table20Main = iota
+	table20Offset
+	table20Length
+)
+
+// sym20 is a symbol in table, which is the channel for audio blocks,
+// followed by extra bits.
+type sym20 struct {
+	table int
+	v     int
+	extra bitList
+}
+
+// op20 is a single decode operation writing n bytes.
+type op20 struct {
+	syms []sym20
+	n    int
+}
+
+// huffLengths returns code lengths of at most max bits for symbols with
+// the frequencies in freq.
+func huffLengths(freq []int, max byte) []byte {
+	f := append([]int(nil), freq...)
+	for {
+		lens := make([]byte, len(f))
+		type node struct {
+			w    int
+			syms []int
+		}
+		var nodes []node
+		for i, w := range f {
+			if w > 0 {
+				nodes = append(nodes, node{w, []int{i}})
+			}
+		}
+		if len(nodes) == 1 {
+			lens[nodes[0].syms[0]] = 1
+		}
+		for len(nodes) > 1 {
+			sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].w < nodes[j].w })
+			n := node{nodes[0].w + nodes[1].w, append(append([]int(nil), nodes[0].syms...), nodes[1].syms...)}
+			for _, s := range n.syms {
+				lens[s]++
+			}
+			nodes = append(nodes[2:], n)
+		}
+		ok := true
+		for _, l := range lens {
+			ok = ok && l <= max
+		}
+		if ok {
+			return lens
+		}
+		for i := range f {
+			f[i] = (f[i] + 1) / 2
+		}
+	}
+}
+
+// huffCodes returns the canonical codes for code lengths lens.
+func huffCodes(lens []byte) []bitList {
+	var count [maxCodeLength + 1]int
+	for _, l := range lens {
+		if l > 0 {
+			count[l]++
+		}
+	}
+	var next [maxCodeLength + 1]uint
+	code := uint(0)
+	for i := 1; i <= maxCodeLength; i++ {
+		code = (code + uint(count[i-1])) << 1
+		next[i] = code
+	}
+	codes := make([]bitList, len(lens))
+	for i, l := range lens {
+		if l > 0 {
+			codes[i] = bitsOf(next[l], uint(l))
+			next[l]++
+		}
+	}
+	return codes
+}
+
+// slot returns the index i of the last base <= v, and the extra bits for v.
+func slot(base []int, extra []uint, v int) (int, bitList, bool) {
+	i := sort.Search(len(base), func(i int) bool { return base[i] > v }) - 1
+	if i < 0 || v-base[i] >= 1<<extra[i] {
+		return 0, nil, false
+	}
+	return i, bitsOf(uint(v-base[i]), extra[i]), true
+}
+
+// encoder20 writes RAR 2.0 compressed data. Like encoder15 it runs the
+// decoder on everything it writes to follow the decoder state.
+type encoder20 struct {
+	t       testing.TB
+	d       decoder20
+	w       window
+	scratch window
+	hist    []byte
+	pos     int
+	chain   map[uint32][]int
+	codes   [][]bitList // codes of the current tables
+}
+
+func newEncoder20(t testing.TB) *encoder20 {
+	e := &encoder20{t: t, chain: make(map[uint32][]int)}
+	e.w.reset(16, true)
+	e.scratch.reset(16, true)
+	e.d.init(bytes.NewReader(nil), true)
+	e.d.size = 1 << 62
+	return e
+}
+
+// pack20 compresses files, splitting each into blocks.
+func pack20(t testing.TB, solid bool, files []testFile, blocks [][]block20) [][]byte {
+	if solid {
+		return newEncoder20(t).encode(files, blocks)
+	}
+	var packed [][]byte
+	for i, f := range files {
+		packed = append(packed, newEncoder20(t).encode([]testFile{f}, blocks[i:i+1])...)
+	}
+	return packed
+}
+
+func (e *encoder20) encode(files []testFile, blocks [][]block20) [][]byte {
+	for _, f := range files {
+		e.hist = append(e.hist, f.data...)
+	}
+	for i := 0; i+3 <= len(e.hist); i++ {
+		k := uint32(e.hist[i]) | uint32(e.hist[i+1])<<8 | uint32(e.hist[i+2])<<16
+		e.chain[k] = append(e.chain[k], i)
+	}
+	out := make([]bitList, len(files))
+	prev := -1 // file of the previous block
+	for fi, bl := range blocks {
+		for bi, b := range bl {
+			last := fi == len(blocks)-1 && bi == len(bl)-1
+			ops, cl, codes := e.plan(b, !last)
+			var hdr bitList
+			switch {
+			case prev < 0:
+				e.run(&out[fi], e.header(b, cl), func() {
+					if err := e.d.readTables(); err != nil {
+						e.t.Fatal(err)
+					}
+				}, 0)
+			case prev != fi:
+				hdr = append(e.switchCode(), e.header(b, cl)...)
+				e.run(&out[prev], hdr, e.d.readLastTables, 0)
+			default:
+				hdr = append(e.switchCode(), e.header(b, cl)...)
+				e.run(&out[fi], hdr, e.decode, 0)
+			}
+			if !e.d.tablesRead {
+				e.t.Fatal("tables not read")
+			}
+			e.codes = codes
+			for _, op := range ops {
+				var b bitList
+				for _, s := range op.syms {
+					b = append(append(b, e.codes[s.table][s.v]...), s.extra...)
+				}
+				e.run(&out[fi], b, e.decode, op.n)
+			}
+			prev = fi
+		}
+	}
+	packed := make([][]byte, len(out))
+	for i, b := range out {
+		packed[i] = b.bytes()
+	}
+	return packed
+}
+
+func (e *encoder20) decode() {
+	if err := e.d.decode(&e.w); err != nil {
+		e.t.Fatal(err)
+	}
+}
+
+func (e *encoder20) run(out *bitList, b bitList, op func(), n int) {
+	e.t.Helper()
+	runBits(e.t, &e.d.br, &e.w, b, op, e.hist[e.pos:e.pos+n])
+	*out = append(*out, b...)
+	e.pos += n
+}
+
+// switchCode returns the code ending the current block.
+func (e *encoder20) switchCode() bitList {
+	if e.d.audio {
+		return e.codes[e.d.channel][256]
+	}
+	return e.codes[table20Main][269]
+}
+
+// plan returns the operations for block b, and the code lengths and codes
+// of its tables. If more is set the tables include the code ending the block.
+func (e *encoder20) plan(b block20, more bool) ([]op20, []byte, [][]bitList) {
+	c := e.d // decoder state at the start of the block
+	var sizes []int
+	if b.channels > 0 {
+		c.channels = b.channels
+		if c.channel >= c.channels {
+			c.channel = 0
+		}
+		for i := 0; i < b.channels; i++ {
+			sizes = append(sizes, audioSize20)
+		}
+	} else {
+		sizes = []int{mainSize20, offsetSize20, repeatSize20}
+	}
+	var ops []op20
+	for i, end := e.pos, e.pos+b.n; i < end; {
+		var op op20
+		if b.channels > 0 {
+			v := c.aud[c.channel]
+			delta := c.delta
+			p := c.decodeAudio(0)
+			c.aud[c.channel], c.delta = v, delta
+			sym := int(p - e.hist[i])
+			if c.decodeAudio(sym) != e.hist[i] {
+				e.t.Fatal("audio prediction differs")
+			}
+			op = op20{[]sym20{{c.channel, sym, nil}}, 1}
+			if c.channel++; c.channel == c.channels {
+				c.channel = 0
+			}
+		} else {
+			op = e.lzOp(&c, i, end)
+		}
+		ops = append(ops, op)
+		i += op.n
+	}
+
+	freq := make([][]int, len(sizes))
+	for i, n := range sizes {
+		freq[i] = make([]int, n)
+	}
+	for _, op := range ops {
+		freq[op.syms[0].table][op.syms[0].v]++
+		if len(op.syms) > 1 {
+			freq[op.syms[1].table][op.syms[1].v]++
+		}
+	}
+	if more {
+		if b.channels > 0 {
+			freq[c.channel][256]++
+		} else {
+			freq[table20Main][269]++
+		}
+	}
+	var cl []byte
+	var codes [][]bitList
+	for _, f := range freq {
+		l := huffLengths(f, maxCodeLength)
+		cl = append(cl, l...)
+		codes = append(codes, huffCodes(l))
+	}
+	return ops, cl, codes
+}
+
+// lzOp returns the operation for the longest match at i, or a literal.
+func (e *encoder20) lzOp(c *decoder20, i, end int) op20 {
+	length := func(dist int) int {
+		n := 0
+		for i+n < end && n < 0x100 && e.hist[i+n-dist] == e.hist[i+n] {
+			n++
+		}
+		return n
+	}
+	best, dist := 0, 0
+	try := func(d int) {
+		if d > 0 && d <= i && d <= 0xffff {
+			if n := length(d); n > best {
+				best, dist = n, d
+			}
+		}
+	}
+	try(c.lastOffset)
+	for _, d := range c.oldOffset {
+		try(d)
+	}
+	for d := 1; d <= 256; d++ {
+		try(d)
+	}
+	if i+3 <= len(e.hist) {
+		ch := e.chain[uint32(e.hist[i])|uint32(e.hist[i+1])<<8|uint32(e.hist[i+2])<<16]
+		j := sort.SearchInts(ch, i)
+		for k := j - 1; k >= 0 && k >= j-32; k-- {
+			try(i - ch[k])
+		}
+	}
+	op := e.lzMatch(c, best, dist)
+	if op.n == 0 {
+		return op20{[]sym20{{table20Main, int(e.hist[i]), nil}}, 1}
+	}
+	c.copyBytes(&e.scratch, op.n, dist)
+	e.scratch.r = e.scratch.w
+	return op
+}
+
+// lzMatch returns an operation for a match of up to n bytes at dist, or
+// an operation with n 0 if there is none.
+func (e *encoder20) lzMatch(c *decoder20, n, dist int) op20 {
+	if n < 2 {
+		return op20{}
+	}
+	if dist == c.lastOffset && c.lastLength >= 2 && c.lastLength <= n {
+		return op20{[]sym20{{table20Main, 256, nil}}, c.lastLength}
+	}
+	for k := 1; k <= 4; k++ {
+		if c.oldOffset[(c.offsetPtr-k)&3] != dist {
+			continue
+		}
+		adj := 0
+		for _, limit := range []int{0x101, 0x2000, 0x40000} {
+			if dist >= limit {
+				adj++
+			}
+		}
+		l := n - 2 - adj
+		if l > 255 {
+			l = 255
+		}
+		if i, extra, ok := slot(lengthBase[:], lengthExtraBits[:], l); ok {
+			return op20{[]sym20{{table20Main, 256 + k, nil}, {table20Length, i, extra}}, l + 2 + adj}
+		}
+	}
+	l := n - 3
+	for _, limit := range []int{0x2000, 0x40000} {
+		if dist >= limit {
+			l--
+		}
+	}
+	if l >= 0 {
+		if l > 255 {
+			n -= l - 255
+			l = 255
+		}
+		i, lextra, _ := slot(lengthBase[:], lengthExtraBits[:], l)
+		j, oextra, ok := slot(offsetBase20, offsetExtraBits20[:], dist-1)
+		if ok {
+			return op20{[]sym20{{table20Main, 270 + i, lextra}, {table20Offset, j, oextra}}, n}
+		}
+	}
+	if dist <= 256 {
+		i, extra, _ := slot(shortOffsetBase[:], shortOffsetExtraBits[:], dist-1)
+		return op20{[]sym20{{table20Main, 261 + i, extra}}, 2}
+	}
+	return op20{}
+}
+
+// header returns the block header for b with code lengths cl.
+func (e *encoder20) header(b block20, cl []byte) bitList {
+	var h bitList
+	keep := byte(0)
+	if e.d.tablesRead {
+		keep = 1 // code lengths are coded as changes to the previous table
+	}
+	if b.channels > 0 {
+		h = append(h, 1, keep)
+		h = append(h, bitsOf(uint(b.channels-1), 2)...)
+	} else {
+		h = append(h, 0, keep)
+	}
+	var old [len(e.d.codeLength)]byte
+	if keep == 1 {
+		old = e.d.codeLength
+	}
+	var syms []sym20
+	for i := 0; i < len(cl); {
+		run := 1
+		for i+run < len(cl) && cl[i+run] == cl[i] {
+			run++
+		}
+		switch {
+		case cl[i] == 0 && run >= 11:
+			if run > 138 {
+				run = 138
+			}
+			syms = append(syms, sym20{v: 18, extra: bitsOf(uint(run-11), 7)})
+		case cl[i] == 0 && run >= 3:
+			if run > 10 {
+				run = 10
+			}
+			syms = append(syms, sym20{v: 17, extra: bitsOf(uint(run-3), 3)})
+		case i > 0 && cl[i-1] == cl[i] && run >= 3:
+			if run > 6 {
+				run = 6
+			}
+			syms = append(syms, sym20{v: 16, extra: bitsOf(uint(run-3), 2)})
+		default:
+			run = 1
+			syms = append(syms, sym20{v: int(cl[i]-old[i]) & 0xf})
+		}
+		i += run
+	}
+	freq := make([]int, bitLength20)
+	for _, s := range syms {
+		freq[s.v]++
+	}
+	bl := huffLengths(freq, 15)
+	for _, l := range bl {
+		h = append(h, bitsOf(uint(l), 4)...)
+	}
+	codes := huffCodes(bl)
+	for _, s := range syms {
+		h = append(append(h, codes[s.v]...), s.extra...)
+	}
+	return h
+}
+
+func TestDecode20(t *testing.T) {
+	text := legacyText(4000, 5)
+	wave := legacyWave(2400, 3)
+	data := append(append(append([]byte(nil), text...), wave...), text[500:2500]...)
+	files := []testFile{{"a", data}, {"b", text[100:]}, {"c", []byte("xyz")}}
+	blocks := [][]block20{
+		{{2000, 0}, {2000, 0}, {1200, 3}, {1200, 1}, {2000, 0}},
+		{{3900, 0}},
+		{{3, 2}},
+	}
+	for _, solid := range []bool{false, true} {
+		arc := legacyArchive(20, solid, files, pack20(t, solid, files, blocks))
+		r, err := NewReader(bytes.NewReader(arc), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range files {
+			if _, err := r.Next(); err != nil {
+				t.Fatal(err)
+			}
+			var b bytes.Buffer
+			if _, err := b.ReadFrom(r); err != nil || !bytes.Equal(b.Bytes(), f.data) {
+				t.Fatalf("solid %v, %s: read %d of %d bytes, %v", solid, f.name, b.Len(), len(f.data), err)
+			}
+		}
+	}
+}
@@ -14,8 +14,8 @@ const (
 )
 
 var (
-	errUnknownFilter       = errors.New("rardecode: unknown V5 filter")
-	errCorruptDecodeHeader = errors.New("rardecode: corrupt decode header")
+	ErrUnknownFilter       = errors.New("rardecode: unknown V5 filter")
+	ErrCorruptDecodeHeader = errors.New("rardecode: corrupt decode header")
 )
 
 // decoder50 implements the decoder interface for RAR 5 compression.
@@ -53,7 +53,7 @@ func (d *decoder50) init(r io.ByteReader, reset bool) error {
 	}
 	err := d.readBlockHeader()
 	if err == io.EOF {
-		return errDecoderOutOfData
+		return ErrDecoderOutOfData
 	}
 	return err
 }
@@ -66,7 +66,7 @@ func (d *decoder50) readBlockHeader() error {
 
 	bytecount := (flags>>3)&3 + 1
 	if bytecount == 4 {
-		return errCorruptDecodeHeader
+		return ErrCorruptDecodeHeader
 	}
 
 	hsum, err := d.r.ReadByte()
@@ -86,12 +86,12 @@ func (d *decoder50) readBlockHeader() error {
 		blockBytes |= int(n) << (i * 8)
 	}
 	if sum != hsum { // bad header checksum
-		return errCorruptDecodeHeader
+		return ErrCorruptDecodeHeader
 	}
 	blockBits += (blockBytes - 1) * 8
 
 	// create bit reader for block
-	d.br = limitBitReader(newRarBitReader(d.r), blockBits, errDecoderOutOfData)
+	d.br = limitBitReader(newRarBitReader(d.r), blockBits, ErrDecoderOutOfData)
 	d.lastBlock = flags&0x40 > 0
 
 	if flags&0x80 > 0 {
@@ -179,7 +179,7 @@ func readFilter(br bitReader) (*filterBlock, error) {
 	case 3:
 		fb.filter = filterArm
 	default:
-		return nil, errUnknownFilter
+		return nil, ErrUnknownFilter
 	}
 	return fb, nil
 }
@@ -285,7 +285,7 @@ func (d *decoder50) fill(w *window) ([]*filterBlock, error) {
 		}
 		if err != nil {
 			if err == io.EOF {
-				return fl, errDecoderOutOfData
+				return fl, ErrDecoderOutOfData
 			}
 			return fl, err
 		}
@@ -11,8 +11,8 @@ const (
 )
 
 var (
-	errTooManyFilters = errors.New("rardecode: too many filters")
-	errInvalidFilter  = errors.New("rardecode: invalid filter")
+	ErrTooManyFilters = errors.New("rardecode: too many filters")
+	ErrInvalidFilter  = errors.New("rardecode: invalid filter")
 )
 
 // filter functions take a byte slice, the current output offset and
@@ -160,13 +160,13 @@ func (d *decodeReader) queueFilter(f *filterBlock) error {
 		d.filters = nil
 	}
 	if len(d.filters) >= maxQueuedFilters {
-		return errTooManyFilters
+		return ErrTooManyFilters
 	}
 	// make offset relative to previous filter in list
 	for _, fb := range d.filters {
 		if f.offset < fb.offset {
 			// filter block must not start before previous filter
-			return errInvalidFilter
+			return ErrInvalidFilter
 		}
 		f.offset -= fb.offset
 	}
@@ -189,7 +189,7 @@ func (d *decodeReader) processFilters() (err error) {
 		// fill() didn't return enough bytes
 		err = d.readErr()
 		if err == nil || err == io.EOF {
-			return errInvalidFilter
+			return ErrInvalidFilter
 		}
 		return err
 	}
@@ -220,7 +220,7 @@ func (d *decodeReader) processFilters() (err error) {
 			return nil
 		}
 		if f.length != len(d.outbuf) {
-			return errInvalidFilter
+			return ErrInvalidFilter
 		}
 		d.filters = d.filters[1:]
 
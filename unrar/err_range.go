@@ -1,25 +1,85 @@
 package rardecode
 
-// ErrRange shows invalid data block in file
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ErrRange is returned when a file fails its checksum or can't be decoded.
+// It reports the packed bytes of the file that were read, so the damaged
+// area can be downloaded or repaired again. A file stored across several
+// volumes produces one ErrRange per volume, linked in volume order by Next.
+// The underlying error is available with errors.Is and errors.As.
 type ErrRange struct {
-	File       string
-	Start, End int64
+	File       string    // volume file name, empty for archives read with NewReader
+	Start, End int64     // packed data offsets in File, End is exclusive
+	Next       *ErrRange // range in the following volume, nil if none
 	err        error
 }
 
 func (r *ErrRange) Error() string {
-	return r.err.Error()
+	var b strings.Builder
+	b.WriteString(r.err.Error())
+	b.WriteString(" (packed data")
+	for p := r; p != nil; p = p.Next {
+		if p != r {
+			b.WriteByte(',')
+		}
+		b.WriteByte(' ')
+		if p.File != "" {
+			b.WriteString(p.File)
+			b.WriteByte(':')
+		}
+		b.WriteString(strconv.FormatInt(p.Start, 10))
+		b.WriteByte('-')
+		b.WriteString(strconv.FormatInt(p.End, 10))
+	}
+	b.WriteByte(')')
+	return b.String()
 }
 
-func rangeErr(file string, start int64) *ErrRange {
-	return &ErrRange{
-		File:  file,
-		Start: start,
+// Unwrap returns the checksum or decode error.
+func (r *ErrRange) Unwrap() error { return r.err }
+
+// Ranges returns r and the ranges that follow it in later volumes.
+func (r *ErrRange) Ranges() []*ErrRange {
+	var rs []*ErrRange
+	for p := r; p != nil; p = p.Next {
+		rs = append(rs, p)
 	}
+	return rs
 }
 
-func (r *ErrRange) fill(end int64, err error) error {
-	r.End = end
-	r.err = err
-	return r
+// blockSpan is the packed data of a single file block.
+type blockSpan struct {
+	file       string
+	start, end int64
+}
+
+// rangeErr wraps err in an ErrRange chain covering spans. Blocks in the same
+// volume are merged. err is returned unchanged if it already is an ErrRange
+// or no span positions are known.
+func rangeErr(spans []blockSpan, err error) error {
+	var re *ErrRange
+	if len(spans) == 0 || spans[0].start < 0 || errors.As(err, &re) {
+		return err
+	}
+	var head, tail *ErrRange
+	for _, s := range spans {
+		if tail != nil && tail.File == s.file && s.start >= tail.Start {
+			if s.end > tail.End {
+				tail.End = s.end
+			}
+			continue
+		}
+		r := &ErrRange{File: s.file, Start: s.start, End: s.end, err: err}
+		if tail == nil {
+			head = r
+		} else {
+			tail.Next = r
+		}
+		tail = r
+	}
+	return head
 }
@@ -297,7 +297,7 @@ type vmFilter struct {
 // execute implements v3filter type for VM based RAR 3 filters.
 func (f *vmFilter) execute(r map[int]uint32, global, buf []byte, offset int64) ([]byte, error) {
 	if len(buf) > vmGlobalAddr {
-		return buf, errInvalidFilter
+		return buf, ErrInvalidFilter
 	}
 	v := newVM(buf)
 
@@ -0,0 +1,234 @@
+package rardecode
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FS is a read-only fs.FS view of an archive built from its Index. File
+// contents are decoded on demand when a file is read, nothing is extracted
+// to disk. Directories missing from the archive are added for the parents
+// of its files. Files with names that aren't valid fs paths are left out.
+//
+// Non-solid files are opened at their first block. A solid file is decoded
+// from the start of the archive, so reading many files of a solid archive
+// is better done in archive order with a Reader.
+type FS struct {
+	x    *Index
+	root *fsNode
+}
+
+// fsNode is a file or directory in an FS.
+type fsNode struct {
+	name     string      // base name, "." for the root
+	entry    *IndexEntry // nil for directories added for parents
+	children map[string]*fsNode
+}
+
+func (n *fsNode) isDir() bool {
+	return n.children != nil
+}
+
+// NewFS returns an FS for the archive indexed by x.
+func NewFS(x *Index) *FS {
+	fsys := &FS{x: x, root: &fsNode{name: ".", children: make(map[string]*fsNode)}}
+	for _, e := range x.Files {
+		name := strings.TrimPrefix(path.Clean("/"+e.Name), "/")
+		if name == "" || !fs.ValidPath(name) {
+			continue
+		}
+		dir := fsys.root
+		elems := strings.Split(name, "/")
+		for _, elem := range elems[:len(elems)-1] {
+			n := dir.children[elem]
+			if n == nil || !n.isDir() {
+				n = &fsNode{name: elem, children: make(map[string]*fsNode)}
+				dir.children[elem] = n
+			}
+			dir = n
+		}
+		base := elems[len(elems)-1]
+		n := &fsNode{name: base, entry: e}
+		if e.IsDir {
+			n.children = make(map[string]*fsNode)
+			if old := dir.children[base]; old != nil && old.isDir() {
+				n.children = old.children
+			}
+		}
+		dir.children[base] = n
+	}
+	return fsys
+}
+
+// lookup returns the node called name, or an *fs.PathError for op.
+func (fsys *FS) lookup(op, name string) (*fsNode, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	n := fsys.root
+	if name != "." {
+		for _, elem := range strings.Split(name, "/") {
+			if n = n.children[elem]; n == nil {
+				return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+			}
+		}
+	}
+	return n, nil
+}
+
+// Open opens the file called name. Directories implement fs.ReadDirFile.
+func (fsys *FS) Open(name string) (fs.File, error) {
+	n, err := fsys.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if n.isDir() {
+		return &fsDir{fileInfo: fileInfo{n}, entries: n.dirEntries()}, nil
+	}
+	rc, err := fsys.openEntry(n.entry)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &fsFile{fileInfo: fileInfo{n}, rc: rc}, nil
+}
+
+// openEntry returns a ReadCloser positioned at the contents of e.
+func (fsys *FS) openEntry(e *IndexEntry) (*ReadCloser, error) {
+	rc, err := fsys.x.Open(e.Name)
+	if err != ErrSolidFile && (err != nil || rc.pr.h.Offset == e.Blocks[0].Offset) {
+		return rc, err
+	}
+	if rc != nil {
+		rc.Close() // a later file with the same name
+	}
+	// decode the files before it
+	v, err := openVolumeFunc(fsys.x.Volumes[0], fsys.x.pass, fsys.x.open)
+	if err != nil {
+		return nil, err
+	}
+	rc = &ReadCloser{v: v}
+	rc.init(v)
+	for {
+		h, err := rc.Next()
+		if err == io.EOF {
+			err = errStaleIndex
+		}
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+		if h.VolName == e.Blocks[0].Volume && h.Offset == e.Blocks[0].Offset {
+			return rc, nil
+		}
+	}
+}
+
+// Stat returns a FileInfo for the file called name without opening it.
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	n, err := fsys.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo{n}, nil
+}
+
+// ReadDir returns the entries of the directory called name sorted by name.
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	n, err := fsys.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !n.isDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	return n.dirEntries(), nil
+}
+
+func (n *fsNode) dirEntries() []fs.DirEntry {
+	list := make([]fs.DirEntry, 0, len(n.children))
+	for _, c := range n.children {
+		list = append(list, fileInfo{c})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+	return list
+}
+
+// fileInfo implements fs.FileInfo and fs.DirEntry from the file header.
+type fileInfo struct {
+	n *fsNode
+}
+
+func (fi fileInfo) Name() string { return fi.n.name }
+func (fi fileInfo) IsDir() bool  { return fi.n.isDir() }
+
+func (fi fileInfo) Size() int64 {
+	if fi.n.isDir() {
+		return 0
+	}
+	return fi.n.entry.UnPackedSize
+}
+
+func (fi fileInfo) Mode() fs.FileMode {
+	if fi.n.entry == nil {
+		return fs.ModeDir | 0555
+	}
+	return fi.n.entry.Mode()
+}
+
+func (fi fileInfo) ModTime() time.Time {
+	if fi.n.entry == nil {
+		return time.Time{}
+	}
+	return fi.n.entry.ModificationTime
+}
+
+// Sys returns the *FileHeader of the file, nil for added directories.
+func (fi fileInfo) Sys() interface{} {
+	if fi.n.entry == nil {
+		return nil
+	}
+	return &fi.n.entry.FileHeader
+}
+
+func (fi fileInfo) Type() fs.FileMode          { return fi.Mode().Type() }
+func (fi fileInfo) Info() (fs.FileInfo, error) { return fi, nil }
+
+// fsFile is an open file of an FS.
+type fsFile struct {
+	fileInfo
+	rc *ReadCloser
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) { return f.fileInfo, nil }
+func (f *fsFile) Read(p []byte) (int, error) { return f.rc.Read(p) }
+func (f *fsFile) Close() error               { return f.rc.Close() }
+
+// fsDir is an open directory of an FS.
+type fsDir struct {
+	fileInfo
+	entries []fs.DirEntry
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) { return d.fileInfo, nil }
+func (d *fsDir) Close() error               { return nil }
+
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.n.name, Err: errors.New("is a directory")}
+}
+
+func (d *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	list := d.entries
+	if n > 0 && len(list) > n {
+		list = list[:n]
+	}
+	d.entries = d.entries[len(list):]
+	if n > 0 && len(list) == 0 {
+		return nil, io.EOF
+	}
+	return list, nil
+}
@@ -0,0 +1,66 @@
+package rardecode
+
+import (
+	"bytes"
+	"hash/crc32"
+	"io/fs"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFS(t *testing.T) {
+	files := []testFile{
+		{"top.bin", fill(150, 0x11)},
+		{"dir/one.bin", fill(300, 0x22)}, // spans volumes
+		{"dir/sub/two.bin", fill(100, 0x33)},
+	}
+	names := writeVolumes(t, t.TempDir(), storedVolumes(files, 200))
+	x, err := ReadIndex(names[0], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := NewFS(x)
+	if err = fstest.TestFS(fsys, "top.bin", "dir/one.bin", "dir/sub/two.bin"); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		b, err := fs.ReadFile(fsys, f.name)
+		if err != nil || !bytes.Equal(b, f.data) {
+			t.Errorf("%s: %d bytes, %v", f.name, len(b), err)
+		}
+	}
+	fi, err := fs.Stat(fsys, "dir/sub")
+	if err != nil || !fi.IsDir() || fi.Mode() != fs.ModeDir|0555 {
+		t.Errorf("added directory: %v %v", fi, err)
+	}
+	if _, err = fsys.Open("../top.bin"); err == nil {
+		t.Error("opened an invalid path")
+	}
+}
+
+func TestFSSolid(t *testing.T) {
+	one, two := fill(100, 0x44), fill(200, 0x55)
+	var b bytes.Buffer
+	b.WriteString(sigPrefix + "\x00")
+	b.Write(block(blockArc, arcSolid, make([]byte, 6)))
+	b.Write(storedBlock("one.bin", 0, len(one), crc32.ChecksumIEEE(one), one))
+	b.Write(storedBlock("two.bin", fileSolid, len(two), crc32.ChecksumIEEE(two), two))
+	b.Write(block(blockEnd, 0, nil))
+	name := filepath.Join(t.TempDir(), "solid.rar")
+	if err := ioutil.WriteFile(name, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	x, err := ReadIndex(name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = x.Open("two.bin"); err != ErrSolidFile {
+		t.Fatalf("Index.Open = %v, want ErrSolidFile", err)
+	}
+	got, err := fs.ReadFile(NewFS(x), "two.bin")
+	if err != nil || !bytes.Equal(got, two) {
+		t.Fatalf("two.bin: %d bytes, %v", len(got), err)
+	}
+}
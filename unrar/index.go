@@ -0,0 +1,173 @@
+package rardecode
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+var (
+	ErrSolidFile  = errors.New("rardecode: solid file can't be opened without decoding the files before it")
+	errStaleIndex = errors.New("rardecode: archive changed since it was indexed")
+)
+
+// Block is the packed data of a single file block.
+type Block struct {
+	Volume string // volume file name
+	Offset int64  // offset of the packed data in Volume
+	Size   int64  // size of the packed data
+}
+
+// IndexEntry describes a file in an Index.
+type IndexEntry struct {
+	FileHeader
+	Blocks []Block // file blocks in volume order, more than one if the file spans volumes
+
+	solid bool  // file needs the decoder state of the files before it
+	done  bool  // last block has been indexed
+	vol   int   // volume number of the first block
+	from  int64 // where to start reading headers to find the first block, 0 to read from the volume start
+}
+
+// Index lists the files of an archive and where their blocks are stored.
+// It is built by reading only block headers, and allows non-solid files to be
+// opened without reading the files before them.
+type Index struct {
+	Files   []*IndexEntry // files in archive order
+	Volumes []string      // volume file names
+	Solid   bool          // archive is solid
+
+	old    bool // volumes use the old naming scheme
+	pass   string
+	open   OpenFunc // opens volume files, nil for os.Open
+	byName map[string]*IndexEntry
+}
+
+// skip moves the current volume file to off and discards buffered data.
+func (v *volume) skip(off int64) error {
+	if _, err := v.f.Seek(off, io.SeekStart); err != nil {
+		return err
+	}
+	v.br.Reset(v.f)
+	return nil
+}
+
+// ReadIndex builds an Index of the archive specified by name, seeking past
+// the packed data instead of reading it.
+func ReadIndex(name, password string) (*Index, error) {
+	return ReadIndexFunc(name, password, nil)
+}
+
+// ReadIndexFunc is like ReadIndex but opens the volume files with open, which
+// is kept to open them again in Open.
+func ReadIndexFunc(name, password string, open OpenFunc) (*Index, error) {
+	v, err := openVolumeFunc(name, password, open)
+	if err != nil {
+		return nil, err
+	}
+	defer v.Close()
+	x := &Index{pass: password, open: open, byName: make(map[string]*IndexEntry)}
+	var cur *IndexEntry
+	var from int64 // end of the last file block data in the current volume
+	vol := 0
+	for {
+		h, err := v.next()
+		if err == errArchiveEnd || err == io.EOF {
+			if cur != nil && !cur.done {
+				return nil, errUnexpectedArcEnd
+			}
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if h.Offset < 0 {
+			return nil, errors.New("rardecode: volume file is not seekable")
+		}
+		if v.num != vol {
+			vol = v.num
+			from = 0
+		}
+		if h.first {
+			if cur != nil && !cur.done {
+				return nil, errInvalidFileBlock
+			}
+			cur = &IndexEntry{FileHeader: h.FileHeader, solid: h.solid, vol: v.num, from: from}
+			x.Files = append(x.Files, cur)
+			x.byName[cur.Name] = cur
+		} else if cur == nil || cur.done || h.Name != cur.Name {
+			return nil, errInvalidFileBlock
+		}
+		cur.Blocks = append(cur.Blocks, Block{h.VolName, h.Offset, h.PackedSize})
+		cur.done = h.last
+		from = h.Offset + h.PackedSize
+		if err = v.skip(from); err != nil {
+			return nil, err
+		}
+	}
+	x.Volumes = append([]string(nil), v.files...)
+	x.Solid = v.isSolid()
+	x.old = v.old
+	return x, nil
+}
+
+// Lookup returns the entry for the file called name.
+// If the archive holds several files with the same name the last one is returned.
+func (x *Index) Lookup(name string) (*IndexEntry, bool) {
+	e, ok := x.byName[name]
+	return e, ok
+}
+
+// pendingBlock returns h from the first call to next.
+type pendingBlock struct {
+	fileBlockReader
+	h *fileBlockHeader
+}
+
+func (p *pendingBlock) next() (*fileBlockHeader, error) {
+	if h := p.h; h != nil {
+		p.h = nil
+		return h, nil
+	}
+	return p.fileBlockReader.next()
+}
+
+// Open opens the file called name, starting at the volume that holds its first
+// block. Reads from the returned ReadCloser return the file contents and Next
+// continues with the files that follow it in the archive.
+// Solid files other than the first in a solid group return ErrSolidFile.
+func (x *Index) Open(name string) (*ReadCloser, error) {
+	e, ok := x.byName[name]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	if e.solid {
+		return nil, ErrSolidFile
+	}
+	v, err := openVolumeFunc(x.Volumes[e.vol], x.pass, x.open)
+	if err != nil {
+		return nil, err
+	}
+	v.num = e.vol
+	v.old = x.old
+	v.files = append([]string(nil), x.Volumes[:e.vol+1]...)
+	h, err := v.next() // reads the volume headers before the first file block
+	if err == nil && h.Offset != e.Blocks[0].Offset && e.from > 0 {
+		if err = v.skip(e.from); err == nil {
+			h, err = v.next()
+		}
+	}
+	if err == nil && (!h.first || h.Name != e.Name || h.Offset != e.Blocks[0].Offset) {
+		err = errStaleIndex
+	}
+	if err != nil {
+		v.Close()
+		return nil, err
+	}
+	rc := &ReadCloser{v: v}
+	rc.init(&pendingBlock{v, h})
+	if _, err = rc.Next(); err != nil {
+		v.Close()
+		return nil, err
+	}
+	return rc, nil
+}
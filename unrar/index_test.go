@@ -0,0 +1,81 @@
+package rardecode
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestIndexOpen(t *testing.T) {
+	files := []testFile{
+		{"one.bin", fill(300, 0x11)},
+		{"two.bin", fill(500, 0x22)}, // stored in volumes 1 and 2
+		{"three.bin", fill(100, 0x33)},
+		{"four.bin", fill(50, 0x44)},
+	}
+	vols := storedVolumes(files, 600)
+	names := writeVolumes(t, t.TempDir(), vols)
+
+	x, err := ReadIndex(names[0], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(x.Files) != len(files) || len(x.Volumes) != 2 || x.Solid {
+		t.Fatalf("index %d files, volumes %v", len(x.Files), x.Volumes)
+	}
+	e, ok := x.Lookup("two.bin")
+	if !ok || len(e.Blocks) != 2 {
+		t.Fatalf("two.bin %+v", e)
+	}
+	want := []Block{
+		{names[0], int64(bytes.Index(vols[0], fill(300, 0x22))), 300},
+		{names[1], int64(bytes.Index(vols[1], fill(200, 0x22))), 200},
+	}
+	for i, b := range e.Blocks {
+		if b != want[i] {
+			t.Errorf("block %d = %+v, want %+v", i, b, want[i])
+		}
+	}
+
+	// files in the last volume can be read without the first one
+	if err = os.Remove(names[0]); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files[2:] {
+		rc, err := x.Open(f.name)
+		if err != nil {
+			t.Fatal(f.name, err)
+		}
+		b, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil || !bytes.Equal(b, f.data) {
+			t.Errorf("%s: %d bytes, %v", f.name, len(b), err)
+		}
+	}
+	if _, err = x.Open("missing.bin"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Open missing file: %v", err)
+	}
+}
+
+func TestIndexOpenSpanning(t *testing.T) {
+	files := []testFile{{"one.bin", fill(300, 0x11)}, {"two.bin", fill(500, 0x22)}}
+	names := writeVolumes(t, t.TempDir(), storedVolumes(files, 400))
+	x, err := ReadIndex(names[0], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, err := x.Open("two.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	b, err := ioutil.ReadAll(rc)
+	if err != nil || !bytes.Equal(b, files[1].data) {
+		t.Fatalf("two.bin: %d bytes, %v", len(b), err)
+	}
+	if len(rc.Volumes()) != len(names) {
+		t.Errorf("volumes %v", rc.Volumes())
+	}
+}
@@ -0,0 +1,222 @@
+package rardecode
+
+import (
+	"container/list"
+	"runtime"
+	"sync"
+)
+
+const defaultKeyCacheSize = 256 // number of derived keys kept by default
+
+// keyEntry holds the keys derived for one password, salt and kdf count.
+// done is closed once keys is set.
+type keyEntry struct {
+	done chan struct{}
+	keys [][]byte
+	elem *list.Element
+}
+
+// keyCache is a size bounded LRU cache of derived keys shared by all readers.
+// Concurrent requests for the same keys wait for a single calculation.
+type keyCache struct {
+	mu  sync.Mutex
+	max int
+	m   map[string]*keyEntry
+	lru *list.List // entry ids, most recently used first
+}
+
+var sharedKeys = &keyCache{max: defaultKeyCacheSize, m: make(map[string]*keyEntry), lru: list.New()}
+
+// SetKeyCacheSize sets the number of derived encryption keys cached across
+// all archive readers. Key derivation costs 0x40000 SHA-1 rounds for RAR 3.x
+// and a PBKDF2 run for RAR 5.0, once per password and salt.
+func SetKeyCacheSize(n int) {
+	if n < 1 {
+		n = 1
+	}
+	sharedKeys.mu.Lock()
+	sharedKeys.max = n
+	sharedKeys.evict()
+	sharedKeys.mu.Unlock()
+}
+
+// evict drops the least recently used entries over the size limit.
+// It must be called with c.mu held.
+func (c *keyCache) evict() {
+	for c.lru.Len() > c.max {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		delete(c.m, e.Value.(string))
+	}
+}
+
+// lookup returns the entry for id, creating it if needed. owner is true if
+// the caller created it and must calculate the keys.
+func (c *keyCache) lookup(id string) (e *keyEntry, owner bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.m[id]; ok {
+		c.lru.MoveToFront(e.elem)
+		return e, false
+	}
+	e = &keyEntry{done: make(chan struct{})}
+	e.elem = c.lru.PushFront(id)
+	c.m[id] = e
+	c.evict()
+	return e, true
+}
+
+// get returns the keys for id, calling calc to derive them if they aren't
+// cached or being derived already.
+func (c *keyCache) get(id string, calc func() [][]byte) [][]byte {
+	e, owner := c.lookup(id)
+	if owner {
+		e.keys = calc()
+		close(e.done)
+	}
+	<-e.done
+	return e.keys
+}
+
+// prefetch derives the keys for id on the worker pool unless they are cached
+// or being derived. calc must not refer to buffers that may be reused.
+func (c *keyCache) prefetch(id string, calc func() [][]byte) {
+	e, owner := c.lookup(id)
+	if !owner {
+		return
+	}
+	kdfOnce.Do(startKdfWorkers)
+	kdfJobs <- func() {
+		e.keys = calc()
+		close(e.done)
+	}
+}
+
+var (
+	kdfOnce sync.Once
+	kdfJobs chan func()
+)
+
+// startKdfWorkers starts one key derivation worker per CPU.
+func startKdfWorkers() {
+	n := runtime.GOMAXPROCS(0)
+	kdfJobs = make(chan func(), n)
+	for i := 0; i < n; i++ {
+		go func() {
+			for job := range kdfJobs {
+				job()
+			}
+		}()
+	}
+}
+
+// keyID15 returns the cache id of the RAR 3.x keys for a password and salt.
+func keyID15(pass []uint16, salt []byte) string {
+	b := make([]byte, 0, 1+len(salt)+len(pass)*2)
+	b = append(b, 3)
+	b = append(b, salt...)
+	for _, v := range pass {
+		b = append(b, byte(v), byte(v>>8))
+	}
+	return string(b)
+}
+
+// keyID50 returns the cache id of the RAR 5.0 keys for a password, salt and kdf count.
+func keyID50(pass, salt []byte, kdfCount int) string {
+	b := make([]byte, 0, 6+len(salt)+len(pass))
+	b = append(b, 5, byte(kdfCount), byte(kdfCount>>8), byte(kdfCount>>16), byte(kdfCount>>24), byte(len(salt)))
+	b = append(b, salt...)
+	return string(append(b, pass...))
+}
+
+// prefetchKeys reads ahead through the block headers of the volume file name
+// and starts deriving the keys of every salt it finds on the worker pool,
+// so they are ready when the sequential reader gets there. It stops at the
+// end of the volume or when stop is closed.
+func prefetchKeys(name, password string, stop <-chan struct{}) {
+	s, err := openBlockScanner(name, password)
+	if err != nil {
+		return
+	}
+	defer s.Close()
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		var size int64
+		switch a := s.fbr.(type) {
+		case *archive15:
+			size, err = a.prefetchHeader()
+		case *archive50:
+			size, err = a.prefetchHeader()
+		}
+		if err != nil || s.skip(size) != nil {
+			return
+		}
+	}
+}
+
+// prefetchHeader reads the next block header and starts deriving the keys
+// for its salt. It returns the size of the block data.
+func (a *archive15) prefetchHeader() (int64, error) {
+	h, err := a.readBlockHeader()
+	if err != nil {
+		return 0, err
+	}
+	switch h.htype {
+	case blockArc:
+		a.encrypted = h.flags&arcEncrypted > 0
+	case blockEnd:
+		return 0, errArchiveEnd
+	case blockFile, blockService:
+		if h.flags&(fileEncrypted|fileSalt) != fileEncrypted|fileSalt || h.flags&fileSplitBefore != 0 {
+			break
+		}
+		_, salt, _, err := fileNameSalt15(h)
+		if err != nil || len(salt) == 0 {
+			break
+		}
+		salt = append([]byte(nil), salt...)
+		pass := a.pass
+		sharedKeys.prefetch(keyID15(pass, salt), func() [][]byte {
+			key, iv := calcAes30Params(pass, salt)
+			return [][]byte{key, iv}
+		})
+	}
+	return h.dataSize, nil
+}
+
+// prefetchHeader reads the next block header and starts deriving the keys
+// for the salt of a file encryption record. It returns the size of the
+// block data.
+func (a *archive50) prefetchHeader() (int64, error) {
+	h, err := a.readBlockHeader()
+	if err != nil {
+		return 0, err
+	}
+	switch h.htype {
+	case block5Encrypt:
+		err = a.parseEncryptionBlock(h.data)
+	case block5End:
+		return 0, errArchiveEnd
+	case block5File, block5Service:
+		for _, e := range h.extra {
+			if e.ftype != 1 || e.data.uvarint() != 0 {
+				continue
+			}
+			_ = e.data.uvarint() // flags
+			if len(e.data) < 17 || e.data[0] > maxKdfCount {
+				continue
+			}
+			kdfCount := 1 << uint(e.data.byte())
+			salt := append([]byte(nil), e.data.bytes(16)...)
+			pass := a.pass
+			sharedKeys.prefetch(keyID50(pass, salt, kdfCount), func() [][]byte {
+				return calcKeys50(pass, salt, kdfCount)
+			})
+		}
+	}
+	return h.dataSize, err
+}
@@ -0,0 +1,82 @@
+package rardecode
+
+import (
+	"container/list"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"unicode/utf16"
+)
+
+func newKeyCache(max int) *keyCache {
+	return &keyCache{max: max, m: make(map[string]*keyEntry), lru: list.New()}
+}
+
+func TestKeyCache(t *testing.T) {
+	c := newKeyCache(2)
+	var calls int32
+	calc := func(v byte) func() [][]byte {
+		return func() [][]byte {
+			atomic.AddInt32(&calls, 1)
+			return [][]byte{{v}}
+		}
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if k := c.get("a", calc(1)); k[0][0] != 1 {
+				t.Errorf("get a = %v", k)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Fatalf("concurrent gets derived the keys %d times", calls)
+	}
+
+	c.prefetch("b", calc(2))
+	if k := c.get("b", calc(9)); k[0][0] != 2 {
+		t.Fatalf("get b = %v, want prefetched keys", k)
+	}
+	c.get("a", calc(1)) // a is now the most recently used
+	c.get("c", calc(3))
+	if _, ok := c.m["b"]; ok || len(c.m) != 2 || c.lru.Len() != 2 {
+		t.Fatalf("cache holds %d entries, b evicted %v", len(c.m), !ok)
+	}
+}
+
+func TestPrefetchKeys(t *testing.T) {
+	dir := t.TempDir()
+	for _, c := range []struct {
+		pass string
+		arc  []byte
+		id   string
+	}{
+		{"prefetch3", encrypted15("prefetch3", fill(100, 1), false), keyID15(utf16.Encode([]rune("prefetch3")), []byte("saltsalt"))},
+		{"prefetch5", encrypted50("prefetch5", fill(100, 2)), keyID50([]byte("prefetch5"), []byte("0123456789abcdef"), 1)},
+	} {
+		name := filepath.Join(dir, "a.rar")
+		if err := ioutil.WriteFile(name, c.arc, 0644); err != nil {
+			t.Fatal(err)
+		}
+		prefetchKeys(name, "x", nil) // different password, nothing to find
+		sharedKeys.mu.Lock()
+		_, ok := sharedKeys.m[c.id]
+		sharedKeys.mu.Unlock()
+		if ok {
+			t.Fatal("keys cached before prefetch")
+		}
+		prefetchKeys(name, c.pass, nil)
+		sharedKeys.mu.Lock()
+		e, ok := sharedKeys.m[c.id]
+		sharedKeys.mu.Unlock()
+		if !ok {
+			t.Fatal("keys not prefetched")
+		}
+		<-e.done
+	}
+}
@@ -0,0 +1,181 @@
+package rardecode
+
+import (
+	"bytes"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// bitList holds bits MSB first, one per byte.
+type bitList []byte
+
+// bitsOf returns the n low bits of v.
+func bitsOf(v uint, n uint) bitList {
+	b := make(bitList, n)
+	for i := range b {
+		b[i] = byte(v>>(n-1-uint(i))) & 1
+	}
+	return b
+}
+
+func (b bitList) bytes() []byte {
+	p := make([]byte, (len(b)+7)/8)
+	for i, v := range b {
+		p[i/8] |= v << (7 - uint(i%8))
+	}
+	return p
+}
+
+// runBits runs a decoder operation op reading b, and checks that it used all
+// of b and wrote want to w.
+func runBits(t testing.TB, br *bitInput, w *window, b bitList, op func(), want []byte) {
+	t.Helper()
+	data := append(b.bytes(), make([]byte, 8)...)
+	r := bytes.NewReader(data)
+	br.reset(r)
+	start := w.w
+	op()
+	if used := (len(data)-r.Len())*8 - int(br.n); used != len(b) {
+		t.Fatalf("decoder used %d of %d bits", used, len(b))
+	}
+	got := make([]byte, (w.w-start)&w.mask)
+	for i := range got {
+		got[i] = w.buf[(start+i)&w.mask]
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("decoder wrote %x, want %x", got, want)
+	}
+	w.r = w.w
+}
+
+// legacyArchive returns a RAR 1.5 format archive holding files compressed
+// to packed with unpack version ver.
+func legacyArchive(ver byte, solid bool, files []testFile, packed [][]byte) []byte {
+	var arcFlags, flags uint16
+	if solid {
+		arcFlags = arcSolid
+	}
+	b := []byte(sigPrefix + "\x00")
+	b = append(b, block(blockArc, arcFlags, make([]byte, 6))...)
+	for i, f := range files {
+		b = append(b, fileBlock(f.name, flags, ver, 0x33, len(f.data), crc32.ChecksumIEEE(f.data), packed[i])...)
+		if solid {
+			flags = fileSolid
+		}
+	}
+	return append(b, block(blockEnd, 0, nil)...)
+}
+
+// legacyText returns n bytes of text with repeats at short and long
+// distances.
+func legacyText(n int, seed uint32) []byte {
+	words := []string{"archive ", "volume ", "header ", "block ", "window ", "filter ",
+		"decoder ", "the ", "of ", "and ", "data ", "is ", "a ", "RAR ", "1.5\n", "2.0\n"}
+	var b []byte
+	for len(b) < n {
+		seed = seed*1103515245 + 12345
+		b = append(b, words[seed>>16%uint32(len(words))]...)
+		if seed>>8&0xff < 3 {
+			b = append(b, byte(seed>>24))
+		}
+	}
+	return b[:n]
+}
+
+// legacyWave returns n bytes of interleaved samples for channels channels.
+func legacyWave(n, channels int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		ch := i % channels
+		x := i / channels
+		b[i] = byte(128 + (x*(ch+3))%61 - 30 + ch*7)
+	}
+	return b
+}
+
+// legacyArchives are the archives in testdata/legacy, which have to be made
+// by RAR 1.5x, 2.0x and 2.6x themselves, see the README there. Multi-volume
+// archives are listed by their first volume.
+var legacyArchives = []string{
+	"rar15.rar",
+	"rar15-solid.rar",
+	"rar15-multi.rar",
+	"rar20.rar",
+	"rar20-audio.rar",
+	"rar20-solid.rar",
+	"rar26-solid.rar",
+}
+
+// TestLegacyArchives reads every archive in legacyArchives and checks each
+// file against the CRC stored by RAR. It fails while any of them is missing:
+// the RAR 1.5 and 2.x decoders are only known to work once they have read
+// real RAR output.
+func TestLegacyArchives(t *testing.T) {
+	for _, name := range legacyArchives {
+		name = filepath.Join("testdata", "legacy", name)
+		r, err := OpenReader(name, "")
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		n := 0
+		for {
+			h, err := r.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Errorf("%s: %v", name, err)
+				break
+			}
+			if _, err = io.Copy(ioutil.Discard, r); err != nil {
+				t.Errorf("%s/%s: %v", name, h.Name, err)
+			}
+			n++
+		}
+		r.Close()
+		if n == 0 {
+			t.Errorf("%s: no files", name)
+		}
+	}
+}
+
+// TestMixedVersions reads an archive with files of different unpack versions,
+// as made by adding files to it with different RAR versions.
+func TestMixedVersions(t *testing.T) {
+	files := []testFile{
+		{"a.txt", legacyText(3000, 1)},
+		{"b.raw", legacyWave(2000, 2)},
+		{"c.txt", legacyText(2500, 2)},
+		{"d.txt", legacyText(500, 3)},
+	}
+	p15 := pack15(t, false, []testFile{files[0], files[2]})
+	p20 := pack20(t, false, files[1:2], [][]block20{{{2000, 2}}})
+	b := []byte(sigPrefix + "\x00")
+	b = append(b, block(blockArc, 0, make([]byte, 6))...)
+	for i, p := range []struct {
+		ver, method byte
+		data        []byte
+	}{{15, 0x33, p15[0]}, {20, 0x33, p20[0]}, {15, 0x33, p15[1]}, {29, 0x30, files[3].data}} {
+		f := files[i]
+		b = append(b, fileBlock(f.name, 0, p.ver, p.method, len(f.data), crc32.ChecksumIEEE(f.data), p.data)...)
+	}
+	b = append(b, block(blockEnd, 0, nil)...)
+
+	r, err := NewReader(bytes.NewReader(b), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range files {
+		h, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil || !bytes.Equal(got, want.data) {
+			t.Fatalf("%s: read %d of %d bytes, %v", h.Name, len(got), len(want.data), err)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package rardecode
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+var ErrNoPassword = errors.New("rardecode: none of the passwords is correct")
+
+// headersEncrypted reports whether the current volume's headers are encrypted.
+func headersEncrypted(fbr fileBlockReader) bool {
+	switch a := fbr.(type) {
+	case *archive15:
+		return a.encrypted
+	case *archive50:
+		return a.encrypted
+	}
+	return false
+}
+
+// scanEncryption reads the file headers of an archive without a password,
+// seeking past the packed data. It reports whether the headers are encrypted,
+// or else the index of the encrypted file to check passwords with, -1 if
+// there are none. That is the first one with password check data (RAR 5.0),
+// or else the one that needs the fewest bytes decoded, counting the solid
+// files before it, so that it can be decoded in full and its checksum
+// checked. If a later volume can't be read, the best file found so far is
+// used.
+func scanEncryption(name string) (headers bool, file int, err error) {
+	v, err := openVolume(name, "")
+	if err != nil {
+		return false, -1, err
+	}
+	defer v.Close()
+	best, bestCost := -1, int64(0)
+	var cost int64 // bytes decoded up to the end of the current file
+	for i := 0; ; {
+		h, err := v.next()
+		if headersEncrypted(v.fileBlockReader) {
+			return true, -1, nil
+		}
+		switch {
+		case err == errArchiveEnd || err == io.EOF:
+			return false, best, nil
+		case err == errBadPassword:
+			return false, i, nil // check data that doesn't match ""
+		case err != nil && best >= 0:
+			return false, best, nil
+		case err != nil:
+			return false, -1, err
+		case len(h.key) > 0 && h.checked:
+			return false, i, nil
+		}
+		if h.first {
+			size := h.UnPackedSize
+			if size < 0 {
+				size = 1 << 62
+			}
+			if h.solid {
+				cost += size
+			} else {
+				cost = size
+			}
+			if len(h.key) > 0 && (best < 0 || cost < bestCost) {
+				best, bestCost = i, cost
+			}
+			i++
+		}
+		if err = v.skip(h.Offset + h.PackedSize); err != nil {
+			if best >= 0 {
+				return false, best, nil
+			}
+			return false, -1, err
+		}
+	}
+}
+
+// passwordCheckSize is how much of an encrypted file without password check
+// data is decoded to check a password.
+const passwordCheckSize = 1 << 20
+
+// wrongPassword reports whether err from reading an encrypted archive means
+// the password is wrong: a failed password check, or data that was read in
+// full but decrypts to a bad checksum or to something the decoders reject.
+// Errors caused by missing data, as in a short or still downloading volume,
+// don't count.
+func wrongPassword(err error) bool {
+	for _, e := range []error{
+		errBadPassword, ErrBadFileChecksum, ErrHuffDecodeFailed, ErrInvalidLengthTable,
+		ErrCorruptPPM, ErrInvalidFilter, ErrTooManyFilters, ErrInvalidVMInstruction,
+		ErrUnknownFilter, ErrCorruptDecodeHeader,
+	} {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// tryPassword reports whether password opens the archive, and whether that
+// was verified. If the headers are encrypted, decrypting the first one is
+// enough. Otherwise the archive is read up to the file with index file,
+// which is accepted if its password check data matched. Without check data
+// (RAR 3.x) up to passwordCheckSize bytes of the file are decoded: it is
+// accepted if they decode without error, and verified if that is the whole
+// file and its checksum matches.
+func tryPassword(name, password string, headers bool, file int) (ok, verified bool, err error) {
+	rc, err := OpenReader(name, password)
+	if err != nil {
+		return false, false, err
+	}
+	defer rc.Close()
+	if headers {
+		_, err = rc.pr.next()
+		if err == nil || err == io.EOF {
+			return true, true, nil
+		}
+	} else {
+		for i := 0; i <= file; i++ {
+			if _, err = rc.Next(); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			if rc.pr.h.checked {
+				return true, true, nil
+			}
+			_, err = io.CopyN(ioutil.Discard, rc, passwordCheckSize)
+			switch err {
+			case io.EOF:
+				return true, true, nil
+			case nil:
+				return true, false, nil
+			}
+		}
+	}
+	if wrongPassword(err) {
+		return false, false, nil
+	}
+	return false, false, err
+}
+
+// FindPassword returns the first of passwords that opens the archive whose
+// first volume is name, or ErrNoPassword if none of them do. An archive that
+// isn't encrypted needs no password and "" is returned. If a candidate fails
+// with an error that doesn't show the password is wrong, such as a truncated
+// volume, the remaining ones are still tried and that error is returned if
+// none of them open the archive.
+//
+// verified reports whether the password was checked: with the password check
+// data of RAR 5.0, by decrypting an encrypted header, or by decoding a whole
+// file to its checksum. A RAR 3.x archive whose encrypted files are all
+// larger than passwordCheckSize is only decoded that far, which can't tell a
+// stored file's right password from a wrong one; the first password that
+// decodes without error is returned unverified.
+func FindPassword(name string, passwords []string) (password string, verified bool, err error) {
+	headers, file, err := scanEncryption(name)
+	if err != nil {
+		return "", false, err
+	}
+	if !headers && file < 0 {
+		return "", true, nil
+	}
+	var ferr error
+	for _, p := range passwords {
+		ok, verified, err := tryPassword(name, p, headers, file)
+		var pe *os.PathError
+		switch {
+		case ok:
+			return p, verified, nil
+		case errors.As(err, &pe):
+			return "", false, err
+		case err != nil && ferr == nil:
+			ferr = err
+		}
+	}
+	if ferr != nil {
+		return "", false, ferr
+	}
+	return "", false, ErrNoPassword
+}
@@ -0,0 +1,160 @@
+package rardecode
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"unicode/utf16"
+)
+
+// encryptCBC pads b with zeros to the AES block size and encrypts it.
+func encryptCBC(b, key, iv []byte) []byte {
+	p := make([]byte, (len(b)+15)&^15)
+	copy(p, b)
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+	cipher.NewCBCEncrypter(c, iv).CryptBlocks(p, p)
+	return p
+}
+
+// encrypted15 returns a RAR 3.x archive with one stored file. The file data
+// is encrypted with password, and so are the headers if headers is set.
+func encrypted15(password string, data []byte, headers bool) []byte {
+	return encryptedFiles15(password, headers, testFile{"data.bin", data})
+}
+
+// encryptedFiles15 returns a RAR 3.x archive with the stored files, encrypted
+// like encrypted15.
+func encryptedFiles15(password string, headers bool, files ...testFile) []byte {
+	salt := []byte("saltsalt")
+	key, iv := calcAes30Params(utf16.Encode([]rune(password)), salt)
+	var arcFlags uint16
+	if headers {
+		arcFlags = arcEncrypted
+	}
+	// header returns the block header of a block, encrypting it if needed
+	header := func(b []byte) []byte {
+		if !headers {
+			return b
+		}
+		return append(append([]byte(nil), salt...), encryptCBC(b, key, iv)...)
+	}
+	b := []byte(sigPrefix + "\x00")
+	b = append(b, block(blockArc, arcFlags, make([]byte, 6))...)
+	for _, file := range files {
+		packed := encryptCBC(file.data, key, iv)
+		f := make([]byte, 25, 64)
+		binary.LittleEndian.PutUint32(f, uint32(len(packed)))
+		binary.LittleEndian.PutUint32(f[4:], uint32(len(file.data)))
+		f[8] = HostOSWindows
+		binary.LittleEndian.PutUint32(f[9:], crc32.ChecksumIEEE(file.data))
+		f[17] = 29
+		f[18] = 0x30
+		binary.LittleEndian.PutUint16(f[19:], uint16(len(file.name)))
+		f = append(append(f, file.name...), salt...)
+		b = append(b, header(block(blockFile, blockHasData|fileEncrypted|fileSalt, f))...)
+		b = append(b, packed...)
+	}
+	return append(b, header(block(blockEnd, 0, nil))...)
+}
+
+// encrypted50 returns a RAR 5.0 archive with one stored file encrypted with
+// password, with password check data.
+func encrypted50(password string, data []byte) []byte {
+	salt := []byte("0123456789abcdef")
+	iv := []byte("fedcba9876543210")
+	keys := calcKeys50([]byte(password), salt, 1)
+	sum := sha256.Sum256(keys[2])
+	rec := vints(0, file5EncCheckPresent, 0)
+	rec = append(append(append(rec, salt...), iv...), keys[2]...)
+	rec = append(rec, sum[:4]...)
+
+	packed := encryptCBC(data, keys[0], iv)
+	b := []byte(sigPrefix + "\x01\x00")
+	b = append(b, block50(block5Arc, 0, vints(0), nil, 0)...)
+	fields := vints(file5HasCRC32, uint64(len(data)), 0o644)
+	fields = appendUint32(fields, crc32.ChecksumIEEE(data))
+	fields = append(fields, vints(0, 1)...) // method, host OS
+	fields = append(fields, str50("data.bin")...)
+	b = append(b, block50(block5File, 0, fields, record50(1, rec), len(packed))...)
+	b = append(b, packed...)
+	return append(b, block50(block5End, 0, vints(0), nil, 0)...)
+}
+
+func TestFindPassword(t *testing.T) {
+	data := legacyText(3000, 9)
+	list := []string{"", "123123", "gmw1024", "secret"}
+	for _, c := range []struct {
+		name string
+		arc  []byte
+		want string
+	}{
+		{"plain", storedVolumes([]testFile{{"data.bin", data}}, 0)[0], ""},
+		{"rar3 files", encrypted15("gmw1024", data, false), "gmw1024"},
+		{"rar3 headers", encrypted15("secret", data, true), "secret"},
+		{"rar5", encrypted50("123123", data), "123123"},
+	} {
+		name := filepath.Join(t.TempDir(), "a.rar")
+		if err := ioutil.WriteFile(name, c.arc, 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, verified, err := FindPassword(name, list)
+		if err != nil || got != c.want || !verified {
+			t.Errorf("%s: FindPassword = %q, %v, %v, want %q", c.name, got, verified, err, c.want)
+		}
+		if c.want == "" {
+			continue
+		}
+		if _, _, err = FindPassword(name, []string{"wrong", "other"}); err != ErrNoPassword {
+			t.Errorf("%s: FindPassword with wrong passwords = %v", c.name, err)
+		}
+
+		// a truncated file is reported as such, not as a wrong password,
+		// unless the password is checked without reading the file
+		if err = ioutil.WriteFile(name, c.arc[:len(c.arc)-200], 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, _, err = FindPassword(name, list)
+		if c.name == "rar3 files" {
+			if err == nil || err == ErrNoPassword {
+				t.Errorf("%s: FindPassword on a truncated volume = %q, %v", c.name, got, err)
+			}
+		} else if err != nil || got != c.want {
+			t.Errorf("%s: FindPassword on a truncated volume = %q, %v, want %q", c.name, got, err, c.want)
+		}
+	}
+}
+
+func TestFindPasswordSmallest(t *testing.T) {
+	big := testFile{"big.bin", legacyText(passwordCheckSize+100, 3)}
+	small := testFile{"small.txt", legacyText(300, 4)}
+	name := filepath.Join(t.TempDir(), "a.rar")
+	list := []string{"wrong", "gmw1024"}
+
+	// the small file is decoded in full, so the wrong password fails its checksum
+	if err := ioutil.WriteFile(name, encryptedFiles15("gmw1024", false, big, small), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, file, err := scanEncryption(name); file != 1 || err != nil {
+		t.Fatalf("scanEncryption = %d, %v, want file 1", file, err)
+	}
+	got, verified, err := FindPassword(name, list)
+	if err != nil || got != "gmw1024" || !verified {
+		t.Errorf("FindPassword = %q, %v, %v", got, verified, err)
+	}
+
+	// a stored file past passwordCheckSize can't be checked
+	if err = ioutil.WriteFile(name, encryptedFiles15("gmw1024", false, big), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, verified, err = FindPassword(name, list); err != nil || verified {
+		t.Errorf("FindPassword = %v, %v, want unverified", verified, err)
+	}
+}
@@ -21,15 +21,24 @@ const (
 	HostOSBeOS    = 6
 )
 
+// Redirection types stored in FileHeader.Redir
+const (
+	RedirUnixSymlink = 1 + iota
+	RedirWinSymlink
+	RedirWinJunction
+	RedirHardLink
+	RedirFileCopy
+)
+
 const (
 	maxPassword = 128
 )
 
 var (
-	errShortFile        = errors.New("rardecode: decoded file too short")
+	ErrShortFile        = errors.New("rardecode: decoded file too short")
 	errInvalidFileBlock = errors.New("rardecode: invalid file block")
 	errUnexpectedArcEnd = errors.New("rardecode: unexpected end of archive")
-	errBadFileChecksum  = errors.New("rardecode: bad file checksum")
+	ErrBadFileChecksum  = errors.New("rardecode: bad file checksum")
 )
 
 type byteReader interface {
@@ -86,10 +95,11 @@ func limitByteReader(r byteReader, n int64) *limitedByteReader {
 // fileChecksum allows file checksum validations to be performed.
 // File contents must first be written to fileChecksum. Then valid is
 // called to perform the file checksum calculation to determine
-// if the file contents are valid or not.
+// if the file contents are valid or not. Reset discards the data written.
 type fileChecksum interface {
 	io.Writer
 	valid() bool
+	Reset()
 }
 
 // FileHeader represents a single file in a RAR archive.
@@ -105,9 +115,14 @@ type FileHeader struct {
 	CreationTime     time.Time // creation time (non-zero if set)
 	AccessTime       time.Time // access time (non-zero if set)
 	Version          int       // file version
-
-	Offset  int64
-	VolName string
+	Redir            int       // redirection type, 0 if the file isn't a link
+	RedirTarget      string    // link target, or file to copy for RedirFileCopy
+	RedirDir         bool      // link target is a directory
+	User             string    // owner user name (empty if not set)
+	Group            string    // owner group name (empty if not set)
+
+	Offset  int64  // position of the packed data in VolName
+	VolName string // volume file containing the first block, empty if read with NewReader
 }
 
 // Mode returns an os.FileMode for the file, calculated from the Attributes field.
@@ -125,7 +140,7 @@ func (f *FileHeader) Mode() os.FileMode {
 		} else {
 			m |= 0666
 		}
-		return m
+		return m | f.redirMode()
 	}
 	// assume unix perms for all remaining os types
 	m |= os.FileMode(f.Attributes) & os.ModePerm
@@ -149,7 +164,16 @@ func (f *FileHeader) Mode() os.FileMode {
 	if f.Attributes&0xF000 == 0xA000 {
 		m |= os.ModeSymlink
 	}
-	return m
+	return m | f.redirMode()
+}
+
+// redirMode returns os.ModeSymlink for symbolic links and junctions.
+func (f *FileHeader) redirMode() os.FileMode {
+	switch f.Redir {
+	case RedirUnixSymlink, RedirWinSymlink, RedirWinJunction:
+		return os.ModeSymlink
+	}
+	return 0
 }
 
 // fileBlockHeader represents a file block in a RAR archive.
@@ -164,6 +188,7 @@ type fileBlockHeader struct {
 	decoder decoder      // decoder to use for file
 	key     []byte       // key for AES, non-empty if file encrypted
 	iv      []byte       // iv for AES, non-empty if file encrypted
+	checked bool         // password verified with the file's check data
 	FileHeader
 }
 
@@ -179,8 +204,19 @@ type fileBlockReader interface {
 
 // packedFileReader provides sequential access to packed files in a RAR archive.
 type packedFileReader struct {
-	r fileBlockReader
-	h *fileBlockHeader // current file header
+	r     fileBlockReader
+	h     *fileBlockHeader // current file header
+	spans []blockSpan      // packed data of the current file blocks read so far
+}
+
+// addSpan records the packed data position of the current file block.
+func (f *packedFileReader) addSpan() {
+	f.spans = append(f.spans, blockSpan{f.h.VolName, f.h.Offset, f.h.Offset + f.h.PackedSize})
+}
+
+// rangeErr wraps err with the packed data read for the current file.
+func (f *packedFileReader) rangeErr(err error) error {
+	return rangeErr(f.spans, err)
 }
 
 // nextBlockInFile reads the next file block in the current file at the current
@@ -199,6 +235,7 @@ func (f *packedFileReader) nextBlockInFile() error {
 		return errInvalidFileBlock
 	}
 	f.h = h
+	f.addSpan()
 	return nil
 }
 
@@ -221,6 +258,7 @@ func (f *packedFileReader) next() (*fileBlockHeader, error) {
 		}
 	}
 	var err error
+	f.spans = f.spans[:0]
 	f.h, err = f.r.next() // get next file block
 	if err != nil {
 		if err == errArchiveEnd {
@@ -231,6 +269,7 @@ func (f *packedFileReader) next() (*fileBlockHeader, error) {
 	if !f.h.first {
 		return nil, errInvalidFileBlock
 	}
+	f.addSpan()
 	return f.h, nil
 }
 
@@ -276,55 +315,61 @@ type Reader struct {
 func (r *Reader) Read(p []byte) (int, error) {
 	n, err := r.r.Read(p)
 	if err == io.EOF && r.cksum != nil && !r.cksum.valid() {
-		return n, errBadFileChecksum
+		return n, r.pr.rangeErr(ErrBadFileChecksum)
+	}
+	if err != nil && err != io.EOF {
+		err = r.pr.rangeErr(err)
 	}
 	return n, err
 }
 
 // Next advances to the next file in the archive.
-func (rc *ReadCloser) Next() (*FileHeader, error) {
-	if rc.solidr != nil {
+func (r *Reader) Next() (*FileHeader, error) {
+	if r.solidr != nil {
 		// solid files must be read fully to update decoder information
-		if _, err := io.Copy(ioutil.Discard, rc.solidr); err != nil {
-			return nil, err
+		if _, err := io.Copy(ioutil.Discard, r.solidr); err != nil {
+			return nil, r.pr.rangeErr(err)
 		}
 	}
 
-	h, err := rc.pr.next() // skip to next file
+	h, err := r.pr.next() // skip to next file
 	if err != nil {
 		return nil, err
 	}
-	h.Offset, _ = rc.v.f.Seek(0, io.SeekCurrent)
-	h.Offset -= int64(rc.v.br.Buffered())
-	//h.Offset = pos
-	h.VolName = rc.v.f.Name()
-	rc.solidr = nil
+	r.solidr = nil
 
-	br := byteReader(&rc.pr) // start with packed file reader
+	br := byteReader(&r.pr) // start with packed file reader
 
 	// check for encryption
 	if len(h.key) > 0 && len(h.iv) > 0 {
 		br = newAesDecryptReader(br, h.key, h.iv) // decrypt
 	}
-	rc.r = br
+	r.r = br
 	// check for compression
 	if h.decoder != nil {
-		err = rc.dr.init(br, h.decoder, h.winSize, !h.solid)
+		if d, ok := h.decoder.(sizedDecoder); ok {
+			if h.UnKnownSize {
+				d.setSize(-1)
+			} else {
+				d.setSize(h.UnPackedSize)
+			}
+		}
+		err = r.dr.init(br, h.decoder, h.winSize, !h.solid)
 		if err != nil {
-			return nil, err
+			return nil, r.pr.rangeErr(err)
 		}
-		rc.r = &rc.dr
-		if rc.pr.r.isSolid() {
-			rc.solidr = rc.r
+		r.r = &r.dr
+		if r.pr.r.isSolid() {
+			r.solidr = r.r
 		}
 	}
 	if h.UnPackedSize >= 0 && !h.UnKnownSize {
 		// Limit reading to UnPackedSize as there may be padding
-		rc.r = &limitedReader{rc.r, h.UnPackedSize, errShortFile}
+		r.r = &limitedReader{r.r, h.UnPackedSize, ErrShortFile}
 	}
-	rc.cksum = h.cksum
-	if rc.cksum != nil {
-		rc.r = io.TeeReader(rc.r, h.cksum) // write file data to checksum as it is read
+	r.cksum = h.cksum
+	if r.cksum != nil {
+		r.r = io.TeeReader(r.r, h.cksum) // write file data to checksum as it is read
 	}
 	fh := new(FileHeader)
 	*fh = h.FileHeader
@@ -344,15 +389,55 @@ func NewReader(r io.Reader, password string) (*Reader, error) {
 	if !ok {
 		br = bufio.NewReader(r)
 	}
-	fbr, err := newFileBlockReader(br, password)
+	ver, n, err := findSig(br)
+	if err != nil {
+		return nil, err
+	}
+	cr := &countReader{r: br, n: int64(n)}
+	fbr, err := newArchive(cr, ver, password)
 	if err != nil {
 		return nil, err
 	}
 	rr := new(Reader)
-	rr.init(fbr)
+	rr.init(&streamReader{fbr, cr})
 	return rr, nil
 }
 
+// countReader counts the bytes read from r.
+type countReader struct {
+	r byteReader
+	n int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+// streamReader records the stream position of file blocks for archives
+// that aren't read from a volume file.
+type streamReader struct {
+	fileBlockReader
+	cr *countReader
+}
+
+func (s *streamReader) next() (*fileBlockHeader, error) {
+	h, err := s.fileBlockReader.next()
+	if h != nil {
+		h.Offset = s.cr.n
+	}
+	return h, err
+}
+
 type ReadCloser struct {
 	v *volume
 	Reader
@@ -381,3 +466,15 @@ func OpenReader(name, password string) (*ReadCloser, error) {
 	rc.Reader.init(v)
 	return rc, nil
 }
+
+// OpenReaderFunc is like OpenReader but opens the volume files with open.
+func OpenReaderFunc(name, password string, open OpenFunc) (*ReadCloser, error) {
+	v, err := openVolumeFunc(name, password, open)
+	if err != nil {
+		return nil, err
+	}
+	rc := new(ReadCloser)
+	rc.v = v
+	rc.Reader.init(v)
+	return rc, nil
+}
@@ -0,0 +1,273 @@
+package rardecode
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// block returns a RAR 1.5 block with a 16 bit header CRC.
+func block(typ byte, flags uint16, body []byte) []byte {
+	h := make([]byte, 7, 7+len(body))
+	h[2] = typ
+	binary.LittleEndian.PutUint16(h[3:], flags)
+	binary.LittleEndian.PutUint16(h[5:], uint16(7+len(body)))
+	h = append(h, body...)
+	binary.LittleEndian.PutUint16(h, uint16(crc32.ChecksumIEEE(h[2:])))
+	return h
+}
+
+// storedBlock returns a file block holding data without compression.
+// crc and size are for the whole file.
+func storedBlock(name string, flags uint16, size int, crc uint32, data []byte) []byte {
+	return fileBlock(name, flags, 29, 0x30, size, crc, data)
+}
+
+// fileBlock returns a file block holding packed data written by unpack
+// version ver with the given method.
+func fileBlock(name string, flags uint16, ver, method byte, size int, crc uint32, data []byte) []byte {
+	b := make([]byte, 25, 25+len(name))
+	binary.LittleEndian.PutUint32(b, uint32(len(data)))
+	binary.LittleEndian.PutUint32(b[4:], uint32(size))
+	b[8] = HostOSWindows
+	binary.LittleEndian.PutUint32(b[9:], crc)
+	b[17] = ver
+	b[18] = method
+	binary.LittleEndian.PutUint16(b[19:], uint16(len(name)))
+	b = append(b, name...)
+	return append(block(blockFile, flags|0x8000, b), data...)
+}
+
+type testFile struct {
+	name string
+	data []byte
+}
+
+// storedVolumes builds a stored RAR 1.5 archive split into volumes holding
+// at most cut bytes of file data each. A cut of 0 builds a single volume.
+func storedVolumes(files []testFile, cut int) [][]byte {
+	var vols [][]byte
+	cur := new(bytes.Buffer)
+	room := cut
+	multi := cut > 0
+	start := func() {
+		cur.Reset()
+		cur.WriteString(sigPrefix + "\x00")
+		var flags uint16
+		if multi {
+			flags = 0x0001 | 0x0010 // volume, new naming
+			if len(vols) == 0 {
+				flags |= 0x0100 // first volume
+			}
+		}
+		cur.Write(block(blockArc, flags, make([]byte, 6)))
+		room = cut
+	}
+	finish := func(last bool) {
+		var flags uint16
+		if !last {
+			flags = 0x0001 // archive continues
+		}
+		cur.Write(block(blockEnd, flags, nil))
+		vols = append(vols, append([]byte(nil), cur.Bytes()...))
+	}
+	start()
+	for _, f := range files {
+		crc := crc32.ChecksumIEEE(f.data)
+		data := f.data
+		var flags uint16
+		for {
+			if multi && room == 0 {
+				finish(false)
+				start()
+			}
+			n := len(data)
+			if multi && n > room {
+				n = room
+			}
+			fl := flags
+			if n < len(data) {
+				fl |= 0x0002 // continues in next volume
+			}
+			cur.Write(storedBlock(f.name, fl, len(f.data), crc, data[:n]))
+			room -= n
+			data = data[n:]
+			flags = 0x0001 // continued from previous volume
+			if len(data) == 0 {
+				break
+			}
+		}
+	}
+	finish(true)
+	return vols
+}
+
+// writeVolumes writes vols to dir as name.partN.rar and returns their paths.
+func writeVolumes(t *testing.T, dir string, vols [][]byte) []string {
+	var names []string
+	for i, v := range vols {
+		name := filepath.Join(dir, "a.part"+strconv.Itoa(i+1)+".rar")
+		if err := ioutil.WriteFile(name, v, 0644); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func fill(n int, c byte) []byte {
+	return bytes.Repeat([]byte{c}, n)
+}
+
+func TestReadStored(t *testing.T) {
+	files := []testFile{{"one.bin", fill(300, 0x11)}, {"two.bin", fill(500, 0x22)}}
+	vols := storedVolumes(files, 0)
+	arc := append([]byte("MZ self extracting stub"), vols[0]...)
+	// a *bufio.Reader is used as is, offsets are counted on it
+	for _, in := range []io.Reader{bytes.NewReader(arc), bufio.NewReaderSize(bytes.NewReader(arc), 64)} {
+		r, err := NewReader(in, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range files {
+			h, err := r.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h.Name != f.name || h.Offset != int64(bytes.Index(arc, f.data)) || h.VolName != "" {
+				t.Errorf("%T: header %s at %s:%d", in, h.Name, h.VolName, h.Offset)
+			}
+			b, err := ioutil.ReadAll(r)
+			if err != nil || !bytes.Equal(b, f.data) {
+				t.Fatalf("%s: %d bytes, %v", f.name, len(b), err)
+			}
+		}
+		if _, err = r.Next(); err != io.EOF {
+			t.Fatalf("Next = %v, want EOF", err)
+		}
+	}
+}
+
+type memFile struct {
+	*bytes.Reader
+}
+
+func (memFile) Close() error { return nil }
+
+func TestOpenReaderFunc(t *testing.T) {
+	files := []testFile{{"one.bin", fill(300, 0x11)}, {"two.bin", fill(500, 0x22)}}
+	vols := storedVolumes(files, 200)
+	var opened []string
+	open := func(name string) (VolumeFile, error) {
+		opened = append(opened, name)
+		for i, v := range vols {
+			if name == filepath.Join("dl", "a.part"+strconv.Itoa(i+1)+".rar") {
+				return memFile{bytes.NewReader(v)}, nil
+			}
+		}
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	rc, err := OpenReaderFunc(filepath.Join("dl", "a.part1.rar"), "", open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	for _, f := range files {
+		h, err := rc.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(rc)
+		if err != nil || h.Name != f.name || !bytes.Equal(b, f.data) {
+			t.Fatalf("%s: %d bytes, %v", h.Name, len(b), err)
+		}
+	}
+	if _, err = rc.Next(); err != io.EOF {
+		t.Fatalf("Next = %v, want EOF", err)
+	}
+	if len(opened) != len(vols) {
+		t.Errorf("opened %v, want %d volumes", opened, len(vols))
+	}
+}
+
+func TestErrRange(t *testing.T) {
+	dir := t.TempDir()
+	vols := storedVolumes([]testFile{
+		{"one.bin", fill(300, 0x11)},
+		{"two.bin", fill(500, 0x22)}, // stored in both volumes
+		{"three.bin", fill(100, 0x33)},
+	}, 600)
+	if len(vols) != 2 {
+		t.Fatalf("%d volumes", len(vols))
+	}
+	i := bytes.IndexByte(vols[1], 0x22)
+	vols[1][i+10] ^= 0xff
+	names := writeVolumes(t, dir, vols)
+
+	rc, err := OpenReader(names[0], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	var got error
+	for {
+		h, err := rc.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		_, err = io.Copy(ioutil.Discard, rc)
+		if h.Name == "two.bin" {
+			got = err
+			if h.VolName != names[0] || h.Offset != int64(bytes.Index(vols[0], fill(300, 0x22))) {
+				t.Errorf("two.bin header at %s:%d", h.VolName, h.Offset)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %v", h.Name, err)
+		}
+	}
+	if !errors.Is(got, ErrBadFileChecksum) {
+		t.Fatalf("got %v, want bad checksum", got)
+	}
+	var re *ErrRange
+	if !errors.As(got, &re) {
+		t.Fatalf("%T is not an ErrRange", got)
+	}
+	rs := re.Ranges()
+	if len(rs) != 2 {
+		t.Fatalf("ranges %v", got)
+	}
+	if rs[0].File != names[0] || rs[0].Start != int64(bytes.Index(vols[0], fill(300, 0x22))) ||
+		rs[0].End != rs[0].Start+300 {
+		t.Errorf("first range %s:%d-%d", rs[0].File, rs[0].Start, rs[0].End)
+	}
+	if rs[1].File != names[1] || rs[1].Start != int64(i) || rs[1].End != int64(i+200) {
+		t.Errorf("second range %s:%d-%d, data at %d", rs[1].File, rs[1].Start, rs[1].End, i)
+	}
+}
+
+func TestRangeErrMerge(t *testing.T) {
+	err := rangeErr([]blockSpan{{"a", 10, 20}, {"a", 20, 30}, {"b", 0, 5}}, ErrShortFile)
+	var re *ErrRange
+	if !errors.As(err, &re) {
+		t.Fatal(err)
+	}
+	if rs := re.Ranges(); len(rs) != 2 || rs[0].Start != 10 || rs[0].End != 30 || rs[1].File != "b" {
+		t.Errorf("got %v", err)
+	}
+	if want := "rardecode: decoded file too short (packed data a:10-30, b:0-5)"; err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+	if rangeErr(nil, ErrShortFile) != ErrShortFile || rangeErr([]blockSpan{{"a", 0, 1}}, err) != err {
+		t.Error("rangeErr should return err unchanged")
+	}
+}
@@ -0,0 +1,409 @@
+package rardecode
+
+import (
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+const (
+	blockProtect = 0x78 // RAR 2.x recovery record block
+
+	rrSectorSize = 512 // bytes protected by each recovery record sector
+	rrMark       = "Protect+"
+)
+
+var (
+	ErrNoRecoveryRecord    = errors.New("rardecode: volume has no recovery record")
+	ErrRecoveryUnsupported = errors.New("rardecode: recovery record format not supported")
+	ErrTooDamaged          = errors.New("rardecode: damage exceeds what the recovery data can repair")
+)
+
+// RecoveryRecord describes the recovery record stored in a volume.
+//
+// RAR 2.x and 3.x volumes store it as a protect block or an "RR" service
+// block. The record holds a 16 bit checksum (the low 16 bits of CRC32, as
+// RAR uses for block headers) of every 512 byte sector of the volume that
+// precedes it, followed by Sectors parity sectors. Parity sector g is the
+// XOR of every sector i with i%Sectors == g, the last sector zero padded.
+//
+// RAR 5.0 volumes store a Reed-Solomon record which is located but not
+// decoded, as its layout is only known to WinRAR's repair command; Repair
+// returns ErrRecoveryUnsupported for them. RAR 5.0 volume sets are repaired
+// from recovery volumes with RebuildVolumes instead.
+type RecoveryRecord struct {
+	Volume    string // volume file name
+	Version   int    // 3 for the RAR 1.5 file format, 5 for the RAR 5.0 format
+	Sectors   int    // number of parity sectors
+	Blocks    int64  // number of protected sectors
+	Protected int64  // bytes of the volume covered by the record
+	Offset    int64  // position of the recovery data in the volume
+	Size      int64  // size of the recovery data
+}
+
+// serviceName50 returns the name of a RAR 5.0 format service block.
+func serviceName50(h *blockHeader50) (string, error) {
+	b := h.data
+	flags := b.uvarint()
+	_ = b.uvarint() // unpacked size
+	_ = b.uvarint() // attributes
+	if flags&file5HasUnixMtime > 0 {
+		if len(b) < 4 {
+			return "", errCorruptFileHeader
+		}
+		_ = b.uint32()
+	}
+	if flags&file5HasCRC32 > 0 {
+		if len(b) < 4 {
+			return "", errCorruptFileHeader
+		}
+		_ = b.uint32()
+	}
+	_ = b.uvarint() // compression flags
+	_ = b.uvarint() // host OS
+	n := int(b.uvarint())
+	if len(b) < n {
+		return "", errCorruptFileHeader
+	}
+	return string(b.bytes(n)), nil
+}
+
+// ReadRecoveryRecord returns the recovery record of the volume name. It
+// returns ErrNoRecoveryRecord if the volume doesn't have one.
+func ReadRecoveryRecord(name, password string) (*RecoveryRecord, error) {
+	s, err := openBlockScanner(name, password)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	rr := &RecoveryRecord{Volume: name}
+	for {
+		start, err := s.pos()
+		if err != nil {
+			return nil, err
+		}
+		var size int64
+		var found bool
+		switch a := s.fbr.(type) {
+		case *archive15:
+			rr.Version = 3
+			size, found, err = a.readRecoveryHeader(rr)
+		case *archive50:
+			rr.Version = 5
+			size, found, err = a.readRecoveryHeader()
+		}
+		if err != nil {
+			if err == io.EOF || err == errArchiveEnd {
+				err = ErrNoRecoveryRecord
+			}
+			return nil, err
+		}
+		if found {
+			rr.Protected = start
+			if rr.Version == 3 && rr.Blocks*rrSectorSize < start {
+				rr.Protected = rr.Blocks * rrSectorSize
+			}
+			rr.Offset, err = s.pos()
+			rr.Size = size
+			return rr, err
+		}
+		if err = s.skip(size); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readRecoveryHeader reads the next block header, filling in rr if it is a
+// recovery record. It returns the size of the block data.
+func (a *archive15) readRecoveryHeader(rr *RecoveryRecord) (int64, bool, error) {
+	h, err := a.readBlockHeader()
+	if err != nil {
+		return 0, false, err
+	}
+	switch h.htype {
+	case blockArc:
+		a.encrypted = h.flags&arcEncrypted > 0
+	case blockEnd:
+		return 0, false, errArchiveEnd
+	case blockProtect:
+		if len(h.data) < 7 {
+			return 0, false, errCorruptHeader
+		}
+		_ = h.data.byte() // version
+		rr.Sectors = int(h.data.uint16())
+		rr.Blocks = int64(h.data.uint32())
+		return h.dataSize, true, nil
+	case blockService:
+		name, _, b, err := fileNameSalt15(h)
+		if err != nil || name != "RR" {
+			return h.dataSize, false, err
+		}
+		if len(b) < len(rrMark)+4 || string(b[:len(rrMark)]) != rrMark {
+			return 0, false, errCorruptHeader
+		}
+		b = b[len(rrMark):]
+		rr.Sectors = int(b.uint32())
+		if len(b) >= 8 {
+			rr.Blocks = int64(b.uint64())
+		} else {
+			rr.Blocks = (h.dataSize - int64(rr.Sectors)*rrSectorSize) / 2
+		}
+		return h.dataSize, true, nil
+	}
+	return h.dataSize, false, nil
+}
+
+// readRecoveryHeader reads the next block header, reporting whether it is
+// a recovery record. It returns the size of the block data.
+func (a *archive50) readRecoveryHeader() (int64, bool, error) {
+	h, err := a.readBlockHeader()
+	if err != nil {
+		return 0, false, err
+	}
+	switch h.htype {
+	case block5Encrypt:
+		err = a.parseEncryptionBlock(h.data)
+	case block5End:
+		return 0, false, errArchiveEnd
+	case block5Service:
+		var name string
+		name, err = serviceName50(h)
+		if err == nil && name == "RR" {
+			return h.dataSize, true, nil
+		}
+	}
+	return h.dataSize, false, err
+}
+
+// sectorSum returns the checksum stored for a sector.
+func sectorSum(p []byte) uint16 {
+	return uint16(crc32.ChecksumIEEE(p))
+}
+
+// readSector reads sector i of a volume into p, zero padding past protected.
+func readSector(f io.ReaderAt, p []byte, i, protected int64) error {
+	off := i * rrSectorSize
+	n := int64(len(p))
+	if off+n > protected {
+		n = protected - off
+	}
+	for j := n; j < int64(len(p)); j++ {
+		p[j] = 0
+	}
+	_, err := f.ReadAt(p[:n], off)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// damagedSectors returns the protected sectors of f that don't match the
+// checksums sums.
+func (rr *RecoveryRecord) damagedSectors(f io.ReaderAt, sums []byte) ([]int64, error) {
+	nsec := (rr.Protected + rrSectorSize - 1) / rrSectorSize
+	buf := make([]byte, rrSectorSize)
+	var bad []int64
+	for i := int64(0); i < nsec; i++ {
+		if err := readSector(f, buf, i, rr.Protected); err != nil {
+			return nil, err
+		}
+		b := readBuf(sums[i*2:])
+		if sectorSum(buf) != b.uint16() {
+			bad = append(bad, i)
+		}
+	}
+	return bad, nil
+}
+
+// Repair checks every protected sector of the volume against the recovery
+// record and rebuilds the damaged ones. The repaired volume is written to a
+// copy, which replaces the original only once every sector matches its
+// checksum and the block header CRCs check out; the original is left as it
+// was otherwise. It returns the number of sectors repaired, and ErrTooDamaged
+// if the damage can't be repaired.
+func (rr *RecoveryRecord) Repair() (int, error) {
+	if rr.Version != 3 {
+		return 0, ErrRecoveryUnsupported
+	}
+	if rr.Sectors <= 0 || rr.Size < rr.Blocks*2+int64(rr.Sectors)*rrSectorSize {
+		return 0, errCorruptHeader
+	}
+	if (rr.Protected+rrSectorSize-1)/rrSectorSize > rr.Blocks {
+		return 0, errCorruptHeader
+	}
+	f, err := os.Open(rr.Volume)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	sums := make([]byte, rr.Blocks*2)
+	if _, err = f.ReadAt(sums, rr.Offset); err != nil {
+		return 0, err
+	}
+	nsec := (rr.Protected + rrSectorSize - 1) / rrSectorSize
+	sectors := int64(rr.Sectors)
+
+	// find the damaged sectors, allowing one per parity group
+	damaged, err := rr.damagedSectors(f, sums)
+	if err != nil || len(damaged) == 0 {
+		return 0, err
+	}
+	bad := map[int64]int64{} // parity group => damaged sector
+	for _, i := range damaged {
+		if _, ok := bad[i%sectors]; ok {
+			return 0, ErrTooDamaged
+		}
+		bad[i%sectors] = i
+	}
+
+	// rebuild each damaged sector from its parity sector and the others in its group
+	buf := make([]byte, rrSectorSize)
+	fixed := map[int64][]byte{}
+	for g := range bad {
+		p := make([]byte, rrSectorSize)
+		if _, err = f.ReadAt(p, rr.Offset+rr.Blocks*2+g*rrSectorSize); err != nil {
+			return 0, err
+		}
+		fixed[g] = p
+	}
+	for i := int64(0); i < nsec; i++ {
+		g := i % sectors
+		p, ok := fixed[g]
+		if !ok || bad[g] == i {
+			continue
+		}
+		if err = readSector(f, buf, i, rr.Protected); err != nil {
+			return 0, err
+		}
+		for j := range p {
+			p[j] ^= buf[j]
+		}
+	}
+	for g, i := range bad {
+		p := fixed[g]
+		b := readBuf(sums[i*2:])
+		if sectorSum(p) != b.uint16() {
+			return 0, ErrTooDamaged
+		}
+		if n := rr.Protected - i*rrSectorSize; n < rrSectorSize {
+			if !bytes.Equal(p[n:], make([]byte, rrSectorSize-n)) {
+				return 0, ErrTooDamaged
+			}
+			fixed[g] = p[:n]
+		}
+	}
+
+	// write the repaired sectors to a copy of the volume and check it
+	tmp := rr.Volume + ".tmp"
+	if err = copyFile(tmp, f); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	w, err := os.OpenFile(tmp, os.O_RDWR, 0)
+	if err == nil {
+		for g, i := range bad {
+			if _, err = w.WriteAt(fixed[g], i*rrSectorSize); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = w.Sync()
+		}
+		if err == nil {
+			damaged, err = rr.damagedSectors(w, sums)
+			if err == nil && len(damaged) > 0 {
+				err = ErrTooDamaged
+			}
+		}
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err == nil {
+		err = replaceVolume(tmp, rr.Volume, checkHeaders)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return len(bad), nil
+}
+
+// copyFile copies the contents of src to a new file name with the
+// permissions of src.
+func copyFile(name string, src *os.File) error {
+	fi, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, io.NewSectionReader(src, 0, fi.Size()))
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// replaceVolume renames the repaired copy tmp over the volume name once
+// check accepts it. tmp is removed otherwise.
+func replaceVolume(tmp, name string, check func(string) error) error {
+	err := check(tmp)
+	if err == nil {
+		err = os.Rename(tmp, name)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// checkHeaders reads the block headers of the volume name up to its end
+// block, which checks their CRCs, and returns ErrTooDamaged if one is
+// damaged. Volumes with encrypted headers are checked up to the encryption.
+func checkHeaders(name string) error {
+	s, err := openBlockScanner(name, "")
+	if err != nil {
+		if _, ok := err.(*os.PathError); ok {
+			return err
+		}
+		return ErrTooDamaged
+	}
+	defer s.Close()
+	for {
+		var size int64
+		var done bool
+		switch a := s.fbr.(type) {
+		case *archive15:
+			var h *blockHeader15
+			if h, err = a.readBlockHeader(); err == nil {
+				size = h.dataSize
+				done = h.htype == blockEnd || h.htype == blockArc && h.flags&arcEncrypted > 0
+			}
+		case *archive50:
+			var h *blockHeader50
+			if h, err = a.readBlockHeader(); err == nil {
+				size = h.dataSize
+				done = h.htype == block5End || h.htype == block5Encrypt
+			}
+		}
+		switch {
+		case err == io.EOF:
+			return nil // RAR 1.5 volumes may end without an end block
+		case err != nil:
+			return ErrTooDamaged
+		case done:
+			return nil
+		}
+		if err = s.skip(size); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package rardecode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// withRecoveryRecord inserts a recovery record with the given number of
+// parity sectors before the end block of a stored volume. protect selects
+// the RAR 2.x protect block instead of an "RR" service block.
+func withRecoveryRecord(vol []byte, sectors int, protect bool) []byte {
+	end := block(blockEnd, 0, nil)
+	body := vol[:len(vol)-len(end)]
+	nsec := (len(body) + rrSectorSize - 1) / rrSectorSize
+	sums := make([]byte, nsec*2)
+	parity := make([]byte, sectors*rrSectorSize)
+	for i := 0; i < nsec; i++ {
+		s := make([]byte, rrSectorSize)
+		copy(s, body[i*rrSectorSize:])
+		binary.LittleEndian.PutUint16(sums[i*2:], uint16(crc32.ChecksumIEEE(s)))
+		p := parity[i%sectors*rrSectorSize:]
+		for j, v := range s {
+			p[j] ^= v
+		}
+	}
+	data := append(sums, parity...)
+	var h []byte
+	if protect {
+		b := make([]byte, 19)
+		binary.LittleEndian.PutUint32(b, uint32(len(data)))
+		b[4] = 20
+		binary.LittleEndian.PutUint16(b[5:], uint16(sectors))
+		binary.LittleEndian.PutUint32(b[7:], uint32(nsec))
+		copy(b[11:], "Protect!")
+		h = block(blockProtect, blockHasData, b)
+	} else {
+		b := make([]byte, 25, 64)
+		binary.LittleEndian.PutUint32(b, uint32(len(data)))
+		b[17] = 29
+		b[18] = 0x30
+		binary.LittleEndian.PutUint16(b[19:], 2)
+		b = append(b, "RR"+rrMark...)
+		b = appendUint32(b, uint32(sectors))
+		h = block(blockService, blockHasData, b)
+	}
+	out := append(append([]byte(nil), body...), h...)
+	out = append(out, data...)
+	return append(out, end...)
+}
+
+func TestRecoveryRecord(t *testing.T) {
+	files := []testFile{{"text.txt", legacyText(5000, 7)}, {"zero.bin", fill(1500, 0)}}
+	vol := storedVolumes(files, 0)[0]
+	for _, protect := range []bool{false, true} {
+		arc := withRecoveryRecord(vol, 4, protect)
+		name := filepath.Join(t.TempDir(), "a.rar")
+		if err := ioutil.WriteFile(name, arc, 0644); err != nil {
+			t.Fatal(err)
+		}
+		rr, err := ReadRecoveryRecord(name, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rr.Version != 3 || rr.Sectors != 4 || rr.Protected != int64(len(vol)-7) {
+			t.Fatalf("record %+v", rr)
+		}
+		if n, err := rr.Repair(); n != 0 || err != nil {
+			t.Fatalf("Repair undamaged = %d, %v", n, err)
+		}
+
+		// damage one sector in each of three parity groups
+		bad := append([]byte(nil), arc...)
+		last := int(rr.Protected) - 3
+		g := last / rrSectorSize
+		for _, off := range []int{(g+1)%4*rrSectorSize + 100, (g+2)%4*rrSectorSize + 7, last} {
+			bad[off] ^= 0xff
+		}
+		if err = ioutil.WriteFile(name, bad, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if n, err := rr.Repair(); n != 3 || err != nil {
+			t.Fatalf("Repair = %d, %v", n, err)
+		}
+		got, err := ioutil.ReadFile(name)
+		if err != nil || !bytes.Equal(got, arc) {
+			t.Fatalf("repaired volume differs, %v", err)
+		}
+
+		// two damaged sectors in the same group can't be rebuilt
+		bad[10] ^= 0xff
+		bad[512*4+10] ^= 0xff
+		if err = ioutil.WriteFile(name, bad, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := rr.Repair(); !errors.Is(err, ErrTooDamaged) {
+			t.Fatalf("Repair = %v, want ErrTooDamaged", err)
+		}
+		got, err = ioutil.ReadFile(name)
+		if err != nil || !bytes.Equal(got, bad) {
+			t.Fatalf("failed repair changed the volume, %v", err)
+		}
+		if _, err = os.Stat(name + ".tmp"); !os.IsNotExist(err) {
+			t.Fatalf("copy left behind, %v", err)
+		}
+	}
+
+	name := filepath.Join(t.TempDir(), "plain.rar")
+	if err := ioutil.WriteFile(name, vol, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadRecoveryRecord(name, ""); err != ErrNoRecoveryRecord {
+		t.Fatalf("ReadRecoveryRecord = %v, want ErrNoRecoveryRecord", err)
+	}
+}
+
+func TestCheckHeaders(t *testing.T) {
+	// made by rar 5, from github.com/gabriel-vasile/mimetype (MIT license)
+	arc, err := ioutil.ReadFile(filepath.Join("testdata", "rar5.rar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vol := storedVolumes([]testFile{{"text.txt", legacyText(2000, 3)}}, 0)[0]
+	for _, b := range [][]byte{arc, vol} {
+		name := filepath.Join(t.TempDir(), "a.rar")
+		if err = ioutil.WriteFile(name, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err = checkHeaders(name); err != nil {
+			t.Fatalf("checkHeaders = %v", err)
+		}
+		bad := append([]byte(nil), b...)
+		bad[len(bad)-3] ^= 0xff // end block header
+		if err = ioutil.WriteFile(name, bad, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err = checkHeaders(name); err != ErrTooDamaged {
+			t.Fatalf("checkHeaders = %v, want ErrTooDamaged", err)
+		}
+	}
+}
@@ -0,0 +1,427 @@
+package rardecode
+
+import (
+	"bufio"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	revTailSize  = 7       // counts and CRC32 at the end of a .rev file
+	revChunkSize = 1 << 16 // bytes of each volume processed at a time
+)
+
+var ErrNoRecoveryVolumes = errors.New("rardecode: no recovery volumes found")
+
+// GF(2^8) tables for the polynomial x^8+x^4+x^3+x^2+1 with generator 2.
+var (
+	gfExp [2 * 255]byte
+	gfLog [256]int
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfExp[i+255] = byte(x)
+		gfLog[x] = i
+		x <<= 1
+		if x > 0xff {
+			x ^= 0x11d
+		}
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[gfLog[a]+gfLog[b]]
+}
+
+func gfInv(a byte) byte {
+	return gfExp[255-gfLog[a]]
+}
+
+// gfPow returns 2^n.
+func gfPow(n int) byte {
+	return gfExp[n%255]
+}
+
+// revVolume is a RAR 3.x recovery volume.
+type revVolume struct {
+	name string
+	num  int   // recovery volume number, from 0
+	size int64 // bytes of parity data
+}
+
+// readRevTail checks the CRC of a .rev file and returns its recovery volume
+// number, number of recovery volumes and number of data volumes.
+//
+// The file ends in 7 bytes: the number of data volumes, the number of
+// recovery volumes and this volume's number, each stored minus one, then the
+// CRC32 of everything before the CRC.
+func readRevTail(name string) (num, recs, files int, size int64, err error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+	size = fi.Size() - revTailSize
+	if size < 0 {
+		return 0, 0, 0, 0, errCorruptHeader
+	}
+	h := crc32.NewIEEE()
+	if _, err = io.Copy(h, io.LimitReader(f, fi.Size()-4)); err != nil {
+		return 0, 0, 0, 0, err
+	}
+	tail := make(readBuf, revTailSize)
+	if _, err = f.ReadAt(tail, size); err != nil {
+		return 0, 0, 0, 0, err
+	}
+	files = int(tail.byte()) + 1
+	recs = int(tail.byte()) + 1
+	num = int(tail.byte())
+	if tail.uint32() != h.Sum32() || files+recs > 255 || num >= recs {
+		return 0, 0, 0, 0, errBadHeaderCrc
+	}
+	return num, recs, files, size, nil
+}
+
+// revVolumeNames returns the names of the .rev files in the directory of set
+// that have the set name of set, name.part2.rev like the volume name.part2.rar.
+func revVolumeNames(set string) ([]string, error) {
+	dir, base := filepath.Split(set)
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		name := e.Name()
+		ext := filepath.Ext(name)
+		if e.IsDir() || !strings.EqualFold(ext, ".rev") {
+			continue
+		}
+		if s, _, ok := VolumeInfo(strings.TrimSuffix(name, ext) + ".rar"); ok && s == base {
+			names = append(names, filepath.Join(filepath.Dir(set), name))
+		}
+	}
+	return names, nil
+}
+
+// findRevVolumes returns the valid RAR 3.x recovery volumes among names, and
+// the number of recovery and data volumes they were made for.
+func findRevVolumes(names []string) ([]revVolume, int, int, error) {
+	var revs []revVolume
+	var recs, files int
+	var size int64
+	for _, name := range names {
+		num, r, n, sz, err := readRevTail(name)
+		if err != nil {
+			continue // damaged recovery volumes are treated as missing
+		}
+		if len(revs) == 0 {
+			recs, files, size = r, n, sz
+		} else if r != recs || n != files || sz != size {
+			continue // belongs to another set
+		}
+		revs = append(revs, revVolume{name, num, sz})
+	}
+	if len(revs) == 0 {
+		return nil, 0, 0, ErrNoRecoveryVolumes
+	}
+	sort.Slice(revs, func(i, j int) bool { return revs[i].num < revs[j].num })
+	// drop duplicates of the same recovery volume number
+	n := 1
+	for _, r := range revs[1:] {
+		if r.num != revs[n-1].num {
+			revs[n] = r
+			n++
+		}
+	}
+	return revs[:n], recs, files, nil
+}
+
+// gfSolve returns the matrix c so that for each erased codeword position e,
+// symbol e equals the sum over the known positions k of c[e][k] times symbol k.
+// Position j of a codeword of length n holds the coefficient of x^(n-1-j),
+// and the codeword has roots 2^1 ... 2^len(erased).
+func gfSolve(n int, erased, known []int) [][]byte {
+	m := len(erased)
+	// a * erased symbols = s * known symbols, one row per root
+	a := make([][]byte, m)
+	s := make([][]byte, m)
+	for i := range a {
+		a[i] = make([]byte, m)
+		s[i] = make([]byte, len(known))
+		for j, e := range erased {
+			a[i][j] = gfPow((n - 1 - e) * (i + 1))
+		}
+		for j, k := range known {
+			s[i][j] = gfPow((n - 1 - k) * (i + 1))
+		}
+	}
+	// Gauss-Jordan elimination, applying the same row operations to s
+	for col := 0; col < m; col++ {
+		p := col
+		for a[p][col] == 0 {
+			p++
+		}
+		a[col], a[p] = a[p], a[col]
+		s[col], s[p] = s[p], s[col]
+		inv := gfInv(a[col][col])
+		for j := range a[col] {
+			a[col][j] = gfMul(a[col][j], inv)
+		}
+		for j := range s[col] {
+			s[col][j] = gfMul(s[col][j], inv)
+		}
+		for r := 0; r < m; r++ {
+			if f := a[r][col]; r != col && f != 0 {
+				for j := range a[r] {
+					a[r][j] ^= gfMul(f, a[col][j])
+				}
+				for j := range s[r] {
+					s[r][j] ^= gfMul(f, s[col][j])
+				}
+			}
+		}
+	}
+	return s
+}
+
+// truncateAtEnd cuts a rebuilt volume after its end of archive block. The
+// recovery data covers the largest volume, so smaller ones are rebuilt with
+// trailing zeros.
+func truncateAtEnd(name string) error {
+	f, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	fr, err := newFileBlockReader(br, "")
+	if err != nil {
+		return err
+	}
+	a, ok := fr.(*archive15)
+	if !ok {
+		return nil
+	}
+	for {
+		h, err := a.readBlockHeader()
+		if err != nil {
+			return nil // no end block, keep the whole volume
+		}
+		off, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		off -= int64(br.Buffered())
+		if h.htype == blockEnd {
+			return f.Truncate(off + h.dataSize)
+		}
+		if h.htype == blockArc && h.flags&arcEncrypted > 0 {
+			return nil
+		}
+		if _, err = f.Seek(off+h.dataSize, io.SeekStart); err != nil {
+			return err
+		}
+		br.Reset(f)
+	}
+}
+
+// RebuildVolumes rebuilds the missing volumes of the archive whose first
+// volume is first, and the damaged ones, from the recovery volumes (.rev
+// files) next to it. It returns the names of the rebuilt volumes, and
+// ErrTooDamaged if there are more missing or damaged volumes than recovery
+// volumes. Each volume is rebuilt into a copy, which replaces the original
+// only once it checks out; the original is left as it was otherwise.
+//
+// RAR 5.0 recovery volumes store the size and CRC32 of every data volume.
+// Volumes that don't match them are rebuilt, damaged is ignored, and a
+// rebuilt volume must match them. The data volumes split into 16 bit words
+// and the recovery volumes form a Reed-Solomon code over GF(2^16), as in
+// unrar's recvol5.
+//
+// RAR 3.x recovery volumes store no checksums of the data volumes, so the
+// damaged ones must be listed in damaged, and every missing or damaged
+// recovery volume counts against the number of recovery volumes as well.
+// Byte i of every data volume, zero padded, followed by byte i of every
+// recovery volume forms a Reed-Solomon codeword over GF(2^8), as in unrar's
+// recvol3. A rebuilt volume is cut after its end block and its block header
+// CRCs must check out.
+func RebuildVolumes(first string, damaged []string) ([]string, error) {
+	set, _, ok := VolumeInfo(first)
+	if !ok {
+		return nil, errNoSig
+	}
+	old := strings.EqualFold(filepath.Base(first), filepath.Base(set)+".rar")
+	revNames, err := revVolumeNames(set)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range revNames {
+		if isRev5(name) {
+			return rebuildVolumes5(first, old, revNames)
+		}
+	}
+	revs, recs, files, err := findRevVolumes(revNames)
+	if err != nil {
+		return nil, err
+	}
+	size := revs[0].size
+	bad := map[string]bool{}
+	for _, name := range damaged {
+		bad[filepath.Clean(name)] = true
+	}
+
+	// codeword positions: data volumes then recovery volumes
+	n := files + recs
+	names := make([]string, n)
+	names[0] = first
+	for i := 1; i < files; i++ {
+		names[i] = NextVolumeName(names[i-1], old)
+	}
+	for _, r := range revs {
+		names[files+r.num] = r.name
+	}
+	var erased, known []int
+	for i, name := range names {
+		missing := name == "" || bad[filepath.Clean(name)]
+		if !missing && i < files {
+			_, err := os.Stat(name)
+			missing = os.IsNotExist(err)
+		}
+		if missing {
+			erased = append(erased, i)
+		} else {
+			known = append(known, i)
+		}
+	}
+	if len(erased) > recs {
+		return nil, ErrTooDamaged
+	}
+	var rebuilt []int
+	for _, e := range erased {
+		if e < files {
+			rebuilt = append(rebuilt, e)
+		}
+	}
+	if len(rebuilt) == 0 {
+		return nil, nil
+	}
+	c := gfSolve(n, erased, known)
+
+	in := make([]*os.File, len(known))
+	defer func() {
+		for _, f := range in {
+			if f != nil {
+				f.Close()
+			}
+		}
+	}()
+	for i, k := range known {
+		if in[i], err = os.Open(names[k]); err != nil {
+			return nil, err
+		}
+	}
+	out := make([]*os.File, len(rebuilt))
+	defer func() {
+		for _, f := range out {
+			if f != nil {
+				f.Close()
+				os.Remove(f.Name())
+			}
+		}
+	}()
+	for i, e := range rebuilt {
+		if out[i], err = os.Create(names[e] + ".tmp"); err != nil {
+			return nil, err
+		}
+	}
+
+	src := make([][]byte, len(known))
+	for i := range src {
+		src[i] = make([]byte, revChunkSize)
+	}
+	dst := make([]byte, revChunkSize)
+	for off := int64(0); off < size; off += revChunkSize {
+		l := size - off
+		if l > revChunkSize {
+			l = revChunkSize
+		}
+		for i, f := range in {
+			p := src[i][:l]
+			m, err := f.ReadAt(p, off)
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+			for j := m; j < len(p); j++ {
+				p[j] = 0
+			}
+		}
+		for i, e := range rebuilt {
+			row := c[indexOf(erased, e)]
+			p := dst[:l]
+			for j := range p {
+				p[j] = 0
+			}
+			for k, s := range src {
+				if row[k] == 0 {
+					continue
+				}
+				lc := gfLog[row[k]]
+				for j, v := range s[:l] {
+					if v != 0 {
+						p[j] ^= gfExp[gfLog[v]+lc]
+					}
+				}
+			}
+			if _, err = out[i].Write(p); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var done []string
+	for i, e := range rebuilt {
+		f := out[i]
+		out[i] = nil
+		if err = f.Close(); err == nil {
+			err = truncateAtEnd(f.Name())
+		}
+		if err == nil {
+			err = replaceVolume(f.Name(), names[e], checkHeaders)
+		} else {
+			os.Remove(f.Name())
+		}
+		if err != nil {
+			return done, err
+		}
+		done = append(done, names[e])
+	}
+	return done, nil
+}
+
+func indexOf(a []int, v int) int {
+	for i, x := range a {
+		if x == v {
+			return i
+		}
+	}
+	return -1
+}
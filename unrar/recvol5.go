@@ -0,0 +1,295 @@
+package rardecode
+
+import (
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+)
+
+const (
+	rev5Sign      = "Rar!\x1aRev"
+	rev5MaxHeader = 0x100000
+)
+
+// rev5Header is the header of a RAR 5.0 recovery volume, following unrar's
+// recvol5. The file starts with "Rar!\x1aRev", the CRC32 of the rest of the
+// header and the header size. The header holds a version, the number of data
+// volumes, the number of recovery volumes and this volume's number counting
+// the data volumes first, the CRC32 of the recovery data that follows the
+// header, then the size and CRC32 of every data volume.
+type rev5Header struct {
+	name  string
+	files int
+	recs  int
+	num   int      // recovery volume number, from 0
+	crc   uint32   // CRC32 of the recovery data
+	sizes []int64  // data volume sizes
+	crcs  []uint32 // data volume CRC32s
+	off   int64    // offset of the recovery data
+	size  int64    // bytes of recovery data
+}
+
+// isRev5 reports whether the file name starts with the RAR 5.0 recovery
+// volume signature.
+func isRev5(name string) bool {
+	f, err := os.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	b := make([]byte, len(rev5Sign))
+	_, err = io.ReadFull(f, b)
+	return err == nil && string(b) == rev5Sign
+}
+
+// fileCRC returns the CRC32 of the contents of f from off to the end.
+func fileCRC(f *os.File, off int64) (uint32, int64, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	h := crc32.NewIEEE()
+	n, err := io.Copy(h, io.NewSectionReader(f, off, fi.Size()-off))
+	return h.Sum32(), n, err
+}
+
+// readRev5Header reads the header of the RAR 5.0 recovery volume name and
+// checks the CRCs of the header and the recovery data.
+func readRev5Header(name string) (*rev5Header, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	b := make([]byte, len(rev5Sign)+8)
+	if _, err = io.ReadFull(f, b); err != nil {
+		return nil, err
+	}
+	if string(b[:len(rev5Sign)]) != rev5Sign {
+		return nil, errNoSig
+	}
+	rb := readBuf(b[len(rev5Sign):])
+	crc := rb.uint32()
+	size := rb.uint32()
+	if size <= 5 || size > rev5MaxHeader {
+		return nil, errCorruptHeader
+	}
+	hb := make(readBuf, size)
+	if _, err = io.ReadFull(f, hb); err != nil {
+		return nil, err
+	}
+	c := crc32.Update(crc32.ChecksumIEEE(b[len(rev5Sign)+4:]), crc32.IEEETable, hb)
+	if c != crc {
+		return nil, errBadHeaderCrc
+	}
+	h := &rev5Header{name: name, off: int64(len(b)) + int64(size)}
+	if hb.byte() != 1 {
+		return nil, errCorruptHeader
+	}
+	h.files = int(hb.uint16())
+	h.recs = int(hb.uint16())
+	h.num = int(hb.uint16()) - h.files
+	h.crc = hb.uint32()
+	if h.files == 0 || h.num < 0 || h.num >= h.recs || len(hb) < h.files*12 {
+		return nil, errCorruptHeader
+	}
+	for i := 0; i < h.files; i++ {
+		h.sizes = append(h.sizes, int64(hb.uint64()))
+		h.crcs = append(h.crcs, hb.uint32())
+	}
+	c, h.size, err = fileCRC(f, h.off)
+	if err != nil {
+		return nil, err
+	}
+	if c != h.crc {
+		return nil, ErrBadFileChecksum
+	}
+	return h, nil
+}
+
+// findRev5Volumes returns the valid RAR 5.0 recovery volumes among names,
+// one per recovery volume number.
+func findRev5Volumes(names []string) ([]*rev5Header, error) {
+	var revs []*rev5Header
+	for _, name := range names {
+		h, err := readRev5Header(name)
+		if err != nil {
+			continue // damaged recovery volumes are treated as missing
+		}
+		if len(revs) > 0 {
+			r := revs[0]
+			if h.files != r.files || h.recs != r.recs || h.size != r.size {
+				continue // belongs to another set
+			}
+		}
+		revs = append(revs, h)
+	}
+	if len(revs) == 0 {
+		return nil, ErrNoRecoveryVolumes
+	}
+	sort.Slice(revs, func(i, j int) bool { return revs[i].num < revs[j].num })
+	n := 1
+	for _, r := range revs[1:] {
+		if r.num != revs[n-1].num {
+			revs[n] = r
+			n++
+		}
+	}
+	return revs[:n], nil
+}
+
+// checkVolume5 reports whether the volume name has the size and CRC32 stored
+// for it in a recovery volume.
+func checkVolume5(name string, size int64, crc uint32) (bool, error) {
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	c, n, err := fileCRC(f, 0)
+	return err == nil && n == size && c == crc, err
+}
+
+// rebuildVolumes5 rebuilds the volumes of the set starting at first that are
+// missing or don't match the size and CRC32 stored in the RAR 5.0 recovery
+// volumes among names. Each rebuilt volume replaces the original only once
+// its CRC32 matches.
+func rebuildVolumes5(first string, old bool, names []string) ([]string, error) {
+	revs, err := findRev5Volumes(names)
+	if err != nil {
+		return nil, err
+	}
+	h := revs[0]
+	vols := make([]string, h.files)
+	vols[0] = first
+	for i := 1; i < h.files; i++ {
+		vols[i] = NextVolumeName(vols[i-1], old)
+	}
+	var erased, valid []int
+	for i, name := range vols {
+		ok, err := checkVolume5(name, h.sizes[i], h.crcs[i])
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			valid = append(valid, i)
+		} else {
+			erased = append(erased, i)
+		}
+	}
+	if len(erased) == 0 {
+		return nil, nil
+	}
+	if len(erased) > len(revs) {
+		return nil, ErrTooDamaged
+	}
+	revs = revs[:len(erased)]
+	recs := make([]int, len(revs))
+	for i, r := range revs {
+		recs[i] = r.num
+	}
+	c := rs16Solve(h.files, erased, recs)
+
+	// inputs: the valid data volumes followed by the recovery volumes
+	in := make([]*os.File, 0, len(valid)+len(revs))
+	offs := make([]int64, 0, cap(in))
+	defer func() {
+		for _, f := range in {
+			f.Close()
+		}
+	}()
+	for _, i := range valid {
+		f, err := os.Open(vols[i])
+		if err != nil {
+			return nil, err
+		}
+		in = append(in, f)
+		offs = append(offs, 0)
+	}
+	for _, r := range revs {
+		f, err := os.Open(r.name)
+		if err != nil {
+			return nil, err
+		}
+		in = append(in, f)
+		offs = append(offs, r.off)
+	}
+	out := make([]*os.File, len(erased))
+	defer func() {
+		for _, f := range out {
+			if f != nil {
+				f.Close()
+				os.Remove(f.Name())
+			}
+		}
+	}()
+	for i, e := range erased {
+		if out[i], err = os.Create(vols[e] + ".tmp"); err != nil {
+			return nil, err
+		}
+	}
+
+	src := make([][]byte, len(in))
+	for i := range src {
+		src[i] = make([]byte, revChunkSize)
+	}
+	dst := make([]byte, revChunkSize)
+	for off := int64(0); off < h.size; off += revChunkSize {
+		l := h.size - off
+		if l > revChunkSize {
+			l = revChunkSize
+		}
+		w := l + l&1 // whole 16 bit words
+		for i, f := range in {
+			p := src[i][:w]
+			n, err := f.ReadAt(p[:l], offs[i]+off)
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+			for j := n; j < len(p); j++ {
+				p[j] = 0
+			}
+		}
+		for i := range erased {
+			p := dst[:w]
+			for j := range p {
+				p[j] = 0
+			}
+			for k, s := range src {
+				rs16Update(p, s[:w], c[i][k])
+			}
+			if _, err = out[i].Write(p[:l]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var done []string
+	for i, e := range erased {
+		f := out[i]
+		out[i] = nil
+		err = f.Truncate(h.sizes[e])
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			size, crc := h.sizes[e], h.crcs[e]
+			err = replaceVolume(f.Name(), vols[e], func(name string) error {
+				if ok, err := checkVolume5(name, size, crc); err != nil || ok {
+					return err
+				}
+				return ErrTooDamaged
+			})
+		} else {
+			os.Remove(f.Name())
+		}
+		if err != nil {
+			return done, err
+		}
+		done = append(done, vols[e])
+	}
+	return done, nil
+}
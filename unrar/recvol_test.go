@@ -0,0 +1,337 @@
+package rardecode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// rsEncode returns the parity bytes for data the way RAR 3.x RSCoder does:
+// a generator polynomial with roots 2^1 ... 2^n run through a shift register.
+func rsEncode(data []byte, n int) []byte {
+	g := make([]byte, n+1) // coefficients from x^0
+	g[0] = 1
+	for i := 1; i <= n; i++ {
+		// g *= x + 2^i
+		for j := n; j >= 0; j-- {
+			v := gfMul(g[j], gfPow(i))
+			if j > 0 {
+				v ^= g[j-1]
+			}
+			g[j] = v
+		}
+	}
+	reg := make([]byte, n)
+	for _, v := range data {
+		d := v ^ reg[n-1]
+		for j := n - 1; j > 0; j-- {
+			reg[j] = reg[j-1] ^ gfMul(g[j], d)
+		}
+		reg[0] = gfMul(g[0], d)
+	}
+	p := make([]byte, n)
+	for i := range p {
+		p[i] = reg[n-1-i]
+	}
+	return p
+}
+
+// revVolumes returns n recovery volumes for vols.
+func revVolumes(vols [][]byte, n int) [][]byte {
+	size := 0
+	for _, v := range vols {
+		if len(v) > size {
+			size = len(v)
+		}
+	}
+	revs := make([][]byte, n)
+	for i := range revs {
+		revs[i] = make([]byte, size, size+revTailSize)
+	}
+	col := make([]byte, len(vols))
+	for off := 0; off < size; off++ {
+		for i, v := range vols {
+			col[i] = 0
+			if off < len(v) {
+				col[i] = v[off]
+			}
+		}
+		for i, p := range rsEncode(col, n) {
+			revs[i][off] = p
+		}
+	}
+	for i := range revs {
+		revs[i] = append(revs[i], byte(len(vols)-1), byte(n-1), byte(i))
+		revs[i] = appendUint32(revs[i], crc32.ChecksumIEEE(revs[i]))
+	}
+	return revs
+}
+
+func TestRebuildVolumes(t *testing.T) {
+	files := []testFile{
+		{"one.txt", legacyText(3000, 4)},
+		{"two.bin", legacyWave(2500, 2)},
+		{"three.txt", legacyText(900, 5)},
+	}
+	vols := storedVolumes(files, 1500)
+	if len(vols) != 5 {
+		t.Fatalf("%d volumes", len(vols))
+	}
+	dir := filepath.Join(t.TempDir(), "[a]") // not a glob pattern
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	names := writeVolumes(t, dir, vols)
+	revs := revVolumes(vols, 3)
+	for i, r := range revs {
+		name := filepath.Join(dir, "a.part"+strconv.Itoa(i+1)+".rev")
+		if err := ioutil.WriteFile(name, r, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// nothing to do
+	if got, err := RebuildVolumes(names[0], nil); len(got) != 0 || err != nil {
+		t.Fatalf("RebuildVolumes = %v, %v", got, err)
+	}
+
+	// lose two volumes, damage the last and a recovery volume
+	for _, i := range []int{1, 3} {
+		if err := os.Remove(names[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(names[4], vols[4][:50], 0644); err != nil {
+		t.Fatal(err)
+	}
+	rev := filepath.Join(dir, "a.part2.rev")
+	if err := ioutil.WriteFile(rev, revs[1][:100], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RebuildVolumes(names[0], names[4:]); !errors.Is(err, ErrTooDamaged) {
+		t.Fatalf("RebuildVolumes = %v, want ErrTooDamaged", err)
+	}
+	if err := ioutil.WriteFile(rev, revs[1], 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := RebuildVolumes(names[0], names[4:])
+	if err != nil || len(got) != 3 {
+		t.Fatalf("RebuildVolumes = %v, %v", got, err)
+	}
+	for i, name := range names {
+		b, err := ioutil.ReadFile(name)
+		if err != nil || !bytes.Equal(b, vols[i]) {
+			t.Fatalf("volume %d: %d bytes, want %d, %v", i+1, len(b), len(vols[i]), err)
+		}
+	}
+	r, err := OpenReader(names[0], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for _, f := range files {
+		if _, err = r.Next(); err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil || !bytes.Equal(b, f.data) {
+			t.Fatalf("%s: %d bytes, %v", f.name, len(b), err)
+		}
+	}
+}
+
+func TestGFSolve(t *testing.T) {
+	data := []byte("recovery volumes")
+	code := append(append([]byte(nil), data...), rsEncode(data, 4)...)
+	erased := []int{0, 7, 17, 19}
+	var known []int
+	for i := range code {
+		if indexOf(erased, i) < 0 {
+			known = append(known, i)
+		}
+	}
+	c := gfSolve(len(code), erased, known)
+	for i, e := range erased {
+		var v byte
+		for j, k := range known {
+			v ^= gfMul(c[i][j], code[k])
+		}
+		if v != code[e] {
+			t.Errorf("symbol %d = %x, want %x", e, v, code[e])
+		}
+	}
+}
+
+// rev5Volumes returns n RAR 5.0 recovery volumes for vols, encoded the way
+// unrar's RSCoder16 does.
+func rev5Volumes(vols [][]byte, n int) [][]byte {
+	gf16Once.Do(gf16Init)
+	size := 0
+	for _, v := range vols {
+		if len(v) > size {
+			size = len(v)
+		}
+	}
+	data := make([][]byte, len(vols))
+	for i, v := range vols {
+		data[i] = make([]byte, size+size&1)
+		copy(data[i], v)
+	}
+	revs := make([][]byte, n)
+	for r := range revs {
+		p := make([]byte, size+size&1)
+		for j, d := range data {
+			rs16Update(p, d, rs16Coef(len(vols), r, j))
+		}
+		revs[r] = rev5Volume(vols, n, r, p[:size])
+	}
+	return revs
+}
+
+// rev5Volume returns recovery volume r of n for vols holding the recovery
+// data p.
+func rev5Volume(vols [][]byte, n, r int, p []byte) []byte {
+	h := []byte{1}
+	for _, v := range []int{len(vols), n, len(vols) + r} {
+		h = append(h, byte(v), byte(v>>8))
+	}
+	h = appendUint32(h, crc32.ChecksumIEEE(p))
+	for _, v := range vols {
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], uint64(len(v)))
+		h = append(h, b[:]...)
+		h = appendUint32(h, crc32.ChecksumIEEE(v))
+	}
+	b := appendUint32(nil, uint32(len(h)))
+	b = append(b, h...)
+	b = append(appendUint32([]byte(rev5Sign), crc32.ChecksumIEEE(b)), b...)
+	return append(b, p...)
+}
+
+func TestRebuildVolumes5(t *testing.T) {
+	files := []testFile{
+		{"one.txt", legacyText(3000, 4)},
+		{"two.bin", legacyWave(2500, 2)},
+		{"three.txt", legacyText(900, 5)},
+	}
+	vols := storedVolumes(files, 1500)
+	dir := t.TempDir()
+	names := writeVolumes(t, dir, vols)
+	revs := rev5Volumes(vols, 2)
+	writeRevs := func(revs [][]byte) {
+		for i, r := range revs {
+			name := filepath.Join(dir, "a.part"+strconv.Itoa(i+1)+".rev")
+			if err := ioutil.WriteFile(name, r, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	writeRevs(revs)
+	damage := func(i int) []byte {
+		b := append([]byte(nil), vols[i]...)
+		b[len(b)/2] ^= 0xff
+		if err := ioutil.WriteFile(names[i], b, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	checkFiles := func(want map[int][]byte) {
+		t.Helper()
+		for i, name := range names {
+			b, err := ioutil.ReadFile(name)
+			if w, ok := want[i]; ok {
+				if w == nil && !os.IsNotExist(err) || w != nil && !bytes.Equal(b, w) {
+					t.Fatalf("volume %d changed, %v", i+1, err)
+				}
+			} else if err != nil || !bytes.Equal(b, vols[i]) {
+				t.Fatalf("volume %d: %d bytes, want %d, %v", i+1, len(b), len(vols[i]), err)
+			}
+		}
+		if m, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(m) > 0 {
+			t.Fatalf("left behind %v", m)
+		}
+	}
+
+	if got, err := RebuildVolumes(names[0], nil); len(got) != 0 || err != nil {
+		t.Fatalf("RebuildVolumes = %v, %v", got, err)
+	}
+
+	// three bad volumes are too many for two recovery volumes
+	if err := os.Remove(names[1]); err != nil {
+		t.Fatal(err)
+	}
+	bad3, bad4 := damage(3), damage(4)
+	if _, err := RebuildVolumes(names[0], nil); !errors.Is(err, ErrTooDamaged) {
+		t.Fatalf("RebuildVolumes = %v, want ErrTooDamaged", err)
+	}
+	checkFiles(map[int][]byte{1: nil, 3: bad3, 4: bad4})
+
+	// recovery data that doesn't match the volumes must not replace them
+	if err := ioutil.WriteFile(names[4], vols[4], 0644); err != nil {
+		t.Fatal(err)
+	}
+	size := 0
+	for _, v := range vols {
+		if len(v) > size {
+			size = len(v)
+		}
+	}
+	wrong := make([][]byte, len(revs))
+	for i, r := range revs {
+		p := append([]byte(nil), r[len(r)-size:]...)
+		p[100] ^= 1
+		wrong[i] = rev5Volume(vols, len(revs), i, p)
+	}
+	writeRevs(wrong)
+	if _, err := RebuildVolumes(names[0], nil); !errors.Is(err, ErrTooDamaged) {
+		t.Fatalf("RebuildVolumes = %v, want ErrTooDamaged", err)
+	}
+	checkFiles(map[int][]byte{1: nil, 3: bad3})
+
+	// a damaged recovery volume is ignored
+	writeRevs(revs)
+	if _, err := RebuildVolumes(names[0], nil); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(nil)
+	damage(2)
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.part2.rev"), revs[1][:200], 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := RebuildVolumes(names[0], nil)
+	if err != nil || len(got) != 1 || got[0] != names[2] {
+		t.Fatalf("RebuildVolumes = %v, %v", got, err)
+	}
+	checkFiles(nil)
+}
+
+func TestRS16Solve(t *testing.T) {
+	data := [][]byte{[]byte("reco"), []byte("very"), []byte(" vol"), []byte("umes")}
+	revs := rev5Volumes(data, 3)
+	erased, recs := []int{0, 2, 3}, []int{2, 0, 1}
+	var in [][]byte
+	for j, d := range data {
+		if indexOf(erased, j) < 0 {
+			in = append(in, d)
+		}
+	}
+	for _, r := range recs {
+		in = append(in, revs[r][len(revs[r])-4:])
+	}
+	c := rs16Solve(len(data), erased, recs)
+	for i, e := range erased {
+		p := make([]byte, 4)
+		for k, s := range in {
+			rs16Update(p, s, c[i][k])
+		}
+		if !bytes.Equal(p, data[e]) {
+			t.Errorf("unit %d = %q, want %q", e, p, data[e])
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package rardecode
+
+import "sync"
+
+// Reed-Solomon coding over GF(2^16) as used by RAR 5.0 recovery volumes,
+// following unrar's RSCoder16. Data is split into 16 bit little endian words.
+// Recovery unit r of n data units is the sum over data units j of
+// gf16Inv((n+r) ^ j) times unit j, a Cauchy matrix below an identity matrix,
+// so any n valid units rebuild the others.
+
+const gf16Size = 65535
+
+var (
+	gf16Once sync.Once
+	gf16Exp  []uint16 // doubled so that a sum of two logs needs no modulo
+	gf16Log  []int
+)
+
+func gf16Init() {
+	gf16Exp = make([]uint16, 2*gf16Size)
+	gf16Log = make([]int, gf16Size+1)
+	x := 1
+	for i := 0; i < gf16Size; i++ {
+		gf16Exp[i] = uint16(x)
+		gf16Exp[i+gf16Size] = uint16(x)
+		gf16Log[x] = i
+		x <<= 1
+		if x > gf16Size {
+			x ^= 0x1100b
+		}
+	}
+}
+
+func gf16Mul(a, b uint16) uint16 {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gf16Exp[gf16Log[a]+gf16Log[b]]
+}
+
+func gf16Inv(a uint16) uint16 {
+	return gf16Exp[gf16Size-gf16Log[a]]
+}
+
+// rs16Coef returns the coefficient of data unit j in recovery unit r of a set
+// of n data units.
+func rs16Coef(n, r, j int) uint16 {
+	return gf16Inv(uint16((n + r) ^ j))
+}
+
+// rs16Solve returns the matrix c that rebuilds the erased data units of a set
+// of n data units: unit erased[i] is the sum over the inputs k of c[i][k]
+// times input k. The inputs are the valid data units in order, followed by the
+// recovery units recs, of which there must be len(erased).
+func rs16Solve(n int, erased, recs []int) [][]uint16 {
+	gf16Once.Do(gf16Init)
+	m := len(erased)
+	valid := make([]int, 0, n-m)
+	for j := 0; j < n; j++ {
+		if indexOf(erased, j) < 0 {
+			valid = append(valid, j)
+		}
+	}
+	// a * erased units = s * inputs, one row per recovery unit
+	a := make([][]uint16, m)
+	s := make([][]uint16, m)
+	for i, r := range recs {
+		a[i] = make([]uint16, m)
+		s[i] = make([]uint16, len(valid)+m)
+		for j, e := range erased {
+			a[i][j] = rs16Coef(n, r, e)
+		}
+		for j, v := range valid {
+			s[i][j] = rs16Coef(n, r, v)
+		}
+		s[i][len(valid)+i] = 1
+	}
+	// Gauss-Jordan elimination, a Cauchy matrix is always invertible
+	for col := 0; col < m; col++ {
+		p := col
+		for a[p][col] == 0 {
+			p++
+		}
+		a[col], a[p] = a[p], a[col]
+		s[col], s[p] = s[p], s[col]
+		inv := gf16Inv(a[col][col])
+		for j := range a[col] {
+			a[col][j] = gf16Mul(a[col][j], inv)
+		}
+		for j := range s[col] {
+			s[col][j] = gf16Mul(s[col][j], inv)
+		}
+		for r := 0; r < m; r++ {
+			if f := a[r][col]; r != col && f != 0 {
+				for j := range a[r] {
+					a[r][j] ^= gf16Mul(f, a[col][j])
+				}
+				for j := range s[r] {
+					s[r][j] ^= gf16Mul(f, s[col][j])
+				}
+			}
+		}
+	}
+	return s
+}
+
+// rs16Update adds c times the words of src to dst. len(src) must be even.
+func rs16Update(dst, src []byte, c uint16) {
+	if c == 0 {
+		return
+	}
+	lc := gf16Log[c]
+	for i := 0; i+1 < len(src); i += 2 {
+		if v := uint16(src[i]) | uint16(src[i+1])<<8; v != 0 {
+			p := gf16Exp[gf16Log[v]+lc]
+			dst[i] ^= byte(p)
+			dst[i+1] ^= byte(p >> 8)
+		}
+	}
+}
@@ -1,61 +1,431 @@
 package rardecode
 
 import (
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
 )
 
-type onlyWriter struct {
-	io.Writer
+var ErrUnsafePath = errors.New("rardecode: file name is absolute or outside the extraction directory")
+
+const (
+	maxLinkTarget = 4096 // maximum size of a symbolic link target stored as file data
+	maxLinks      = 40   // symbolic links followed when checking a link target
+)
+
+// OverwritePolicy decides what Unpack does with files that already exist.
+type OverwritePolicy int
+
+const (
+	OverwriteSkipSame OverwritePolicy = iota // keep existing files with the same size and checksum, replace others
+	OverwriteRename                          // extract to "name (1).ext" instead
+	OverwriteAlways                          // replace existing files
+)
+
+var overwriteNames = []string{"skip", "rename", "overwrite"}
+
+func (p OverwritePolicy) String() string {
+	if int(p) < len(overwriteNames) {
+		return overwriteNames[p]
+	}
+	return fmt.Sprintf("OverwritePolicy(%d)", int(p))
+}
+
+// Set parses a policy name, so that an OverwritePolicy can be used as a flag.Value.
+func (p *OverwritePolicy) Set(s string) error {
+	for i, n := range overwriteNames {
+		if strings.EqualFold(s, n) {
+			*p = OverwritePolicy(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("rardecode: unknown overwrite policy %q", s)
+}
+
+// SymlinkPolicy decides which symbolic links and junctions Unpack creates.
+type SymlinkPolicy int
+
+const (
+	SymlinkSkip   SymlinkPolicy = iota // don't create links
+	SymlinkInside                      // create relative links to targets inside the extraction directory
+	SymlinkAll                         // create all links as stored
+)
+
+var symlinkNames = []string{"skip", "inside", "all"}
+
+func (p SymlinkPolicy) String() string {
+	if int(p) < len(symlinkNames) {
+		return symlinkNames[p]
+	}
+	return fmt.Sprintf("SymlinkPolicy(%d)", int(p))
+}
+
+// Set parses a policy name, so that a SymlinkPolicy can be used as a flag.Value.
+func (p *SymlinkPolicy) Set(s string) error {
+	for i, n := range symlinkNames {
+		if strings.EqualFold(s, n) {
+			*p = SymlinkPolicy(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("rardecode: unknown symlink policy %q", s)
+}
+
+// UnpackOptions configures Unpack. The zero value skips existing files with the
+// same checksum and doesn't create links.
+type UnpackOptions struct {
+	Overwrite OverwritePolicy
+	Symlinks  SymlinkPolicy
+}
+
+// UnpackError is the error extracting a single file.
+type UnpackError struct {
+	Name string // file name in the archive
+	Err  error
+}
+
+func (e *UnpackError) Error() string { return e.Name + ": " + e.Err.Error() }
+func (e *UnpackError) Unwrap() error { return e.Err }
+
+// UnpackErrors lists the files Unpack failed to extract. It unwraps to the first error.
+type UnpackErrors []*UnpackError
+
+func (e UnpackErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%v (and %d more errors)", e[0], len(e)-1)
+}
+
+func (e UnpackErrors) Unwrap() error { return e[0] }
+
+// UnpackTo extracts the remaining files of the archive to baseDir with the
+// default UnpackOptions.
+func (r *ReadCloser) UnpackTo(baseDir string) error {
+	return r.Unpack(baseDir, UnpackOptions{})
 }
 
-func (r *ReadCloser) UnpackTo(baseDir string) {
-	var lastErrPos int64
+// Unpack extracts the remaining files of the archive to dir, restoring their
+// permission bits and times. Files with absolute names or names leaving dir
+// are not extracted, and nothing is written through a symbolic link.
+//
+// A file that can't be extracted doesn't stop the others, its error is
+// returned in UnpackErrors once the archive is done. Errors reading the
+// archive headers end Unpack early.
+func (r *Reader) Unpack(dir string, opts UnpackOptions) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	var errs UnpackErrors
+	var dirs []*FileHeader // directories get their modes and times after their contents
+	var dirPaths []string
 	for {
 		f, err := r.Next()
-		if err != nil {
-			if err == io.EOF {
-				break
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		p, err := safePath(dir, f.Name)
+		if err == nil {
+			err = makeDirs(dir, filepath.Dir(p))
+		}
+		if err == nil && f.IsDir {
+			if err = makeDirs(dir, p); err == nil {
+				dirs = append(dirs, f)
+				dirPaths = append(dirPaths, p)
 			}
-			if f != nil {
-				fmt.Println(f.Offset, f.Offset+f.PackedSize, err)
+		} else if err == nil {
+			err = r.unpackFile(dir, p, f, opts)
+		}
+		if err != nil {
+			errs = append(errs, &UnpackError{f.Name, err})
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Chmod(dirPaths[i], perm(dirs[i]))
+		setTimes(dirPaths[i], dirs[i])
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// safePath returns the path of the archived file name inside dir. Backslashes
+// are treated as separators as they are on Windows.
+func safePath(dir, name string) (string, error) {
+	name = path.Clean(strings.ReplaceAll(name, "\\", "/"))
+	if path.IsAbs(name) || (len(name) > 1 && name[1] == ':') ||
+		name == "." || name == ".." || strings.HasPrefix(name, "../") {
+		return "", ErrUnsafePath
+	}
+	return filepath.Join(dir, filepath.FromSlash(name)), nil
+}
+
+// inside reports whether the link at p with target stays inside dir. The
+// target is resolved through the links already extracted, so that b -> .
+// followed by a -> b/.. is caught.
+func inside(dir, p, target string) bool {
+	_, ok := resolveInside(filepath.Clean(dir), filepath.Dir(p), target, 0)
+	return ok
+}
+
+// resolveInside follows target from the directory cur inside dir, reading
+// any symbolic links on the way, and returns where it ends. It reports false
+// if the path leaves dir, or goes up from something that isn't a directory
+// yet and could still be extracted as a link.
+func resolveInside(dir, cur, target string, links int) (string, bool) {
+	if filepath.IsAbs(target) || filepath.VolumeName(target) != "" || links > maxLinks {
+		return "", false
+	}
+	for _, elem := range strings.Split(target, string(filepath.Separator)) {
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			fi, err := os.Lstat(cur)
+			if cur == dir || err != nil || !fi.IsDir() {
+				return "", false
 			}
+			cur = filepath.Dir(cur)
 			continue
 		}
-		if lastErrPos != 0 {
-			fmt.Println("Error at", lastErrPos, f.Offset, f.VolName)
-		}
-		if f.IsDir {
+		next := filepath.Join(cur, elem)
+		fi, err := os.Lstat(next)
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			cur = next
 			continue
 		}
-		fmt.Print(f.Name, " ", f.VolName, " ", f.Offset, " ", f.Offset+f.PackedSize)
-		f.Name = baseDir + f.Name
-		dir, _ := path.Split(f.Name)
-		if _, err := os.Stat(dir); err != nil {
-			err = os.MkdirAll(dir, 0755)
+		t, err := os.Readlink(next)
+		if err != nil {
+			return "", false
+		}
+		var ok bool
+		if cur, ok = resolveInside(dir, cur, t, links+1); !ok {
+			return "", false
+		}
+	}
+	return cur, true
+}
+
+// throughLink reports whether a path element of p below dir is a symbolic
+// link.
+func throughLink(dir, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	if err != nil {
+		return true
+	}
+	cur := dir
+	for _, elem := range strings.Split(rel, string(filepath.Separator)) {
+		cur = filepath.Join(cur, elem)
+		fi, err := os.Lstat(cur)
+		if err != nil {
+			return false
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// makeDirs creates the directory p inside dir and any missing parents. It fails
+// if a path element below dir exists but isn't a directory, so that nothing is
+// created through a symbolic link.
+func makeDirs(dir, p string) error {
+	rel, err := filepath.Rel(dir, p)
+	if err != nil {
+		return err
+	}
+	if rel == "." {
+		return nil
+	}
+	cur := dir
+	for _, elem := range strings.Split(rel, string(filepath.Separator)) {
+		cur = filepath.Join(cur, elem)
+		fi, err := os.Lstat(cur)
+		if os.IsNotExist(err) {
+			err = os.Mkdir(cur, 0755)
+		} else if err == nil && !fi.IsDir() {
+			err = &os.PathError{Op: "mkdir", Path: cur, Err: errors.New("not a directory")}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uniqueName returns p with " (n)" added before the extension so that no
+// file by that name exists.
+func uniqueName(p string) string {
+	ext := filepath.Ext(p)
+	base := strings.TrimSuffix(p, ext)
+	for i := 1; ; i++ {
+		n := fmt.Sprintf("%s (%d)%s", base, i, ext)
+		if _, err := os.Lstat(n); os.IsNotExist(err) {
+			return n
+		}
+	}
+}
+
+// isLink reports whether f is a symbolic link or junction, the target stored
+// in RedirTarget or else as the file data.
+func isLink(f *FileHeader) bool {
+	switch f.Redir {
+	case RedirUnixSymlink, RedirWinSymlink, RedirWinJunction:
+		return true
+	case 0:
+		return f.Mode()&os.ModeSymlink != 0
+	}
+	return false
+}
+
+// unpackFile extracts the current file, a link or a regular file, to p.
+func (r *Reader) unpackFile(dir, p string, f *FileHeader, opts UnpackOptions) error {
+	var target string
+	switch {
+	case isLink(f):
+		if opts.Symlinks == SymlinkSkip {
+			return nil
+		}
+		target = f.RedirTarget
+		if f.Redir == 0 {
+			b, err := ioutil.ReadAll(io.LimitReader(r, maxLinkTarget))
 			if err != nil {
-				log.Println(err)
-				continue
+				return err
 			}
+			target = string(b)
 		}
-		stat, err := os.Stat(f.Name)
-		if err == nil && stat.Size() == f.UnPackedSize {
-			os.Stdout.WriteString(" skipped \r")
-			os.Chtimes(f.Name, f.AccessTime, f.ModificationTime)
-			continue
+		if f.Redir == RedirWinSymlink || f.Redir == RedirWinJunction {
+			target = strings.ReplaceAll(strings.TrimPrefix(target, `\??\`), `\`, "/")
+		}
+		target = filepath.FromSlash(target)
+		if opts.Symlinks == SymlinkInside && !inside(dir, p, target) {
+			return nil
 		}
-		file, err := os.OpenFile(f.Name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
-		_, err = io.Copy(onlyWriter{file}, r)
-		file.Close()
+	case f.Redir == RedirHardLink || f.Redir == RedirFileCopy:
+		src, err := safePath(dir, f.RedirTarget)
 		if err != nil {
-			os.Stdout.WriteString(" ")
-			os.Stdout.WriteString(err.Error())
-			os.Remove(f.Name)
+			return err
+		}
+		if throughLink(dir, src) {
+			// a link could lead the copy or hard link outside dir
+			return ErrUnsafePath
 		}
-		os.Stdout.WriteString(" \n")
-		os.Chtimes(f.Name, f.AccessTime, f.ModificationTime)
+		target = src
+	}
+
+	fi, err := os.Lstat(p)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return err
+	case fi.IsDir():
+		return &os.PathError{Op: "open", Path: p, Err: errors.New("is a directory")}
+	case opts.Overwrite == OverwriteRename:
+		p = uniqueName(p)
+	case opts.Overwrite == OverwriteSkipSame && f.Redir == 0 && !isLink(f) &&
+		fi.Mode().IsRegular() && fi.Size() == f.UnPackedSize && r.sameFile(p):
+		return restore(p, f)
+	default:
+		if err = os.Remove(p); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case isLink(f):
+		return os.Symlink(target, p)
+	case f.Redir == RedirHardLink:
+		return os.Link(target, p)
+	case f.Redir == RedirFileCopy:
+		src, err := os.Open(target)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		return writeFile(p, f, src)
+	}
+	return writeFile(p, f, r)
+}
+
+// sameFile reports whether the file at p has the checksum of the current file.
+// Files spanning volumes only have the checksum of the whole file in their
+// last block and are reported as different.
+func (r *Reader) sameFile(p string) bool {
+	if r.cksum == nil || r.pr.h == nil || !r.pr.h.first || !r.pr.h.last {
+		return false
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	_, err = io.Copy(r.cksum, f)
+	same := err == nil && r.cksum.valid()
+	r.cksum.Reset() // the file contents are checked again if they are read
+	return same
+}
+
+// writeFile creates p with the contents of src, removing it again if src
+// fails, and restores the mode and times of f.
+func writeFile(p string, f *FileHeader, src io.Reader) error {
+	w, err := os.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, src)
+	if cErr := w.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		os.Remove(p)
+		return err
+	}
+	return restore(p, f)
+}
+
+// perm returns the permission bits to restore for f. Archives made on DOS,
+// OS/2 and Windows only have a read-only attribute, their files get 0644 or
+// 0755 for directories without write permission if it is set.
+func perm(f *FileHeader) os.FileMode {
+	switch f.HostOS {
+	case HostOSMSDOS, HostOSOS2, HostOSWindows:
+	default:
+		return f.Mode().Perm()
+	}
+	m := os.FileMode(0644)
+	if f.IsDir {
+		m = 0755
+	}
+	if f.Attributes&1 != 0 {
+		m &^= 0222
+	}
+	return m
+}
+
+// restore sets the permission bits and times of f on p.
+func restore(p string, f *FileHeader) error {
+	if err := os.Chmod(p, perm(f)); err != nil {
+		return err
+	}
+	return setTimes(p, f)
+}
+
+func setTimes(p string, f *FileHeader) error {
+	if f.ModificationTime.IsZero() {
+		return nil
+	}
+	atime := f.AccessTime
+	if atime.IsZero() {
+		atime = f.ModificationTime
 	}
+	return os.Chtimes(p, atime, f.ModificationTime)
 }
@@ -0,0 +1,190 @@
+package rardecode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// unixBlock returns a stored file block made on unix with the given mode
+// attributes, modified 2020-05-06 07:08:10.
+func unixBlock(name string, attrs uint32, data []byte) []byte {
+	b := make([]byte, 25, 25+len(name))
+	binary.LittleEndian.PutUint32(b, uint32(len(data)))
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(data)))
+	b[8] = HostOSUnix - 1
+	binary.LittleEndian.PutUint32(b[9:], crc32.ChecksumIEEE(data))
+	binary.LittleEndian.PutUint32(b[13:], 40<<25|5<<21|6<<16|7<<11|8<<5|5)
+	b[17] = 29
+	b[18] = 0x30
+	binary.LittleEndian.PutUint16(b[19:], uint16(len(name)))
+	binary.LittleEndian.PutUint32(b[21:], attrs)
+	b = append(b, name...)
+	return append(block(blockFile, 0x8000, b), data...)
+}
+
+func unpackArchive(t *testing.T, dir string, opts UnpackOptions, blocks ...[]byte) error {
+	var b bytes.Buffer
+	b.WriteString(sigPrefix + "\x00")
+	b.Write(block(blockArc, 0, make([]byte, 6)))
+	for _, bl := range blocks {
+		b.Write(bl)
+	}
+	b.Write(block(blockEnd, 0, nil))
+	name := filepath.Join(t.TempDir(), "a.rar")
+	if err := ioutil.WriteFile(name, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rc, err := OpenReader(name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	return rc.Unpack(dir, opts)
+}
+
+func TestUnpack(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "out")
+	blocks := [][]byte{
+		unixBlock("a.txt", 0100640, []byte("hello")),
+		unixBlock("sub/b.txt", 0100600, []byte("world")),
+		unixBlock("../evil.txt", 0100644, []byte("x")),
+		unixBlock("/abs.txt", 0100644, []byte("x")),
+		unixBlock("link", 0120777, []byte("a.txt")),
+		unixBlock("escape", 0120777, []byte("../../etc")),
+	}
+	err := unpackArchive(t, dir, UnpackOptions{Symlinks: SymlinkInside}, blocks...)
+	var errs UnpackErrors
+	if !errors.As(err, &errs) || len(errs) != 2 || !errors.Is(errs[0], ErrUnsafePath) || !errors.Is(errs[1], ErrUnsafePath) {
+		t.Fatalf("Unpack = %v, want two unsafe paths", err)
+	}
+	if _, err = os.Lstat(filepath.Join(root, "evil.txt")); err == nil {
+		t.Error("file written outside the directory")
+	}
+	fi, err := os.Stat(filepath.Join(dir, "a.txt"))
+	if err != nil || fi.Mode().Perm() != 0640 || fi.ModTime().Year() != 2020 {
+		t.Fatalf("a.txt: %v %v", fi, err)
+	}
+	if target, err := os.Readlink(filepath.Join(dir, "link")); err != nil || target != "a.txt" {
+		t.Errorf("link to %q, %v", target, err)
+	}
+	if _, err = os.Lstat(filepath.Join(dir, "escape")); err == nil {
+		t.Error("link leaving the directory created")
+	}
+
+	// 相同的文件保留，内容不同的替换
+	blocks[1] = unixBlock("sub/b.txt", 0100600, []byte("WORLD"))
+	if err = unpackArchive(t, dir, UnpackOptions{}, blocks[:2]...); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := os.Stat(filepath.Join(dir, "a.txt")); !os.SameFile(fi, after) {
+		t.Error("a.txt with the same checksum was replaced")
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(dir, "sub", "b.txt")); string(b) != "WORLD" {
+		t.Errorf("sub/b.txt = %q", b)
+	}
+	if err = unpackArchive(t, dir, UnpackOptions{Overwrite: OverwriteRename}, blocks[0]); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(dir, "a (1).txt")); string(b) != "hello" {
+		t.Errorf("renamed file = %q", b)
+	}
+
+	// 不通过目录中已有的符号链接写入
+	outside := filepath.Join(root, "outside")
+	os.Mkdir(outside, 0755)
+	os.RemoveAll(filepath.Join(dir, "sub"))
+	os.Symlink(outside, filepath.Join(dir, "sub"))
+	if err = unpackArchive(t, dir, UnpackOptions{}, blocks[1]); err == nil {
+		t.Error("extracted through a symbolic link")
+	}
+	if _, err = os.Lstat(filepath.Join(outside, "b.txt")); err == nil {
+		t.Error("file written through a symbolic link")
+	}
+}
+
+func TestUnpackBadChecksum(t *testing.T) {
+	dir := t.TempDir()
+	bad := unixBlock("bad.txt", 0100644, []byte("hello"))
+	bad[len(bad)-1] ^= 0xff
+	err := unpackArchive(t, dir, UnpackOptions{}, bad, unixBlock("good.txt", 0100644, []byte("fine")))
+	if !errors.Is(err, ErrBadFileChecksum) {
+		t.Fatalf("Unpack = %v, want bad checksum", err)
+	}
+	if _, err = os.Lstat(filepath.Join(dir, "bad.txt")); err == nil {
+		t.Error("damaged file left behind")
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(dir, "good.txt")); string(b) != "fine" {
+		t.Errorf("good.txt = %q after an earlier error", b)
+	}
+}
+
+// redir50 returns a RAR 5 file block redirecting name to target.
+func redir50(name string, redir uint64, target string) []byte {
+	fields := vints(0, 0, 0o777, 0, 1) // flags, size, attributes, method, unix
+	fields = append(fields, str50(name)...)
+	extra := record50(5, append(vints(redir, 0), str50(target)...))
+	return block50(block5File, 0, fields, extra, 0)
+}
+
+func TestUnpackLinkChain(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "out")
+	if err := ioutil.WriteFile(filepath.Join(root, "secret"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	unpack := func(blocks ...[]byte) error {
+		b := []byte(sigPrefix + "\x01\x00")
+		b = append(b, block50(block5Arc, 0, vints(0), nil, 0)...)
+		for _, bl := range blocks {
+			b = append(b, bl...)
+		}
+		b = append(b, block50(block5End, 0, vints(0), nil, 0)...)
+		r, err := NewReader(bytes.NewReader(b), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r.Unpack(dir, UnpackOptions{Symlinks: SymlinkInside})
+	}
+
+	// b -> . is inside, a -> b/.. is the parent of dir
+	if err := unpack(
+		redir50("b", RedirUnixSymlink, "."),
+		redir50("a", RedirUnixSymlink, "b/.."),
+		redir50("sub/c", RedirUnixSymlink, "../b/x/../.."),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if target, err := os.Readlink(filepath.Join(dir, "b")); err != nil || target != "." {
+		t.Fatalf("b links to %q, %v", target, err)
+	}
+	for _, name := range []string{"a", "sub/c"} {
+		if _, err := os.Lstat(filepath.Join(dir, name)); err == nil {
+			t.Errorf("%s: link leaving the directory created", name)
+		}
+	}
+
+	// copies and hard links don't follow links, even ones already there
+	if err := os.Symlink("..", filepath.Join(dir, "a")); err != nil {
+		t.Fatal(err)
+	}
+	err := unpack(
+		redir50("copy", RedirFileCopy, "a/secret"),
+		redir50("hard", RedirHardLink, "a/secret"),
+	)
+	var errs UnpackErrors
+	if !errors.As(err, &errs) || len(errs) != 2 || !errors.Is(errs[0], ErrUnsafePath) || !errors.Is(errs[1], ErrUnsafePath) {
+		t.Fatalf("Unpack = %v, want two unsafe paths", err)
+	}
+	for _, name := range []string{"copy", "hard"} {
+		if _, err := os.Lstat(filepath.Join(dir, name)); err == nil {
+			t.Errorf("%s: created from outside the directory", name)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"downloader/engine"
+	"downloader/pipeline"
+
+	rar "github.com/nwaples/rardecode"
+)
+
+// volumeSet 同一个分卷压缩包的全部分卷，按分卷号排序
+type volumeSet struct {
+	name  string
+	parts []*task
+	done  int
+	fresh int // 本次运行中下载完成的分卷数
+	job   *pipeline.Job
+	sync.Mutex
+
+	stream chan struct{} // 边下边解压结束时关闭，未启用时为 nil
+	output string        // 边下边解压成功时的解压目录
+}
+
+// groupVolumes 按 rar 分卷命名规则把队列中的任务归入分卷集合
+func groupVolumes(queue []*task) {
+	sets := make(map[string]*volumeSet)
+	for _, t := range queue {
+		set, num, ok := rar.VolumeInfo(filepath.Base(t.Filename()))
+		if !ok {
+			continue
+		}
+		first, _ := rar.FirstVolumeName(filepath.Base(t.Filename()))
+		key := filepath.Join(filepath.Dir(t.Filename()), first)
+		s := sets[key]
+		if s == nil {
+			s = &volumeSet{name: set}
+			sets[key] = s
+		}
+		t.set = s
+		t.vol = num
+		s.parts = append(s.parts, t)
+	}
+	for _, s := range sets {
+		sort.Slice(s.parts, func(i, j int) bool { return s.parts[i].vol < s.parts[j].vol })
+		if len(s.parts) > 1 {
+			log.Printf("分卷集合 %s 共 %d 个分卷", s.name, len(s.parts))
+		}
+	}
+}
+
+// partDone 记录一个分卷完成，fresh 表示本次运行下载的而不是之前已存在的。
+// 全部完成且至少有一个是本次下载的，执行后续流程
+func (s *volumeSet) partDone(t *task, fresh bool) {
+	s.Lock()
+	if t.finished {
+		s.Unlock()
+		return
+	}
+	t.finished = true
+	t.length = t.Length()
+	if t.length == 0 {
+		if st, err := os.Stat(t.Filename()); err == nil {
+			t.length = st.Size()
+		}
+	}
+	s.done++
+	if fresh {
+		s.fresh++
+	}
+	done := s.done
+	last := done == len(s.parts) && s.fresh > 0
+	s.Unlock()
+	if len(s.parts) > 1 {
+		log.Printf("分卷集合 %s 进度 %d/%d", s.name, done, len(s.parts))
+	}
+	if last {
+		wg.Add(1)
+		go func() {
+			s.complete()
+			wg.Done()
+		}()
+	}
+}
+
+// progress 返回已完成的字节数和已知的总字节数，未开始的分卷长度未知不计入
+func (s *volumeSet) progress() (done, total int64) {
+	for _, t := range s.parts {
+		if t.finished {
+			done += t.length
+			total += t.length
+			continue
+		}
+		if l := t.Length(); l > 0 {
+			done += l - t.Pending()
+			total += l
+		}
+	}
+	return
+}
+
+func (s *volumeSet) complete() {
+	for i, t := range s.parts {
+		if t.vol != i {
+			log.Printf("分卷集合 %s 缺少第 %d 个分卷，跳过后续处理", s.name, i+1)
+			return
+		}
+	}
+	if s.stream != nil {
+		<-s.stream // 全部分卷已下载，解压不会再等待
+	}
+	s.job = runPost(s.name, s.parts, s.output)
+}
+
+// startStreams 对有未下载完成分卷的集合开始边下载边解压
+func startStreams(queue []*task) {
+	started := make(map[*volumeSet]bool)
+	for _, t := range queue {
+		s := t.set
+		if s == nil || started[s] || s.parts[0].vol != 0 {
+			continue
+		}
+		started[s] = true
+		for _, p := range s.parts {
+			if !engine.Finished(p.Filename()) {
+				s.startStream()
+				break
+			}
+		}
+	}
+}
+
+// startStream 在后台解压集合，读到未下载的范围时等待，并让下载优先该位置。
+// 只使用 -password 的密码，失败时在下载完成后由 extract 步骤重新解压
+func (s *volumeSet) startStream() {
+	s.stream = make(chan struct{})
+	job := &pipeline.Job{Name: s.name, Files: []string{s.parts[0].Filename()}, Password: post.Password}
+	go func() {
+		defer close(s.stream)
+		ex := pipeline.Extract{Layout: post.Extract, Options: post.Unpack}
+		if err := ex.Stream(job, s.open); err != nil {
+			log.Printf("分卷集合 %s 边下边解压失败，下载完成后重新解压：%v", s.name, err)
+			return
+		}
+		s.Lock()
+		s.output = job.Output
+		s.Unlock()
+		log.Printf("分卷集合 %s 已边下边解压到 %s", s.name, job.Output)
+	}()
+}
+
+// open 按文件名打开集合中的分卷，返回读取下载中文件的 TaskReader
+func (s *volumeSet) open(name string) (rar.VolumeFile, error) {
+	base := filepath.Base(name)
+	for _, t := range s.parts {
+		if filepath.Base(t.Filename()) == base {
+			return t.NewReader(), nil
+		}
+	}
+	return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+}
+
+// finish 任务下载完成，分卷交给所属集合，其他文件直接执行后续流程
+func (t *task) finish() {
+	if t.set != nil {
+		t.set.partDone(t, true)
+		return
+	}
+	wg.Add(1)
+	go func() {
+		t.job = runPost(t.Name(), []*task{t}, "")
+		wg.Done()
+	}()
+}
+
+// runPost 对下载完成的文件执行 -post 流程，失败原因记录在返回的 Job 中。
+// output 为边下边解压的目录，不为空时 extract 步骤跳过
+func runPost(name string, parts []*task, output string) *pipeline.Job {
+	job := &pipeline.Job{Name: name, Password: post.Password, Output: output, Extracted: output != ""}
+	for _, t := range parts {
+		job.Files = append(job.Files, t.Filename())
+		job.Sizes = append(job.Sizes, t.Length())
+		job.Pages = append(job.Pages, t.WebURL())
+	}
+	postFlow.Run(job)
+	return job
+}
+
+// damaged 返回 repair 步骤发现损坏、已在 .stat 中标记了需要重新下载范围的任务
+func damaged(queue []*task) (out []*task) {
+	for _, t := range queue {
+		job := t.job
+		if t.set != nil {
+			job = t.set.job
+		}
+		if job == nil || len(job.Damaged) == 0 || engine.Finished(t.Filename()) {
+			continue
+		}
+		out = append(out, t)
+	}
+	return
+}
+
+// requeue 把 t 加入当前 Session 重新下载，新任务替换队列和分卷集合中的 t
+func requeue(queue []*task, t *task) []*task {
+	nt := &task{DownloadTask: sess.Add(t.WebURL()), set: t.set, vol: t.vol}
+	nt.Tag = nt
+	for i := range queue {
+		if queue[i] == t {
+			queue[i] = nt
+		}
+	}
+	if s := t.set; s != nil {
+		s.Lock()
+		for i := range s.parts {
+			if s.parts[i] == t {
+				s.parts[i] = nt
+			}
+		}
+		s.done--
+		s.fresh = 0
+		s.job = nil
+		s.output = "" // 重新下载后再解压
+		s.Unlock()
+	}
+	return queue
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"sync"
+	"testing"
+
+	"downloader/engine"
+)
+
+// 两个分卷同时完成，go test -race 能发现对 fresh 的无锁读写
+func TestPartDoneConcurrent(t *testing.T) {
+	s := &volumeSet{name: "test"}
+	for _, vol := range []int{0, 2} { // 缺少第 2 个分卷，complete 直接返回
+		s.parts = append(s.parts, &task{DownloadTask: &engine.DownloadTask{}, set: s, vol: vol})
+	}
+	var start sync.WaitGroup
+	start.Add(1)
+	var parts sync.WaitGroup
+	for _, p := range s.parts {
+		parts.Add(1)
+		go func(p *task) {
+			defer parts.Done()
+			start.Wait()
+			s.partDone(p, true)
+		}(p)
+	}
+	start.Done()
+	parts.Wait()
+	wg.Wait()
+	if s.done != 2 || s.fresh != 2 {
+		t.Fatalf("done %d fresh %d, want 2 2", s.done, s.fresh)
+	}
+}